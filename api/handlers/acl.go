@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"blockhouse/audit"
+	"blockhouse/auth"
+	"blockhouse/models"
+	"blockhouse/registry"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// GrantRequest represents the request body for granting a principal a role on a stream
+type GrantRequest struct {
+	Principal string `json:"principal"` // Principal ID: key ID, "jwt:<subject>" or "cert:<identity>"
+	Role      string `json:"role"`      // producer, consumer or owner
+}
+
+// ACLResponse represents a stream's owner and the roles granted on it
+type ACLResponse struct {
+	StreamID string         `json:"stream_id"`
+	Owner    string         `json:"owner"`
+	Grants   []models.Grant `json:"grants"`
+}
+
+// requireStreamAccess checks that one of the principal's roles on the stream allows action,
+// writing a 403 response with the reason otherwise. Another tenant's stream gets a 404, as if
+// it did not exist. It reports whether the caller may continue.
+func requireStreamAccess(w http.ResponseWriter, r *http.Request, principal auth.Principal, stream models.Stream, action string) bool {
+	if err := auth.CheckStreamAccess(principal, stream, action); err != nil {
+		log.Printf("Denied %s on stream %s: %v", action, stream.ID, err)
+		record(r, principal, audit.Event{Type: audit.EventAccessDenied, Outcome: audit.OutcomeDenied, Resource: stream.ID, Reason: err.Error()})
+		if errors.Is(err, auth.ErrOtherTenant) {
+			http.Error(w, "Stream not found", http.StatusNotFound)
+			return false
+		}
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// writeACL encodes a stream's ACL as the JSON response body with the given status
+func writeACL(w http.ResponseWriter, status int, stream models.Stream) {
+	response := ACLResponse{StreamID: stream.ID, Owner: stream.Owner, Grants: stream.ACL}
+	if response.Grants == nil {
+		response.Grants = []models.Grant{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding ACL response for stream %s: %v", stream.ID, err)
+	}
+}
+
+// GetACL returns a stream's owner and the roles granted on it
+func GetACL(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeStreamRead)
+	if !ok {
+		return
+	}
+
+	stream, ok := lookupStream(w, mux.Vars(r)["stream_id"])
+	if !ok || !requireStreamAccess(w, r, principal, stream, auth.ActionView) {
+		return
+	}
+	writeACL(w, http.StatusOK, stream)
+}
+
+// GrantAccess gives another principal a role on a stream
+func GrantAccess(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeStreamCreate)
+	if !ok {
+		return
+	}
+
+	streamID := mux.Vars(r)["stream_id"]
+	stream, ok := lookupStream(w, streamID)
+	if !ok || !requireStreamAccess(w, r, principal, stream, auth.ActionManage) {
+		return
+	}
+
+	var req GrantRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil || req.Principal == "" {
+		http.Error(w, "Invalid grant: a principal and role are required", http.StatusBadRequest)
+		return
+	}
+	if !auth.GrantableRole(req.Role) {
+		http.Error(w, "Invalid grant: role must be producer, consumer or owner", http.StatusBadRequest)
+		return
+	}
+
+	stream, err := registry.Default().AddGrant(streamID, models.Grant{
+		Principal: req.Principal,
+		Role:      req.Role,
+		GrantedBy: principal.ID,
+		GrantedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		if errors.Is(err, registry.ErrStreamNotFound) {
+			http.Error(w, "Stream not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to update stream ACL", http.StatusInternalServerError)
+		log.Printf("Error granting %s on stream %s: %v", req.Role, streamID, err)
+		return
+	}
+
+	log.Printf("Granted %s on stream %s to %s", req.Role, stream.ID, req.Principal)
+	record(r, principal, audit.Event{Type: audit.EventACLGrant, Outcome: audit.OutcomeSuccess, Resource: stream.ID, Reason: req.Role + " to " + req.Principal})
+	writeACL(w, http.StatusCreated, stream)
+}
+
+// RevokeAccess removes a principal's grants on a stream, selected by the "principal" query
+// parameter and optionally narrowed to one "role"
+func RevokeAccess(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeStreamCreate)
+	if !ok {
+		return
+	}
+
+	streamID := mux.Vars(r)["stream_id"]
+	stream, ok := lookupStream(w, streamID)
+	if !ok || !requireStreamAccess(w, r, principal, stream, auth.ActionManage) {
+		return
+	}
+
+	grantee := r.URL.Query().Get("principal")
+	if grantee == "" {
+		http.Error(w, "Missing principal", http.StatusBadRequest)
+		return
+	}
+	role := r.URL.Query().Get("role")
+
+	stream, err := registry.Default().RemoveGrants(streamID, grantee, role)
+	switch {
+	case errors.Is(err, registry.ErrStreamNotFound):
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	case errors.Is(err, registry.ErrGrantNotFound):
+		http.Error(w, "Grant not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update stream ACL", http.StatusInternalServerError)
+		log.Printf("Error revoking grants on stream %s: %v", streamID, err)
+		return
+	}
+
+	log.Printf("Revoked grants on stream %s from %s", streamID, grantee)
+	revoked := "all roles"
+	if role != "" {
+		revoked = role
+	}
+	record(r, principal, audit.Event{Type: audit.EventACLRevoke, Outcome: audit.OutcomeSuccess, Resource: streamID, Reason: revoked + " from " + grantee})
+	writeACL(w, http.StatusOK, stream)
+}
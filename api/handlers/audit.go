@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"blockhouse/audit"
+	"blockhouse/auth"
+	"blockhouse/models"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Bounds on the number of events returned by GetAuditLog
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditLogResponse represents the response structure when querying the audit log
+type AuditLogResponse struct {
+	Events []audit.Event `json:"events"`
+}
+
+// record writes an audit event attributed to principal
+func record(r *http.Request, principal auth.Principal, event audit.Event) {
+	event.Principal = principal.ID
+	event.Method = principal.Method
+	audit.Record(r, event)
+}
+
+// parseAuditTime parses an optional RFC 3339 time query parameter
+func parseAuditTime(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New(name + " must be an RFC 3339 time such as 2024-06-01T00:00:00Z")
+	}
+	return t, nil
+}
+
+// GetAuditLog returns the most recent audit events, filtered by the "from" and "to" time
+// range, "principal", "type" and "limit" query parameters
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r, models.ScopeAdmin); !ok {
+		return
+	}
+
+	var filter audit.Filter
+	var err error
+	if filter.From, err = parseAuditTime(r, "from"); err != nil {
+		http.Error(w, "Invalid audit query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseAuditTime(r, "to"); err != nil {
+		http.Error(w, "Invalid audit query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Principal = r.URL.Query().Get("principal")
+	filter.Type = r.URL.Query().Get("type")
+	filter.Limit = defaultAuditLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxAuditLimit {
+			http.Error(w, "Invalid audit query: limit must be between 1 and "+strconv.Itoa(maxAuditLimit), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	events, err := audit.Default().Query(filter)
+	if err != nil {
+		if errors.Is(err, audit.ErrQueryUnavailable) {
+			http.Error(w, "Audit log is not available: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "Failed to read audit log", http.StatusInternalServerError)
+		log.Printf("Error querying audit log: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(AuditLogResponse{Events: events}); err != nil {
+		log.Printf("Error encoding GetAuditLog response: %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"blockhouse/auth"
+	"blockhouse/config"
+	"blockhouse/idempotency"
+	"blockhouse/kafka"
+	"blockhouse/models"
+	"blockhouse/quotas"
+	"blockhouse/registry"
+	"blockhouse/schemaregistry"
+	"blockhouse/schemas"
+	"blockhouse/tenants"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// errTooManyRecords is returned when a batch holds more records than BATCH_MAX_RECORDS
+var errTooManyRecords = errors.New("too many records")
+
+// Outcomes of a single record in a batch
+const (
+	RecordAccepted = "accepted" // Valid and handed to Kafka
+	RecordRejected = "rejected" // Invalid; Reason says why
+	RecordSkipped  = "skipped"  // Valid, but not written because another record was rejected
+)
+
+// BatchRecordResult reports the outcome of one record of a batch
+type BatchRecordResult struct {
+	Index      int                 `json:"index"`
+	Status     string              `json:"status"`
+	Reason     string              `json:"reason,omitempty"`
+	Violations []schemas.Violation `json:"violations,omitempty"`
+	*kafka.Ack                     // Where an accepted record was written, for synchronous writes
+}
+
+// SendBatchResponse represents the response structure for a batch sent to a stream
+type SendBatchResponse struct {
+	Status   string              `json:"status"`
+	Mode     string              `json:"mode"`
+	Accepted int                 `json:"accepted"`
+	Rejected int                 `json:"rejected"`
+	Results  []BatchRecordResult `json:"results"`
+}
+
+// decodeBatch splits a request body into its raw records: the elements of a JSON array, or the
+// non-blank lines of an application/x-ndjson body
+func decodeBatch(r *http.Request, maxRecords int) ([][]byte, error) {
+	var records [][]byte
+	add := func(record []byte) error {
+		if maxRecords > 0 && len(records) >= maxRecords {
+			return fmt.Errorf("%w: a batch may hold at most %d records", errTooManyRecords, maxRecords)
+		}
+		records = append(records, record)
+		return nil
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-ndjson" {
+		reader := bufio.NewReader(r.Body)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				if err := add(line); err != nil {
+					return nil, err
+				}
+			}
+			if err == io.EOF {
+				return records, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		if err == nil {
+			err = errors.New("body must be a JSON array of records")
+		}
+		return nil, err
+	}
+	for decoder.More() {
+		var record json.RawMessage
+		if err := decoder.Decode(&record); err != nil {
+			return nil, err
+		}
+		if err := add(record); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// SendBatch validates a batch of JSON records and sends the valid ones to the specified Kafka
+// stream in a single write. In all_or_nothing mode one invalid record rejects the whole batch;
+// in best_effort mode the valid records are sent and the others reported.
+func SendBatch(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeStreamWrite)
+	if !ok {
+		return
+	}
+
+	streamID := mux.Vars(r)["stream_id"]
+	stream, ok := requireStream(w, streamID)
+	if !ok || !requireStreamAccess(w, r, principal, stream, auth.ActionProduce) {
+		return
+	}
+	if !requireStreamToken(w, r, stream, auth.TokenProduce) {
+		return
+	}
+	sync, ok := requireAckMode(w, r)
+	if !ok || idempotency.Replay(w, r) {
+		return
+	}
+
+	batchConfig := config.GetBatchConfig()
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = batchConfig.Mode
+	}
+	if mode != config.BatchModeAllOrNothing && mode != config.BatchModeBestEffort {
+		http.Error(w, fmt.Sprintf("Invalid mode %q: must be %q or %q", mode, config.BatchModeAllOrNothing, config.BatchModeBestEffort), http.StatusBadRequest)
+		return
+	}
+
+	if max := quotas.Default().MaxBodyBytes(); max > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+	}
+	records, err := decodeBatch(r, batchConfig.MaxRecords)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, fmt.Sprintf("Payload Too Large: bodies are limited to %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, errTooManyRecords):
+		http.Error(w, "Payload Too Large: "+err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		http.Error(w, "Invalid batch: "+err.Error(), http.StatusBadRequest)
+		log.Printf("Invalid batch for stream %s: %v", streamID, err)
+		return
+	case len(records) == 0:
+		http.Error(w, "Invalid batch: no records", http.StatusBadRequest)
+		return
+	}
+
+	response := SendBatchResponse{Mode: mode, Results: make([]BatchRecordResult, len(records))}
+	values := make([][]byte, 0, len(records))
+	accepted := make([]int, 0, len(records)) // Index of the record each value came from
+	for i, record := range records {
+		result := &response.Results[i]
+		result.Index = i
+
+		var data map[string]interface{}
+		if err := json.Unmarshal(record, &data); err != nil || len(data) == 0 {
+			result.Status, result.Reason = RecordRejected, "invalid or missing JSON object"
+			continue
+		}
+		violations, err := schemas.Default().Validate(streamID, data)
+		if err != nil {
+			http.Error(w, "Failed to validate payload", http.StatusInternalServerError)
+			log.Printf("Error validating batch record %d for stream %s: %v", i, streamID, err)
+			return
+		}
+		if len(violations) > 0 {
+			result.Status, result.Reason, result.Violations = RecordRejected, "payload does not match the stream schema", violations
+			continue
+		}
+		value, err := encodeValue(r.Context(), stream, data)
+		if errors.Is(err, schemaregistry.ErrRegistryUnavailable) {
+			http.Error(w, "Schema registry is unavailable, try again later", http.StatusServiceUnavailable)
+			log.Printf("Error encoding batch for stream %s: %v", streamID, err)
+			return
+		}
+		if err != nil {
+			result.Status, result.Reason = RecordRejected, "payload cannot be encoded with the stream's "+stream.Config.Encoding+" schema: "+err.Error()
+			continue
+		}
+		result.Status = RecordAccepted
+		values = append(values, value)
+		accepted = append(accepted, i)
+	}
+	response.Accepted = len(values)
+	response.Rejected = len(records) - len(values)
+
+	if response.Rejected > 0 && (mode == config.BatchModeAllOrNothing || response.Accepted == 0) {
+		for i := range response.Results {
+			if response.Results[i].Status == RecordAccepted {
+				response.Results[i].Status, response.Results[i].Reason = RecordSkipped, "another record in the batch was rejected"
+			}
+		}
+		response.Status, response.Accepted = "batch rejected", 0
+		writeJSONError(w, http.StatusUnprocessableEntity, response)
+		return
+	}
+
+	// Shed the write before it counts against any quota if the producer is already saturated
+	ticket, ok := requireProduceCapacity(w)
+	if !ok {
+		return
+	}
+	size := valuesSize(values)
+	if !requireByteQuota(w, principal, stream, size) {
+		ticket.Cancel()
+		return
+	}
+	if !requireTenantQuota(w, r, principal, tenants.Default().AllowWriteN(stream.Tenant, len(values), size)) {
+		ticket.Cancel()
+		quotas.Default().Refund(principal.ID, stream.ID, size)
+		return
+	}
+
+	status := http.StatusAccepted
+	registry.Default().Touch(streamID)
+	if sync {
+		acks, ok := produceSync(w, r, stream, values, ticket)
+		if !ok {
+			refundWrite(principal, stream, size)
+			return
+		}
+		for i, index := range accepted {
+			response.Results[index].Ack = &acks[i]
+		}
+		status = http.StatusOK
+	} else {
+		done := idempotency.Defer(r.Context())
+		go func() {
+			err := kafka.SendValuesToKafka(stream.Topic(), values)
+			ticket.Done(err)
+			done(err)
+			if err != nil {
+				refundWrite(principal, stream, size)
+				log.Printf("Failed to send batch to Kafka for stream %s: %v", streamID, err)
+				return
+			}
+			registry.Default().AddBytes(streamID, size)
+		}()
+	}
+
+	switch {
+	case sync && response.Rejected > 0:
+		response.Status = "partially written"
+	case sync:
+		response.Status = "data written"
+	case response.Rejected > 0:
+		response.Status = "partially accepted"
+	default:
+		response.Status = "data accepted"
+	}
+	log.Printf("Accepted %d of %d records for stream %s", response.Accepted, len(records), streamID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -1,12 +1,28 @@
 package handlers
 
 import (
+	"blockhouse/audit"
+	"blockhouse/auth"
+	"blockhouse/config"
+	"blockhouse/idempotency"
 	"blockhouse/kafka"
+	"blockhouse/loadshed"
+	"blockhouse/models"
+	"blockhouse/quotas"
+	"blockhouse/registry"
+	"blockhouse/schemaregistry"
+	"blockhouse/schemas"
+	"blockhouse/tenants"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
-	"os"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,45 +30,304 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-var (
-	once         sync.Once
-	cachedAPIKey string
-	upgrader     = websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin:     func(r *http.Request) bool { return true },
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// subprotocolHeader selects the subprotocol to answer a WebSocket upgrade with: "blockhouse"
+// when the client offers it, otherwise the ticket entry it offered. Browsers fail a handshake
+// that does not select one of the offered subprotocols, so clients sending only
+// "ticket.<ticket>" get it echoed back.
+func subprotocolHeader(r *http.Request) http.Header {
+	selected := ""
+	for _, protocol := range websocket.Subprotocols(r) {
+		if protocol == "blockhouse" {
+			selected = protocol
+			break
+		}
+		if selected == "" && strings.HasPrefix(protocol, auth.TicketSubprotocolPrefix) {
+			selected = protocol
+		}
+	}
+	if selected == "" {
+		return nil
 	}
+	return http.Header{"Sec-Websocket-Protocol": {selected}}
+}
+
+// ValidateAPIKey checks if the request carries valid credentials for the configured AUTH_MODE
+func ValidateAPIKey(r *http.Request) bool {
+	_, err := auth.Authenticate(r)
+	return err == nil
+}
+
+// authenticate returns the request's principal, writing a 401 response if it has no valid
+// credentials. The boolean result reports whether the caller may continue.
+func authenticate(w http.ResponseWriter, r *http.Request) (auth.Principal, bool) {
+	principal, err := auth.Authenticate(r)
+	if err != nil {
+		if !auth.IsUnauthenticated(err) {
+			log.Printf("Error authenticating request: %v", err)
+		}
+		audit.Record(r, audit.Event{Type: audit.EventAuthFailure, Outcome: audit.OutcomeFailure, Reason: err.Error()})
+		auth.SetChallenge(w)
+		http.Error(w, "Unauthorized: invalid or missing credentials", http.StatusUnauthorized)
+		return auth.Principal{}, false
+	}
+	return principal, true
+}
+
+// authorize authenticates the request and checks that its principal was granted scope,
+// writing a 401 or 403 response otherwise. The boolean result reports whether the caller may continue.
+func authorize(w http.ResponseWriter, r *http.Request, scope string) (auth.Principal, bool) {
+	principal, ok := authenticate(w, r)
+	if !ok {
+		return auth.Principal{}, false
+	}
+	if !principal.HasScope(scope) {
+		record(r, principal, audit.Event{Type: audit.EventAccessDenied, Outcome: audit.OutcomeDenied, Reason: "missing the " + scope + " scope"})
+		http.Error(w, "Forbidden: caller lacks the "+scope+" scope", http.StatusForbidden)
+		return auth.Principal{}, false
+	}
+	return principal, true
+}
+
+// StartStreamRequest represents the optional JSON body accepted when creating a new stream
+type StartStreamRequest struct {
+	Partitions        int    `json:"partitions"`
+	ReplicationFactor int    `json:"replication_factor"`
+	RetentionMs       int64  `json:"retention_ms"`
+	CleanupPolicy     string `json:"cleanup_policy"`
+	MaxMessageBytes   int    `json:"max_message_bytes"`
+	TTL               string `json:"ttl"`
+	IdleTimeout       string `json:"idle_timeout"`
+
+	// Optional JSON Schema that payloads sent to the stream must satisfy
+	Schema              json.RawMessage `json:"schema"`
+	SchemaCompatibility string          `json:"schema_compatibility"`
+
+	// Optional Avro or Protobuf encoding for values written to Kafka
+	Encoding    string `json:"encoding"`
+	ValueSchema string `json:"value_schema"`
+	MessageType string `json:"message_type"`
+
+	// Optional lifetime of the producer and consumer tokens returned for the stream
+	TokenTTL string `json:"token_ttl"`
+}
+
+// Default topic settings applied when StartStream is called without a body
+const (
+	defaultPartitions        = 1
+	defaultReplicationFactor = 1
 )
 
-// loadAPIKey caches the API key from environment at startup
-func loadAPIKey() {
-	cachedAPIKey = os.Getenv("API_KEY")
-	if cachedAPIKey == "" {
-		log.Println("Warning: API_KEY is not set in the environment.")
+// streamConfig validates the request and converts it to a stream configuration, filling in defaults
+func (req StartStreamRequest) streamConfig(topic string) (models.StreamConfig, error) {
+	cfg := models.StreamConfig{
+		Topic:             topic,
+		Partitions:        req.Partitions,
+		ReplicationFactor: req.ReplicationFactor,
+		RetentionMs:       req.RetentionMs,
+		CleanupPolicy:     req.CleanupPolicy,
+		MaxMessageBytes:   req.MaxMessageBytes,
+		TTL:               req.TTL,
+		IdleTimeout:       req.IdleTimeout,
+		Encoding:          req.Encoding,
+		MessageType:       req.MessageType,
+	}
+	if cfg.Partitions == 0 {
+		cfg.Partitions = defaultPartitions
+	}
+	if cfg.ReplicationFactor == 0 {
+		cfg.ReplicationFactor = defaultReplicationFactor
+	}
+	if cfg.Encoding == "" {
+		cfg.Encoding = models.EncodingJSON
+	}
+
+	switch {
+	case cfg.Partitions < 0:
+		return cfg, errors.New("partitions must be positive")
+	case cfg.ReplicationFactor < 0:
+		return cfg, errors.New("replication_factor must be positive")
+	case cfg.RetentionMs < -1:
+		return cfg, errors.New("retention_ms must be -1 (unlimited) or a non-negative duration")
+	case cfg.MaxMessageBytes < 0:
+		return cfg, errors.New("max_message_bytes must not be negative")
+	}
+	switch cfg.CleanupPolicy {
+	case "", "delete", "compact", "compact,delete", "delete,compact":
+	default:
+		return cfg, fmt.Errorf("unsupported cleanup_policy %q", cfg.CleanupPolicy)
+	}
+	if _, err := parseOptionalDuration("ttl", cfg.TTL); err != nil {
+		return cfg, err
 	}
+	if _, err := parseOptionalDuration("idle_timeout", cfg.IdleTimeout); err != nil {
+		return cfg, err
+	}
+	if _, err := parseOptionalDuration("token_ttl", req.TokenTTL); err != nil {
+		return cfg, err
+	}
+	if req.SchemaCompatibility != "" && !schemas.ValidCompatibility(req.SchemaCompatibility) {
+		return cfg, fmt.Errorf("unsupported schema_compatibility %q", req.SchemaCompatibility)
+	}
+	if len(req.Schema) > 0 {
+		if _, err := schemas.Compile(req.Schema); err != nil {
+			return cfg, err
+		}
+	}
+	if schema, ok := req.valueSchema(cfg.Encoding); ok {
+		if schemaregistry.Default() == nil {
+			return cfg, errors.New("encoding " + cfg.Encoding + " requires SCHEMA_REGISTRY_URL to be configured")
+		}
+		if req.ValueSchema == "" {
+			return cfg, errors.New("value_schema is required for encoding " + cfg.Encoding)
+		}
+		if err := schemaregistry.CheckSchema(schema, cfg.MessageType); err != nil {
+			return cfg, err
+		}
+	} else if cfg.Encoding != models.EncodingJSON {
+		return cfg, fmt.Errorf("unsupported encoding %q", cfg.Encoding)
+	}
+	return cfg, nil
 }
 
-// ValidateAPIKey checks if the request contains a valid API key
-func ValidateAPIKey(r *http.Request) bool {
-	once.Do(loadAPIKey)
-	clientAPIKey := r.Header.Get("X-API-Key")
-	return clientAPIKey == cachedAPIKey
+// valueSchema returns the schema registry document for a binary encoding.
+// The boolean result is false for JSON and unknown encodings.
+func (req StartStreamRequest) valueSchema(encoding string) (schemaregistry.Schema, bool) {
+	switch encoding {
+	case models.EncodingAvro:
+		return schemaregistry.Schema{Schema: req.ValueSchema}, true
+	case models.EncodingProtobuf:
+		return schemaregistry.Schema{Schema: req.ValueSchema, SchemaType: schemaregistry.TypeProtobuf}, true
+	}
+	return schemaregistry.Schema{}, false
+}
+
+// parseOptionalDuration parses a positive duration setting, treating an empty value as unset (zero)
+func parseOptionalDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration such as 30m", name)
+	}
+	return d, nil
 }
 
 // StreamResponse represents the response structure when creating a new stream
 type StreamResponse struct {
-	StreamID string `json:"stream_id"`
+	StreamID      string              `json:"stream_id"`
+	Config        models.StreamConfig `json:"config"`
+	SchemaVersion int                 `json:"schema_version,omitempty"`
+	Tokens        StreamTokens        `json:"tokens"`
 }
 
-// StartStream initializes a new data stream and returns a unique stream ID
+// StartStream provisions a Kafka topic for a new data stream and returns its unique stream ID
 func StartStream(w http.ResponseWriter, r *http.Request) {
-	if !ValidateAPIKey(r) {
-		http.Error(w, "Unauthorized: invalid or missing API key", http.StatusUnauthorized)
+	principal, ok := authorize(w, r, models.ScopeStreamCreate)
+	if !ok {
 		return
 	}
 
+	// The request body is optional; an empty body selects the default topic settings
+	var req StartStreamRequest
+	if r.Body != nil && r.Body != http.NoBody {
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid stream configuration: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	streamID := uuid.New().String()
-	response := StreamResponse{StreamID: streamID}
+	cfg, err := req.streamConfig(tenants.TopicName(principal.Tenant, streamID))
+	if err != nil {
+		http.Error(w, "Invalid stream configuration: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Hold a stream slot until the stream is registered so concurrent creations respect the limit
+	release, err := tenants.Default().ReserveStream(principal.Tenant)
+	if !requireTenantQuota(w, r, principal, err) {
+		return
+	}
+	defer release()
+
+	// Register the value schema under the topic's subject so consumers can resolve the ID
+	if schema, ok := req.valueSchema(cfg.Encoding); ok {
+		schemaID, err := schemaregistry.Default().Register(r.Context(), cfg.Topic+"-value", schema)
+		if err != nil {
+			log.Printf("Error registering value schema for stream %s: %v", streamID, err)
+			if errors.Is(err, schemaregistry.ErrSchemaRejected) {
+				http.Error(w, "Value schema rejected by schema registry: "+err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, "Schema registry is unavailable, try again later", http.StatusServiceUnavailable)
+			}
+			return
+		}
+		cfg.SchemaID = schemaID
+	}
+
+	stream := models.Stream{
+		ID:        streamID,
+		Tenant:    models.TenantOf(principal.Tenant),
+		Owner:     principal.ID,
+		CreatedAt: time.Now().UTC(),
+		Config:    cfg,
+		Status:    models.StreamStatusActive,
+	}
+	if ttl, _ := parseOptionalDuration("ttl", cfg.TTL); ttl > 0 {
+		expiresAt := stream.CreatedAt.Add(ttl)
+		stream.ExpiresAt = &expiresAt
+	}
+	// Sign the tokens before anything is provisioned; they only depend on the stream record
+	tokenTTL, _ := parseOptionalDuration("token_ttl", req.TokenTTL)
+	tokens, err := issueStreamTokens(stream, tokenTTL)
+	if err != nil {
+		http.Error(w, "Failed to issue stream tokens", http.StatusInternalServerError)
+		log.Printf("Error issuing tokens for stream %s: %v", streamID, err)
+		return
+	}
+
+	if err := kafka.CreateTopic(config.GetKafkaBroker(), cfg.Topic, cfg); err != nil {
+		log.Printf("Error provisioning topic for stream %s: %v", streamID, err)
+		record(r, principal, audit.Event{Type: audit.EventStreamCreate, Outcome: audit.OutcomeFailure, Resource: streamID, Reason: err.Error()})
+		switch {
+		case errors.Is(err, kafka.ErrInvalidTopicConfig):
+			http.Error(w, "Topic configuration rejected by Kafka: "+err.Error(), http.StatusBadRequest)
+		case errors.Is(err, kafka.ErrBrokerUnavailable):
+			http.Error(w, "Kafka is unavailable, try again later", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "Failed to provision Kafka topic", http.StatusBadGateway)
+		}
+		return
+	}
+
+	if err := registry.Default().Register(stream); err != nil {
+		http.Error(w, "Failed to register stream", http.StatusInternalServerError)
+		log.Printf("Error registering stream %s: %v", streamID, err)
+		abandonStream(r, principal, stream, false, err)
+		return
+	}
+
+	response := StreamResponse{StreamID: streamID, Config: cfg, Tokens: tokens}
+	if len(req.Schema) > 0 {
+		version, _, err := schemas.Default().Register(streamID, req.Schema, req.SchemaCompatibility)
+		if err != nil {
+			http.Error(w, "Failed to register stream schema", http.StatusInternalServerError)
+			log.Printf("Error registering schema for stream %s: %v", streamID, err)
+			abandonStream(r, principal, stream, true, err)
+			return
+		}
+		response.SchemaVersion = version.Version
+	}
+	record(r, principal, audit.Event{Type: audit.EventStreamCreate, Outcome: audit.OutcomeSuccess, Resource: streamID})
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 
@@ -62,36 +337,191 @@ func StartStream(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// SendDataResponse represents the response structure for data sent to a stream
+// abandonStream undoes what StartStream provisioned for a stream whose creation failed with
+// cause: its schemas, its registry record when registered is set, and its Kafka topic. The
+// value schema registered with the schema registry is left under the topic's unused subject.
+func abandonStream(r *http.Request, principal auth.Principal, stream models.Stream, registered bool, cause error) {
+	record(r, principal, audit.Event{Type: audit.EventStreamCreate, Outcome: audit.OutcomeFailure, Resource: stream.ID, Reason: cause.Error()})
+	if registered {
+		if err := schemas.Default().Delete(stream.ID); err != nil {
+			log.Printf("Error deleting schemas of abandoned stream %s: %v", stream.ID, err)
+		}
+		if err := registry.Default().Unregister(stream.ID); err != nil {
+			log.Printf("Error removing abandoned stream %s: %v", stream.ID, err)
+		}
+	}
+	if err := kafka.DeleteTopic(config.GetKafkaBroker(), stream.Topic()); err != nil {
+		log.Printf("Error deleting topic of abandoned stream %s: %v", stream.ID, err)
+	}
+}
+
+// requireProduceCapacity admits a write to Kafka, or answers 503 with Retry-After when the
+// producer has as many writes in flight as it can currently sustain
+func requireProduceCapacity(w http.ResponseWriter) (loadshed.Ticket, bool) {
+	ticket, ok := loadshed.Default().Acquire()
+	if !ok {
+		retryAfter := int(math.Ceil(loadshed.Default().RetryAfter().Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, "Service Unavailable: the producer is overloaded, retry later", http.StatusServiceUnavailable)
+	}
+	return ticket, ok
+}
+
+// AckAll asks a produce endpoint to wait until every in-sync replica has the data
+const AckAll = "all"
+
+// requireAckMode reads the ack query parameter, reporting whether the caller asked for a
+// synchronous write; any value other than AckAll or none is answered with 400
+func requireAckMode(w http.ResponseWriter, r *http.Request) (sync bool, ok bool) {
+	switch ack := r.URL.Query().Get("ack"); ack {
+	case "":
+		return false, true
+	case AckAll:
+		return true, true
+	default:
+		http.Error(w, fmt.Sprintf("Invalid ack %q: must be %q or omitted", ack, AckAll), http.StatusBadRequest)
+		return false, false
+	}
+}
+
+// produceSync writes values to a stream and waits up to PRODUCE_ACK_TIMEOUT for the broker's
+// acknowledgement, releasing the write's ticket once it completes. A write that fails is
+// answered with 502 and the produce error, and one that is not acknowledged in time with 504.
+func produceSync(w http.ResponseWriter, r *http.Request, stream models.Stream, values [][]byte, ticket loadshed.Ticket) ([]kafka.Ack, bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), config.GetEnvDuration("PRODUCE_ACK_TIMEOUT", 10*time.Second))
+	defer cancel()
+
+	acks, err := kafka.ProduceSync(ctx, stream.Topic(), values)
+	if errors.Is(err, context.Canceled) {
+		// The client went away; that says nothing about the broker
+		ticket.Cancel()
+	} else {
+		ticket.Done(err)
+	}
+	switch {
+	case err == nil:
+		registry.Default().AddBytes(stream.ID, valuesSize(values))
+		return acks, true
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, "Gateway Timeout: the broker did not acknowledge the write in time, it may or may not have been written", http.StatusGatewayTimeout)
+	default:
+		http.Error(w, "Bad Gateway: "+err.Error(), http.StatusBadGateway)
+	}
+	log.Printf("Failed to send data to Kafka for stream %s: %v", stream.ID, err)
+	return nil, false
+}
+
+// valuesSize returns the total size of encoded message values
+func valuesSize(values [][]byte) int64 {
+	var size int64
+	for _, value := range values {
+		size += int64(len(value))
+	}
+	return size
+}
+
+// SendDataResponse represents the response structure for data sent to a stream. Synchronous
+// writes also report where the message was written.
 type SendDataResponse struct {
 	Status string `json:"status"`
+	*kafka.Ack
 }
 
 // SendData sends a JSON payload to the specified Kafka stream
 func SendData(w http.ResponseWriter, r *http.Request) {
-	if !ValidateAPIKey(r) {
-		http.Error(w, "Unauthorized: invalid or missing API key", http.StatusUnauthorized)
+	principal, ok := authorize(w, r, models.ScopeStreamWrite)
+	if !ok {
 		return
 	}
 
-	vars := mux.Vars(r)
-	streamID := vars["stream_id"]
-	if clientStreamID := r.Header.Get("X-Stream-ID"); clientStreamID != streamID {
-		http.Error(w, "Forbidden: Access to this stream is restricted", http.StatusForbidden)
+	streamID := mux.Vars(r)["stream_id"]
+	stream, ok := requireStream(w, streamID)
+	if !ok || !requireStreamAccess(w, r, principal, stream, auth.ActionProduce) {
+		return
+	}
+	if !requireStreamToken(w, r, stream, auth.TokenProduce) {
+		return
+	}
+	sync, ok := requireAckMode(w, r)
+	if !ok || idempotency.Replay(w, r) {
 		return
 	}
 
+	if max := quotas.Default().MaxBodyBytes(); max > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+	}
 	var data map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || len(data) == 0 {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Payload Too Large: bodies are limited to %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Invalid or missing JSON data", http.StatusBadRequest)
 		log.Printf("Invalid JSON data for stream %s: %v", streamID, err)
 		return
 	}
 
+	violations, err := schemas.Default().Validate(streamID, data)
+	if err != nil {
+		http.Error(w, "Failed to validate payload", http.StatusInternalServerError)
+		log.Printf("Error validating payload for stream %s: %v", streamID, err)
+		return
+	}
+	if len(violations) > 0 {
+		writeSchemaViolations(w, violations)
+		return
+	}
+
+	value, err := encodeValue(r.Context(), stream, data)
+	if err != nil {
+		log.Printf("Error encoding payload for stream %s: %v", streamID, err)
+		if errors.Is(err, schemaregistry.ErrRegistryUnavailable) {
+			http.Error(w, "Schema registry is unavailable, try again later", http.StatusServiceUnavailable)
+		} else {
+			http.Error(w, "Payload cannot be encoded with the stream's "+stream.Config.Encoding+" schema: "+err.Error(), http.StatusUnprocessableEntity)
+		}
+		return
+	}
+
+	// Shed the write before it counts against any quota if the producer is already saturated
+	ticket, ok := requireProduceCapacity(w)
+	if !ok {
+		return
+	}
+	size := int64(len(value))
+	if !requireByteQuota(w, principal, stream, size) {
+		ticket.Cancel()
+		return
+	}
+	if !requireTenantQuota(w, r, principal, tenants.Default().AllowWrite(stream.Tenant, size)) {
+		ticket.Cancel()
+		quotas.Default().Refund(principal.ID, stream.ID, size)
+		return
+	}
+
+	registry.Default().Touch(streamID)
+	if sync {
+		acks, ok := produceSync(w, r, stream, [][]byte{value}, ticket)
+		if !ok {
+			refundWrite(principal, stream, size)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendDataResponse{Status: "data written", Ack: &acks[0]})
+		return
+	}
+	done := idempotency.Defer(r.Context())
 	go func() {
-		if err := kafka.SendToKafka(streamID, data); err != nil {
+		err := kafka.SendValueToKafka(stream.Topic(), value)
+		ticket.Done(err)
+		done(err)
+		if err != nil {
+			refundWrite(principal, stream, size)
 			log.Printf("Failed to send data to Kafka for stream %s: %v", streamID, err)
+			return
 		}
+		registry.Default().AddBytes(streamID, size)
 	}()
 
 	w.Header().Set("Content-Type", "application/json")
@@ -99,24 +529,46 @@ func SendData(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(SendDataResponse{Status: "data accepted"})
 }
 
+// encodeValue serializes a payload in the stream's configured encoding
+func encodeValue(ctx context.Context, stream models.Stream, data map[string]interface{}) ([]byte, error) {
+	value, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if stream.Config.Encoding == "" || stream.Config.Encoding == models.EncodingJSON {
+		return value, nil
+	}
+	client := schemaregistry.Default()
+	if client == nil {
+		return nil, fmt.Errorf("%w: SCHEMA_REGISTRY_URL is not configured", schemaregistry.ErrRegistryUnavailable)
+	}
+	return client.Serialize(ctx, stream.Config.SchemaID, stream.Config.MessageType, value)
+}
+
 // GetResults retrieves and sends results for a specified stream
 func GetResults(w http.ResponseWriter, r *http.Request) {
-	if !ValidateAPIKey(r) {
-		http.Error(w, "Unauthorized: invalid or missing API key", http.StatusUnauthorized)
+	principal, ok := authorize(w, r, models.ScopeStreamRead)
+	if !ok {
 		return
 	}
 
-	vars := mux.Vars(r)
-	streamID := vars["stream_id"]
-	if clientStreamID := r.Header.Get("X-Stream-ID"); clientStreamID != streamID {
-		http.Error(w, "Forbidden: Access to this stream is restricted", http.StatusForbidden)
+	streamID := mux.Vars(r)["stream_id"]
+	stream, ok := requireStream(w, streamID)
+	if !ok || !requireStreamAccess(w, r, principal, stream, auth.ActionConsume) {
+		return
+	}
+	if !requireStreamToken(w, r, stream, auth.TokenConsume) {
 		return
 	}
 
-	resultChan := make(chan string, 5)
-	defer close(resultChan)
+	registry.Default().Touch(streamID)
 
-	go kafka.ProcessMessages(streamID, resultChan)
+	// Stop the reader once the response has been written
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	resultChan := make(chan string, 5)
+	go kafka.ProcessMessagesContext(ctx, stream, resultChan)
 
 	select {
 	case result := <-resultChan:
@@ -130,46 +582,76 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// StreamResults establishes a WebSocket connection for streaming Kafka results
+// StreamResults establishes a WebSocket connection for streaming Kafka results. Callers
+// authenticate with a ticket from IssueTicket, or with credentials and a consumer token
 func StreamResults(w http.ResponseWriter, r *http.Request) {
-	clientAPIKey := r.Header.Get("X-API-Key")
-	if clientAPIKey == "" {
-		clientAPIKey = r.URL.Query().Get("X-API-Key")
+	principal, ok := authorize(w, r, models.ScopeStreamRead)
+	if !ok {
+		return
 	}
 
-	streamID := r.Header.Get("X-Stream-ID")
+	streamID := mux.Vars(r)["stream_id"]
+	if streamID == "" {
+		streamID = r.Header.Get("X-Stream-ID")
+	}
 	if streamID == "" {
 		streamID = r.URL.Query().Get("X-Stream-ID")
 	}
-
-	if clientAPIKey != cachedAPIKey || streamID == "" {
-		http.Error(w, "Unauthorized: invalid or missing API key", http.StatusUnauthorized)
+	if streamID == "" {
+		http.Error(w, "Missing stream ID", http.StatusBadRequest)
+		return
+	}
+	stream, ok := requireStream(w, streamID)
+	if !ok || !requireStreamAccess(w, r, principal, stream, auth.ActionConsume) {
+		return
+	}
+	// The consumer token was checked when the ticket was issued
+	if principal.Method != auth.MethodTicket && !requireStreamToken(w, r, stream, auth.TokenConsume) {
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := upgrader.Upgrade(w, r, subprotocolHeader(r))
 	if err != nil {
 		log.Printf("WebSocket upgrade failed for stream %s: %v", streamID, err)
 		return
 	}
 	defer conn.Close()
 
-	resultChan := make(chan string, 5)
-	defer close(resultChan)
+	// Track the connection so deleting the stream can close it
+	addSubscriber(streamID, conn)
+	defer removeSubscriber(streamID, conn)
 
-	go kafka.ProcessMessages(streamID, resultChan)
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
 
-	for {
-		select {
-		case result, ok := <-resultChan:
-			if !ok {
+	// Read from the client so control frames are handled and disconnects are noticed
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
 				return
 			}
+		}
+	}()
+
+	resultChan := make(chan string, 5)
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		kafka.ProcessMessagesContext(ctx, stream, resultChan)
+	}()
+
+	for {
+		select {
+		case result := <-resultChan:
 			if err := conn.WriteMessage(websocket.TextMessage, []byte(result)); err != nil {
 				log.Printf("WebSocket send error for stream %s: %v", streamID, err)
 				return
 			}
-		case <-r.Context().Done():
+		case <-readerDone:
+			log.Printf("Kafka reader stopped for WebSocket on stream %s", streamID)
+			return
+		case <-ctx.Done():
 			log.Printf("Client disconnected from WebSocket for stream %s", streamID)
 			return
 		}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"blockhouse/audit"
+	"blockhouse/auth"
+	"blockhouse/models"
+	"blockhouse/tenants"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateKeyRequest represents the request body for issuing a new API key
+type CreateKeyRequest struct {
+	Name      string   `json:"name"`
+	Tenant    string   `json:"tenant"` // Tenant the key acts for; defaults to the default tenant
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expires_in"` // Optional lifetime such as 720h; keys without one never expire
+}
+
+// CreateKeyResponse returns a new key's record with its secret, which is only ever shown once
+type CreateKeyResponse struct {
+	Key    models.APIKey `json:"key"`
+	Secret string        `json:"secret"`
+}
+
+// KeyListResponse represents the response structure when listing API keys
+type KeyListResponse struct {
+	Keys []models.APIKey `json:"keys"`
+}
+
+// redactKey strips the secret hash and sealed signing key from a key record before it is
+// returned to a client
+func redactKey(key models.APIKey) models.APIKey {
+	key.Hash, key.Sealed = "", ""
+	return key
+}
+
+// CreateKey issues a new API key with the requested scopes
+func CreateKey(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeAdmin)
+	if !ok {
+		return
+	}
+
+	var req CreateKeyRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, "Invalid key request: a name and at least one scope are required", http.StatusBadRequest)
+		return
+	}
+	expiresIn, err := parseOptionalDuration("expires_in", req.ExpiresIn)
+	if err != nil {
+		http.Error(w, "Invalid key request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var expiresAt *time.Time
+	if expiresIn > 0 {
+		at := time.Now().UTC().Add(expiresIn)
+		expiresAt = &at
+	}
+
+	if _, err := tenants.Default().Get(req.Tenant); err != nil {
+		if errors.Is(err, tenants.ErrTenantNotFound) {
+			http.Error(w, "Invalid key request: tenant "+req.Tenant+" does not exist", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		log.Printf("Error looking up tenant %s for API key %q: %v", req.Tenant, req.Name, err)
+		return
+	}
+
+	key, secret, err := auth.Default().Create(req.Name, req.Tenant, req.Scopes, expiresAt)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidScope) {
+			record(r, principal, audit.Event{Type: audit.EventKeyCreate, Outcome: audit.OutcomeFailure, Reason: err.Error()})
+			http.Error(w, "Invalid key request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		log.Printf("Error creating API key %q: %v", req.Name, err)
+		return
+	}
+	log.Printf("Created API key %s (%s) for tenant %s with scopes %v", key.ID, key.Name, key.Tenant, key.Scopes)
+	record(r, principal, audit.Event{Type: audit.EventKeyCreate, Outcome: audit.OutcomeSuccess, Resource: key.ID, Reason: key.Name + " with " + strings.Join(key.Scopes, ",")})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(CreateKeyResponse{Key: redactKey(key), Secret: secret}); err != nil {
+		log.Printf("Error encoding CreateKey response: %v", err)
+	}
+}
+
+// ListKeys returns every API key, including revoked and expired ones, without their secrets
+func ListKeys(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r, models.ScopeAdmin); !ok {
+		return
+	}
+
+	keys, err := auth.Default().List()
+	if err != nil {
+		http.Error(w, "Failed to list API keys", http.StatusInternalServerError)
+		log.Printf("Error listing API keys: %v", err)
+		return
+	}
+	for i := range keys {
+		keys[i] = redactKey(keys[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(KeyListResponse{Keys: keys}); err != nil {
+		log.Printf("Error encoding ListKeys response: %v", err)
+	}
+}
+
+// RevokeKey disables an API key so it is rejected from then on
+func RevokeKey(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeAdmin)
+	if !ok {
+		return
+	}
+
+	keyID := mux.Vars(r)["key_id"]
+	key, err := auth.Default().Revoke(keyID)
+	if err != nil {
+		if errors.Is(err, auth.ErrKeyNotFound) {
+			record(r, principal, audit.Event{Type: audit.EventKeyRevoke, Outcome: audit.OutcomeFailure, Resource: keyID, Reason: err.Error()})
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+		log.Printf("Error revoking API key %s: %v", keyID, err)
+		return
+	}
+	log.Printf("Revoked API key %s (%s)", key.ID, key.Name)
+	record(r, principal, audit.Event{Type: audit.EventKeyRevoke, Outcome: audit.OutcomeSuccess, Resource: key.ID})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(redactKey(key)); err != nil {
+		log.Printf("Error encoding RevokeKey response: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"blockhouse/auth"
+	"blockhouse/models"
+	"blockhouse/quotas"
+	"blockhouse/tenants"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// requireByteQuota charges n payload bytes to the caller's and the stream's byte quotas,
+// answering 429 with Retry-After when either is used up. A charged write that is refused
+// later or fails must be refunded.
+func requireByteQuota(w http.ResponseWriter, principal auth.Principal, stream models.Stream, n int64) bool {
+	retryAfter, err := quotas.Default().Charge(principal.ID, stream.ID, n)
+	if err == nil {
+		return true
+	}
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "Too Many Requests: "+err.Error(), http.StatusTooManyRequests)
+	log.Printf("Refused write from %s to stream %s: %v", principal.ID, stream.ID, err)
+	return false
+}
+
+// refundWrite gives back the bytes a failed write was charged against the caller's and the
+// stream's byte quotas and its tenant's write volume
+func refundWrite(principal auth.Principal, stream models.Stream, n int64) {
+	quotas.Default().Refund(principal.ID, stream.ID, n)
+	tenants.Default().Refund(stream.Tenant, n)
+}
+
+// QuotaListResponse represents the byte quota usage of every principal and stream
+type QuotaListResponse struct {
+	Principals []quotas.Usage `json:"principals"`
+	Streams    []quotas.Usage `json:"streams"`
+}
+
+// ListQuotas returns how many payload bytes each principal and stream has sent and their quotas
+func ListQuotas(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r, models.ScopeAdmin); !ok {
+		return
+	}
+
+	principals, streams := quotas.Default().List()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(QuotaListResponse{Principals: principals, Streams: streams}); err != nil {
+		log.Printf("Error encoding ListQuotas response: %v", err)
+	}
+}
+
+// GetPrincipalQuota returns how many payload bytes a principal has sent today and overall
+func GetPrincipalQuota(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r, models.ScopeAdmin); !ok {
+		return
+	}
+
+	usage := quotas.Default().PrincipalUsage(mux.Vars(r)["principal_id"])
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
+		log.Printf("Error encoding GetPrincipalQuota response: %v", err)
+	}
+}
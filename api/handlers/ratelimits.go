@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"blockhouse/audit"
+	"blockhouse/models"
+	"blockhouse/ratelimit"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// RateLimitsResponse represents the rate limit policies in force and where they come from
+type RateLimitsResponse struct {
+	Source string `json:"source"` // "config" or "runtime"
+	ratelimit.PolicySet
+}
+
+// writeRateLimits encodes the policies in force as the JSON response body
+func writeRateLimits(w http.ResponseWriter) {
+	policies, override := ratelimit.DefaultEngine().Policies()
+	response := RateLimitsResponse{Source: "config", PolicySet: policies}
+	if override {
+		response.Source = "runtime"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding rate limit policies: %v", err)
+	}
+}
+
+// GetRateLimits returns the rate limit policies in force
+func GetRateLimits(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r, models.ScopeAdmin); !ok {
+		return
+	}
+	writeRateLimits(w)
+}
+
+// SetRateLimits replaces the rate limit policies until they are reset
+func SetRateLimits(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeAdmin)
+	if !ok {
+		return
+	}
+
+	var policies ratelimit.PolicySet
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policies); err != nil {
+		http.Error(w, "Invalid rate limit policies: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := ratelimit.DefaultEngine().Set(policies)
+	switch {
+	case errors.Is(err, ratelimit.ErrInvalidPolicy):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update rate limit policies", http.StatusInternalServerError)
+		log.Printf("Error updating rate limit policies: %v", err)
+		return
+	}
+
+	log.Printf("Rate limit policies replaced by %s: %d policies", principal.ID, len(policies.Policies))
+	record(r, principal, audit.Event{Type: audit.EventRateLimits, Outcome: audit.OutcomeSuccess, Reason: fmt.Sprintf("%d policies", len(policies.Policies))})
+	writeRateLimits(w)
+}
+
+// ResetRateLimits discards the policies set at runtime and restores those from the configuration
+func ResetRateLimits(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeAdmin)
+	if !ok {
+		return
+	}
+
+	if err := ratelimit.DefaultEngine().Reset(); err != nil {
+		http.Error(w, "Failed to reset rate limit policies", http.StatusInternalServerError)
+		log.Printf("Error resetting rate limit policies: %v", err)
+		return
+	}
+
+	log.Printf("Rate limit policies reset to the configuration by %s", principal.ID)
+	record(r, principal, audit.Event{Type: audit.EventRateLimits, Outcome: audit.OutcomeSuccess, Reason: "reset"})
+	writeRateLimits(w)
+}
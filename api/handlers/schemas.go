@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"blockhouse/auth"
+	"blockhouse/models"
+	"blockhouse/schemas"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// SchemaRequest represents the request body for registering a new stream schema version
+type SchemaRequest struct {
+	Schema        json.RawMessage `json:"schema"`
+	Compatibility string          `json:"compatibility"`
+}
+
+// SchemaErrorResponse represents the response body when a schema or payload is rejected
+type SchemaErrorResponse struct {
+	Error      string              `json:"error"`
+	Problems   []string            `json:"problems,omitempty"`
+	Violations []schemas.Violation `json:"violations,omitempty"`
+}
+
+// writeJSONError writes a JSON error body with the given status
+func writeJSONError(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error encoding error response: %v", err)
+	}
+}
+
+// writeSchemaViolations rejects a payload that does not satisfy its stream's schema
+func writeSchemaViolations(w http.ResponseWriter, violations []schemas.Violation) {
+	writeJSONError(w, http.StatusUnprocessableEntity, SchemaErrorResponse{
+		Error:      "Payload does not match the stream schema",
+		Violations: violations,
+	})
+}
+
+// PutSchema registers a new JSON Schema version for a stream, checking it against the
+// previous version under the stream's compatibility mode
+func PutSchema(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeStreamCreate)
+	if !ok {
+		return
+	}
+
+	streamID := mux.Vars(r)["stream_id"]
+	stream, ok := lookupStream(w, streamID)
+	if !ok || !requireStreamAccess(w, r, principal, stream, auth.ActionManage) {
+		return
+	}
+
+	var req SchemaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Schema) == 0 {
+		http.Error(w, "Invalid or missing schema", http.StatusBadRequest)
+		return
+	}
+
+	version, problems, err := schemas.Default().Register(streamID, req.Schema, req.Compatibility)
+	switch {
+	case errors.Is(err, schemas.ErrIncompatible):
+		writeJSONError(w, http.StatusConflict, SchemaErrorResponse{Error: err.Error(), Problems: problems})
+		return
+	case errors.Is(err, schemas.ErrInvalidSchema), errors.Is(err, schemas.ErrInvalidCompatibility):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Failed to register schema", http.StatusInternalServerError)
+		log.Printf("Error registering schema for stream %s: %v", streamID, err)
+		return
+	}
+
+	log.Printf("Registered schema version %d for stream %s", version.Version, streamID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(version); err != nil {
+		log.Printf("Error encoding PutSchema response for stream %s: %v", streamID, err)
+	}
+}
+
+// GetSchema returns every schema version registered for a stream
+func GetSchema(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeStreamRead)
+	if !ok {
+		return
+	}
+
+	streamID := mux.Vars(r)["stream_id"]
+	stream, ok := lookupStream(w, streamID)
+	if !ok || !requireStreamAccess(w, r, principal, stream, auth.ActionView) {
+		return
+	}
+
+	set, err := schemas.Default().Get(streamID)
+	if errors.Is(err, schemas.ErrNoSchema) {
+		http.Error(w, "No schema registered for stream", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load schema", http.StatusInternalServerError)
+		log.Printf("Error loading schema for stream %s: %v", streamID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(set); err != nil {
+		log.Printf("Error encoding GetSchema response for stream %s: %v", streamID, err)
+	}
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"blockhouse/audit"
+	"blockhouse/auth"
+	"blockhouse/config"
+	"blockhouse/kafka"
+	"blockhouse/models"
+	"blockhouse/quotas"
+	"blockhouse/registry"
+	"blockhouse/schemas"
+	"blockhouse/tenants"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Counts streams removed by the janitor because their TTL or idle timeout ran out
+var streamExpirations = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stream_expirations_total",
+		Help: "Total number of streams expired by TTL or idle timeout",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	prometheus.MustRegister(streamExpirations)
+}
+
+// StreamListResponse represents the response structure when listing streams
+type StreamListResponse struct {
+	Streams []models.Stream `json:"streams"`
+}
+
+// lookupStream fetches a stream record and writes a 404 response if it does not exist.
+// The boolean result reports whether the caller may continue handling the request.
+func lookupStream(w http.ResponseWriter, streamID string) (models.Stream, bool) {
+	stream, err := registry.Default().Get(streamID)
+	if err != nil {
+		if errors.Is(err, registry.ErrStreamNotFound) {
+			http.Error(w, "Stream not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to look up stream", http.StatusInternalServerError)
+			log.Printf("Error looking up stream %s: %v", streamID, err)
+		}
+		return models.Stream{}, false
+	}
+	return stream, true
+}
+
+// requireStream is like lookupStream but also rejects streams that are not active,
+// so data and subscribers are only accepted for live streams.
+func requireStream(w http.ResponseWriter, streamID string) (models.Stream, bool) {
+	stream, ok := lookupStream(w, streamID)
+	if !ok {
+		return stream, false
+	}
+	if stream.Status != models.StreamStatusActive {
+		http.Error(w, "Stream is pending deletion", http.StatusGone)
+		return models.Stream{}, false
+	}
+	return stream, true
+}
+
+// writeStream encodes a stream record as the JSON response body with the given status
+func writeStream(w http.ResponseWriter, status int, stream models.Stream) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(stream); err != nil {
+		log.Printf("Error encoding response for stream %s: %v", stream.ID, err)
+	}
+}
+
+// ListStreams returns every stream that has not been deleted and that the caller holds a role on
+func ListStreams(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeStreamRead)
+	if !ok {
+		return
+	}
+
+	streams, err := registry.Default().List()
+	if err != nil {
+		http.Error(w, "Failed to list streams", http.StatusInternalServerError)
+		log.Printf("Error listing streams: %v", err)
+		return
+	}
+
+	visible := []models.Stream{}
+	for _, stream := range streams {
+		if auth.CheckStreamAccess(principal, stream, auth.ActionView) == nil {
+			visible = append(visible, stream)
+		}
+	}
+
+	response := StreamListResponse{Streams: visible}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding ListStreams response: %v", err)
+	}
+}
+
+// GetStream returns the registry record for a single stream
+func GetStream(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeStreamRead)
+	if !ok {
+		return
+	}
+
+	stream, ok := lookupStream(w, mux.Vars(r)["stream_id"])
+	if !ok || !requireStreamAccess(w, r, principal, stream, auth.ActionView) {
+		return
+	}
+	writeStream(w, http.StatusOK, stream)
+}
+
+// DeleteStream ends a stream: running readers are cancelled and WebSocket subscribers
+// are disconnected. With a grace period (the "grace" query parameter or STREAM_DELETE_GRACE)
+// the stream is soft-deleted and its topic kept until the period ends; otherwise the topic
+// is deleted immediately.
+func DeleteStream(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeStreamCreate)
+	if !ok {
+		return
+	}
+
+	streamID := mux.Vars(r)["stream_id"]
+	stream, ok := lookupStream(w, streamID)
+	if !ok || !requireStreamAccess(w, r, principal, stream, auth.ActionManage) {
+		return
+	}
+
+	grace := config.GetEnvDuration("STREAM_DELETE_GRACE", 0)
+	if value := r.URL.Query().Get("grace"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid grace period: expected a duration such as 30m", http.StatusBadRequest)
+			return
+		}
+		grace = parsed
+	}
+
+	stopStream(streamID, "stream deleted")
+
+	if grace > 0 {
+		stream, err := registry.Default().ScheduleDeletion(streamID, time.Now().Add(grace))
+		if err != nil {
+			http.Error(w, "Failed to delete stream", http.StatusInternalServerError)
+			log.Printf("Error scheduling deletion of stream %s: %v", streamID, err)
+			return
+		}
+		log.Printf("Stream %s soft-deleted, topic will be removed after %s", streamID, stream.DeleteAfter.Format(time.RFC3339))
+		record(r, principal, audit.Event{Type: audit.EventStreamDelete, Outcome: audit.OutcomeSuccess, Resource: streamID, Reason: "soft-deleted until " + stream.DeleteAfter.Format(time.RFC3339)})
+		writeStream(w, http.StatusAccepted, stream)
+		return
+	}
+
+	if err := purgeStream(stream); err != nil {
+		// Leave the stream pending so the janitor retries the topic deletion
+		log.Printf("Error deleting stream %s, will retry: %v", streamID, err)
+		stream, err := registry.Default().ScheduleDeletion(streamID, time.Now())
+		if err != nil {
+			http.Error(w, "Failed to delete stream", http.StatusInternalServerError)
+			log.Printf("Error scheduling deletion of stream %s: %v", streamID, err)
+			return
+		}
+		record(r, principal, audit.Event{Type: audit.EventStreamDelete, Outcome: audit.OutcomeSuccess, Resource: streamID, Reason: "topic deletion pending retry"})
+		writeStream(w, http.StatusAccepted, stream)
+		return
+	}
+
+	log.Printf("Stream %s deleted", streamID)
+	record(r, principal, audit.Event{Type: audit.EventStreamDelete, Outcome: audit.OutcomeSuccess, Resource: streamID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreStream reactivates a soft-deleted stream whose grace period has not ended
+func RestoreStream(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeStreamCreate)
+	if !ok {
+		return
+	}
+
+	streamID := mux.Vars(r)["stream_id"]
+	current, ok := lookupStream(w, streamID)
+	if !ok || !requireStreamAccess(w, r, principal, current, auth.ActionManage) {
+		return
+	}
+	stream, err := registry.Default().Restore(streamID)
+	switch {
+	case errors.Is(err, registry.ErrStreamNotFound):
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	case errors.Is(err, registry.ErrStreamNotPending):
+		http.Error(w, "Stream is not pending deletion", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to restore stream", http.StatusInternalServerError)
+		log.Printf("Error restoring stream %s: %v", streamID, err)
+		return
+	}
+
+	log.Printf("Stream %s restored", streamID)
+	record(r, principal, audit.Event{Type: audit.EventStreamRestore, Outcome: audit.OutcomeSuccess, Resource: streamID})
+	writeStream(w, http.StatusOK, stream)
+}
+
+// stopStream cancels the stream's Kafka readers and closes its WebSocket subscribers.
+func stopStream(streamID, reason string) {
+	readers := kafka.StopReaders(streamID)
+	conns := closeSubscribers(streamID, reason)
+	log.Printf("Stopped stream %s: %d readers cancelled, %d WebSocket connections closed", streamID, readers, conns)
+}
+
+// purgeStream deletes the stream's Kafka topic and cursor and marks it deleted in the registry.
+func purgeStream(stream models.Stream) error {
+	if err := kafka.DeleteTopic(config.GetKafkaBroker(), stream.Topic()); err != nil {
+		return err
+	}
+	if err := kafka.DeleteCursor(stream); err != nil {
+		log.Printf("Error deleting cursor for stream %s: %v", stream.ID, err)
+	}
+	if err := schemas.Default().Delete(stream.ID); err != nil {
+		log.Printf("Error deleting schemas for stream %s: %v", stream.ID, err)
+	}
+	if err := registry.Default().Delete(stream.ID); err != nil {
+		return err
+	}
+	tenants.Default().Release(stream.Tenant, stream.WrittenBytes)
+	quotas.Default().ForgetStream(stream.ID)
+	return nil
+}
+
+// StartJanitor periodically expires streams past their TTL or idle timeout and purges
+// soft-deleted streams whose grace period has ended. It runs until ctx is cancelled; a
+// non-positive interval leaves it disabled.
+func StartJanitor(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		log.Printf("Warning: stream janitor disabled, interval %s is not positive", interval)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := registry.Default().FlushActivity(); err != nil {
+					log.Printf("Error persisting stream activity: %v", err)
+				}
+				expireStreams()
+				purgeDueStreams()
+				purgeTombstones()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// purgeDueStreams permanently deletes every stream whose grace period has ended.
+func purgeDueStreams() {
+	due, err := registry.Default().DueForDeletion(time.Now())
+	if err != nil {
+		log.Printf("Error listing streams due for deletion: %v", err)
+		return
+	}
+	for _, stream := range due {
+		if err := purgeStream(stream); err != nil {
+			log.Printf("Error purging stream %s, will retry: %v", stream.ID, err)
+			continue
+		}
+		log.Printf("Stream %s purged after grace period", stream.ID)
+		audit.Record(nil, audit.Event{Type: audit.EventStreamDelete, Outcome: audit.OutcomeSuccess, Principal: "system", Resource: stream.ID, Reason: "purged after grace period"})
+	}
+}
+
+// purgeTombstones forgets streams deleted longer than STREAM_TOMBSTONE_RETENTION ago.
+func purgeTombstones() {
+	retention := config.GetEnvDuration("STREAM_TOMBSTONE_RETENTION", 24*time.Hour)
+	purged, err := registry.Default().PurgeTombstones(time.Now().Add(-retention))
+	if err != nil {
+		log.Printf("Error purging deleted stream records: %v", err)
+	}
+	if purged > 0 {
+		log.Printf("Purged %d deleted stream records", purged)
+	}
+}
+
+// expireStreams stops and deletes every active stream whose TTL or idle timeout has run out.
+func expireStreams() {
+	expired, err := registry.Default().Expired(time.Now(), hasSubscribers)
+	if err != nil {
+		log.Printf("Error listing expired streams: %v", err)
+		return
+	}
+	for _, expiry := range expired {
+		stream := expiry.Stream
+		log.Printf("Stream %s expired (%s), tearing down", stream.ID, expiry.Reason)
+		stopStream(stream.ID, "stream expired")
+		if err := purgeStream(stream); err != nil {
+			// Leave the stream for purgeDueStreams to retry
+			log.Printf("Error deleting expired stream %s, will retry: %v", stream.ID, err)
+			if _, err := registry.Default().ScheduleDeletion(stream.ID, time.Now()); err != nil {
+				log.Printf("Error scheduling deletion of expired stream %s: %v", stream.ID, err)
+			}
+		}
+		streamExpirations.WithLabelValues(expiry.Reason).Inc()
+		audit.Record(nil, audit.Event{Type: audit.EventStreamDelete, Outcome: audit.OutcomeSuccess, Principal: "system", Resource: stream.ID, Reason: "expired (" + expiry.Reason + ")"})
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"blockhouse/registry"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	subscribersMu sync.Mutex
+	subscribers   = make(map[string]map[*websocket.Conn]struct{}) // Open WebSocket connections by stream ID
+)
+
+// addSubscriber records an open WebSocket connection for a stream.
+func addSubscriber(streamID string, conn *websocket.Conn) {
+	registry.Default().Touch(streamID)
+
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+
+	if subscribers[streamID] == nil {
+		subscribers[streamID] = make(map[*websocket.Conn]struct{})
+	}
+	subscribers[streamID][conn] = struct{}{}
+}
+
+// removeSubscriber forgets a WebSocket connection once its handler returns.
+// The stream's idle timeout starts counting from the last disconnect.
+func removeSubscriber(streamID string, conn *websocket.Conn) {
+	registry.Default().Touch(streamID)
+
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+
+	delete(subscribers[streamID], conn)
+	if len(subscribers[streamID]) == 0 {
+		delete(subscribers, streamID)
+	}
+}
+
+// hasSubscribers reports whether any WebSocket connection is open on a stream.
+func hasSubscribers(streamID string) bool {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+
+	return len(subscribers[streamID]) > 0
+}
+
+// closeSubscribers sends a close frame with the given reason to every WebSocket
+// connection on a stream and closes it. It returns the number of connections closed.
+func closeSubscribers(streamID, reason string) int {
+	subscribersMu.Lock()
+	conns := make([]*websocket.Conn, 0, len(subscribers[streamID]))
+	for conn := range subscribers[streamID] {
+		conns = append(conns, conn)
+	}
+	subscribersMu.Unlock()
+
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+	for _, conn := range conns {
+		// WriteControl is safe to call concurrently with the handler's writes
+		if err := conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second)); err != nil {
+			log.Printf("Error sending close frame on stream %s: %v", streamID, err)
+		}
+		conn.Close()
+	}
+	return len(conns)
+}
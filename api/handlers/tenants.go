@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"blockhouse/audit"
+	"blockhouse/auth"
+	"blockhouse/models"
+	"blockhouse/registry"
+	"blockhouse/tenants"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateTenantRequest represents the request body for registering a tenant
+type CreateTenantRequest struct {
+	ID     string              `json:"id"`
+	Name   string              `json:"name"`
+	Tier   string              `json:"tier"`
+	Limits models.TenantLimits `json:"limits"`
+}
+
+// SetTenantTierRequest represents the request body for moving a tenant to another tier
+type SetTenantTierRequest struct {
+	Tier string `json:"tier"`
+}
+
+// TenantResponse represents a tenant with its current stream count and write volume
+type TenantResponse struct {
+	models.Tenant
+	Usage registry.TenantUsage `json:"usage"`
+}
+
+// TenantListResponse represents the response structure when listing tenants
+type TenantListResponse struct {
+	Tenants []TenantResponse `json:"tenants"`
+}
+
+// requireTenantQuota turns the result of a tenant limit check into a response: 429 when the
+// tenant is producing too fast, 403 when it holds too many streams, has reached its write volume
+// or does not exist. It reports whether the caller may continue.
+func requireTenantQuota(w http.ResponseWriter, r *http.Request, principal auth.Principal, err error) bool {
+	if err == nil {
+		return true
+	}
+	tenant := models.TenantOf(principal.Tenant)
+	switch {
+	case errors.Is(err, tenants.ErrThroughputLimit):
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too Many Requests: "+err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, tenants.ErrStreamLimit), errors.Is(err, tenants.ErrWriteVolumeLimit):
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, tenants.ErrTenantNotFound):
+		err = fmt.Errorf("%w: %s", err, tenant)
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "Failed to check tenant limits", http.StatusInternalServerError)
+		log.Printf("Error checking limits for tenant %s: %v", tenant, err)
+		return false
+	}
+	log.Printf("Refused request from %s for tenant %s: %v", principal.ID, tenant, err)
+	record(r, principal, audit.Event{Type: audit.EventAccessDenied, Outcome: audit.OutcomeDenied, Resource: tenant, Reason: err.Error()})
+	return false
+}
+
+// tenantResponse adds a tenant's current usage to its record
+func tenantResponse(tenant models.Tenant) (TenantResponse, error) {
+	usage, err := tenants.Default().Usage(tenant.ID)
+	return TenantResponse{Tenant: tenant, Usage: usage}, err
+}
+
+// writeTenant encodes a tenant and its usage as the JSON response body with the given status
+func writeTenant(w http.ResponseWriter, status int, tenant models.Tenant) {
+	response, err := tenantResponse(tenant)
+	if err != nil {
+		http.Error(w, "Failed to read tenant usage", http.StatusInternalServerError)
+		log.Printf("Error reading usage of tenant %s: %v", tenant.ID, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding response for tenant %s: %v", tenant.ID, err)
+	}
+}
+
+// CreateTenant registers a tenant with optional limits
+func CreateTenant(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeAdmin)
+	if !ok {
+		return
+	}
+
+	var req CreateTenantRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "Invalid tenant: an id is required", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		req.Name = req.ID
+	}
+
+	tenant, err := tenants.Default().Create(models.Tenant{ID: req.ID, Name: req.Name, Tier: req.Tier, Limits: req.Limits})
+	switch {
+	case errors.Is(err, tenants.ErrInvalidTenant):
+		http.Error(w, "Invalid tenant: "+err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, tenants.ErrTenantExists):
+		http.Error(w, "Tenant already exists", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to create tenant", http.StatusInternalServerError)
+		log.Printf("Error creating tenant %s: %v", req.ID, err)
+		return
+	}
+
+	log.Printf("Created tenant %s (%s)", tenant.ID, tenant.Name)
+	record(r, principal, audit.Event{Type: audit.EventTenantCreate, Outcome: audit.OutcomeSuccess, Resource: tenant.ID})
+	writeTenant(w, http.StatusCreated, tenant)
+}
+
+// ListTenants returns every tenant with its current usage
+func ListTenants(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r, models.ScopeAdmin); !ok {
+		return
+	}
+
+	list, err := tenants.Default().List()
+	if err != nil {
+		http.Error(w, "Failed to list tenants", http.StatusInternalServerError)
+		log.Printf("Error listing tenants: %v", err)
+		return
+	}
+	response := TenantListResponse{Tenants: []TenantResponse{}}
+	for _, tenant := range list {
+		item, err := tenantResponse(tenant)
+		if err != nil {
+			http.Error(w, "Failed to read tenant usage", http.StatusInternalServerError)
+			log.Printf("Error reading usage of tenant %s: %v", tenant.ID, err)
+			return
+		}
+		response.Tenants = append(response.Tenants, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding ListTenants response: %v", err)
+	}
+}
+
+// GetTenant returns a tenant's limits and current usage
+func GetTenant(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorize(w, r, models.ScopeAdmin); !ok {
+		return
+	}
+
+	tenant, err := tenants.Default().Get(mux.Vars(r)["tenant_id"])
+	if err != nil {
+		if errors.Is(err, tenants.ErrTenantNotFound) {
+			http.Error(w, "Tenant not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to read tenant", http.StatusInternalServerError)
+		log.Printf("Error reading tenant: %v", err)
+		return
+	}
+	writeTenant(w, http.StatusOK, tenant)
+}
+
+// SetTenantLimits replaces a tenant's limits; omitted limits become unlimited
+func SetTenantLimits(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeAdmin)
+	if !ok {
+		return
+	}
+
+	var limits models.TenantLimits
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&limits); err != nil {
+		http.Error(w, "Invalid tenant limits: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tenantID := mux.Vars(r)["tenant_id"]
+	tenant, err := tenants.Default().SetLimits(tenantID, limits)
+	switch {
+	case errors.Is(err, tenants.ErrInvalidTenant):
+		http.Error(w, "Invalid tenant limits: "+err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, tenants.ErrTenantNotFound):
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update tenant limits", http.StatusInternalServerError)
+		log.Printf("Error updating limits of tenant %s: %v", tenantID, err)
+		return
+	}
+
+	log.Printf("Updated limits of tenant %s: %+v", tenant.ID, tenant.Limits)
+	record(r, principal, audit.Event{Type: audit.EventTenantLimits, Outcome: audit.OutcomeSuccess, Resource: tenant.ID, Reason: fmt.Sprintf("%+v", tenant.Limits)})
+	writeTenant(w, http.StatusOK, tenant)
+}
+
+// SetTenantTier moves a tenant to another tier, which selects the rate limit policies applied
+// to its callers; an empty tier removes it from any tier
+func SetTenantTier(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeAdmin)
+	if !ok {
+		return
+	}
+
+	var req SetTenantTierRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "Invalid tenant tier: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tenantID := mux.Vars(r)["tenant_id"]
+	tenant, err := tenants.Default().SetTier(tenantID, req.Tier)
+	switch {
+	case errors.Is(err, tenants.ErrInvalidTenant):
+		http.Error(w, "Invalid tenant tier: "+err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, tenants.ErrTenantNotFound):
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update tenant tier", http.StatusInternalServerError)
+		log.Printf("Error updating tier of tenant %s: %v", tenantID, err)
+		return
+	}
+
+	log.Printf("Moved tenant %s to tier %q", tenant.ID, tenant.Tier)
+	record(r, principal, audit.Event{Type: audit.EventTenantLimits, Outcome: audit.OutcomeSuccess, Resource: tenant.ID, Reason: "tier=" + tenant.Tier})
+	writeTenant(w, http.StatusOK, tenant)
+}
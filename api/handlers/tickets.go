@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"blockhouse/auth"
+	"blockhouse/models"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// TicketRequest represents the request body for a WebSocket ticket
+type TicketRequest struct {
+	StreamID string `json:"stream_id"`
+}
+
+// TicketResponse returns a single-use WebSocket ticket
+type TicketResponse struct {
+	Ticket    string    `json:"ticket"`     // Passed as ?ticket= or as the "ticket.<ticket>" WebSocket subprotocol
+	StreamID  string    `json:"stream_id"`  // The only stream the ticket opens
+	ExpiresAt time.Time `json:"expires_at"` // The ticket must be used before this time
+}
+
+// IssueTicket exchanges an authenticated request carrying the stream's consumer token for a
+// short-lived, single-use ticket, so WebSocket clients never put the API key or token in a URL
+func IssueTicket(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeStreamRead)
+	if !ok {
+		return
+	}
+
+	var req TicketRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.StreamID == "" {
+		http.Error(w, "Invalid ticket request: stream_id is required", http.StatusBadRequest)
+		return
+	}
+	stream, ok := requireStream(w, req.StreamID)
+	if !ok || !requireStreamAccess(w, r, principal, stream, auth.ActionConsume) {
+		return
+	}
+	if !requireStreamToken(w, r, stream, auth.TokenConsume) {
+		return
+	}
+
+	ticket, expiresAt, err := auth.DefaultTickets().Issue(principal, stream.ID)
+	if err != nil {
+		http.Error(w, "Failed to issue WebSocket ticket", http.StatusInternalServerError)
+		log.Printf("Error issuing WebSocket ticket for stream %s: %v", stream.ID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(TicketResponse{Ticket: ticket, StreamID: stream.ID, ExpiresAt: expiresAt.UTC()}); err != nil {
+		log.Printf("Error encoding IssueTicket response for stream %s: %v", stream.ID, err)
+	}
+}
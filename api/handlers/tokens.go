@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"blockhouse/audit"
+	"blockhouse/auth"
+	"blockhouse/models"
+	"blockhouse/registry"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// StreamTokens holds the signed tokens that grant access to a single stream
+type StreamTokens struct {
+	Producer  string     `json:"producer,omitempty"`   // Sent as X-Stream-Token to /stream/{stream_id}/send
+	Consumer  string     `json:"consumer,omitempty"`   // Sent as X-Stream-Token to /results and /ws/ticket
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Time both tokens stop working, if they expire
+}
+
+// IssueTokensRequest represents the optional request body for issuing new stream tokens
+type IssueTokensRequest struct {
+	ExpiresIn string `json:"expires_in"`
+}
+
+// issueStreamTokens signs a producer and consumer token for the stream's current token generation
+func issueStreamTokens(stream models.Stream, ttl time.Duration) (StreamTokens, error) {
+	return issueTokensFor(stream, ttl, true, true)
+}
+
+// issueTokensFor signs the selected tokens for the stream's current token generation
+func issueTokensFor(stream models.Stream, ttl time.Duration, produce, consume bool) (StreamTokens, error) {
+	signer := auth.DefaultSigner()
+	var tokens StreamTokens
+	var err error
+	if produce {
+		if tokens.Producer, err = signer.Issue(stream.ID, auth.TokenProduce, stream.TokenGeneration, ttl); err != nil {
+			return StreamTokens{}, err
+		}
+	}
+	if consume {
+		if tokens.Consumer, err = signer.Issue(stream.ID, auth.TokenConsume, stream.TokenGeneration, ttl); err != nil {
+			return StreamTokens{}, err
+		}
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().UTC().Add(ttl).Truncate(time.Second)
+		tokens.ExpiresAt = &expiresAt
+	}
+	return tokens, nil
+}
+
+// requireStreamToken verifies that the request carries a valid stream token for use on the
+// stream, writing a 403 response otherwise. It reports whether the caller may continue.
+func requireStreamToken(w http.ResponseWriter, r *http.Request, stream models.Stream, use string) bool {
+	token := auth.RequestStreamToken(r)
+	if token == "" {
+		http.Error(w, "Forbidden: missing stream token", http.StatusForbidden)
+		return false
+	}
+	if _, err := auth.DefaultSigner().Verify(token, stream.ID, use, stream.TokenGeneration); err != nil {
+		log.Printf("Rejected %s token for stream %s: %v", use, stream.ID, err)
+		audit.Record(r, audit.Event{Type: audit.EventAuthFailure, Outcome: audit.OutcomeFailure, Resource: stream.ID, Reason: use + " token: " + err.Error()})
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// IssueStreamTokens returns fresh tokens for a stream. Callers who may manage the stream get
+// a producer and consumer token; others get the tokens their scopes and roles allow, so a
+// principal granted the consumer role can fetch its own consumer token. Tokens issued
+// earlier remain valid until they expire or the stream's tokens are revoked.
+func IssueStreamTokens(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	// Every role allows view, so this only turns away callers with no role or from another tenant
+	stream, ok := requireStream(w, mux.Vars(r)["stream_id"])
+	if !ok || !requireStreamAccess(w, r, principal, stream, auth.ActionView) {
+		return
+	}
+
+	manage := principal.HasScope(models.ScopeStreamCreate) && auth.CheckStreamAccess(principal, stream, auth.ActionManage) == nil
+	produce := manage || (principal.HasScope(models.ScopeStreamWrite) && auth.CheckStreamAccess(principal, stream, auth.ActionProduce) == nil)
+	consume := manage || (principal.HasScope(models.ScopeStreamRead) && auth.CheckStreamAccess(principal, stream, auth.ActionConsume) == nil)
+	if !produce && !consume {
+		log.Printf("Denied token issue on stream %s to %s", stream.ID, principal.ID)
+		record(r, principal, audit.Event{Type: audit.EventAccessDenied, Outcome: audit.OutcomeDenied, Resource: stream.ID, Reason: "no role allowing stream tokens"})
+		http.Error(w, "Forbidden: caller may neither produce to nor consume from stream "+stream.ID, http.StatusForbidden)
+		return
+	}
+
+	var req IssueTokensRequest
+	if r.Body != nil && r.Body != http.NoBody {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid token request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	ttl, err := parseOptionalDuration("expires_in", req.ExpiresIn)
+	if err != nil {
+		http.Error(w, "Invalid token request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := issueTokensFor(stream, ttl, produce, consume)
+	if err != nil {
+		http.Error(w, "Failed to issue stream tokens", http.StatusInternalServerError)
+		log.Printf("Error issuing tokens for stream %s: %v", stream.ID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		log.Printf("Error encoding IssueStreamTokens response for stream %s: %v", stream.ID, err)
+	}
+}
+
+// RevokeStreamTokens invalidates every token issued for a stream so far and disconnects
+// WebSocket subscribers that connected with them. New tokens can then be issued.
+func RevokeStreamTokens(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorize(w, r, models.ScopeStreamCreate)
+	if !ok {
+		return
+	}
+
+	streamID := mux.Vars(r)["stream_id"]
+	stream, ok := lookupStream(w, streamID)
+	if !ok || !requireStreamAccess(w, r, principal, stream, auth.ActionManage) {
+		return
+	}
+
+	if _, err := registry.Default().RevokeTokens(streamID); err != nil {
+		if errors.Is(err, registry.ErrStreamNotFound) {
+			http.Error(w, "Stream not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to revoke stream tokens", http.StatusInternalServerError)
+		log.Printf("Error revoking tokens for stream %s: %v", streamID, err)
+		return
+	}
+	stopStream(streamID, "stream tokens revoked")
+	log.Printf("Revoked tokens for stream %s", streamID)
+	record(r, principal, audit.Event{Type: audit.EventTokensRevoke, Outcome: audit.OutcomeSuccess, Resource: streamID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,13 +1,31 @@
 package middleware
 
 import (
-	"blockhouse/api/handlers"
+	"blockhouse/audit"
+	"blockhouse/auth"
+	"blockhouse/clientip"
 	"blockhouse/config"
+	"blockhouse/idempotency"
+	"blockhouse/models"
+	"blockhouse/ratelimit"
+	"blockhouse/store"
+	"blockhouse/tenants"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
-	"sync"
+	"strconv"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/time/rate"
 )
@@ -24,6 +42,16 @@ var (
 		[]string{"path", "method"},
 	)
 
+	// Counts authenticated API key requests by key name and version, so a rotation can be
+	// watched until nothing uses the old version any more
+	apiKeyRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "api_key_requests_total",
+			Help: "Total number of requests authenticated with each API key version",
+		},
+		[]string{"key", "version"},
+	)
+
 	// Counts the total rate limit denials
 	rateLimitDenials = prometheus.NewCounter(
 		prometheus.CounterOpts{
@@ -31,23 +59,125 @@ var (
 			Help: "Total number of rate-limited requests denied",
 		},
 	)
+
+	// Counts rate limit checks that failed because the backend could not be reached
+	rateLimitBackendErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "http_rate_limit_backend_errors_total",
+			Help: "Total number of rate limit checks that failed because of a backend error",
+		},
+	)
 )
 
 func init() {
-	prometheus.MustRegister(requestDuration, rateLimitDenials) // Register Prometheus metrics
+	prometheus.MustRegister(requestDuration, apiKeyRequests, rateLimitDenials, rateLimitBackendErrors) // Register Prometheus metrics
+}
+
+// RequestIDMiddleware assigns every request an ID, reusing a well-formed X-Request-ID sent by
+// the client, and echoes it in the response so audit records can be correlated with callers
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(audit.HeaderRequestID)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(audit.HeaderRequestID, id)
+		next.ServeHTTP(w, r.WithContext(audit.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts short IDs made of characters that are safe to log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// authFailure records a rejected authentication attempt in the audit log
+func authFailure(r *http.Request, err error) {
+	audit.Record(r, audit.Event{Type: audit.EventAuthFailure, Outcome: audit.OutcomeFailure, Reason: err.Error()})
+}
+
+// authSuccess records an authenticated request in the audit log, and for API keys which key
+// version it used in the process log and metrics
+func authSuccess(r *http.Request, principal auth.Principal) {
+	audit.Record(r, audit.Event{Type: audit.EventAuthSuccess, Outcome: audit.OutcomeSuccess, Principal: principal.ID, Method: principal.Method})
+	if principal.Method == auth.MethodAPIKey {
+		log.Printf("Authenticated %s %s with API key %s version %s", r.Method, r.URL.Path, principal.Name, principal.KeyVersion)
+		apiKeyRequests.WithLabelValues(principal.Name, principal.KeyVersion).Inc()
+	}
 }
 
-// AuthMiddleware validates API key using handlers' validation function
+// AuthMiddleware authenticates the caller with an API key or bearer JWT, as selected by
+// AUTH_MODE, and stores the principal in the request context so handlers can check its scopes.
+// Every attempt is recorded in the audit log.
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !handlers.ValidateAPIKey(r) {
-			http.Error(w, "Unauthorized: invalid or missing API key", http.StatusUnauthorized)
+		if _, ok := auth.PrincipalFromContext(r.Context()); ok {
+			// Already authenticated and audited earlier in the chain
+			next.ServeHTTP(w, r)
 			return
 		}
-		next.ServeHTTP(w, r)
+		principal, err := auth.Authenticate(r)
+		if err != nil {
+			authFailure(r, err)
+			auth.SetChallenge(w)
+			http.Error(w, "Unauthorized: invalid or missing credentials", http.StatusUnauthorized)
+			return
+		}
+		authSuccess(r, principal)
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
 	})
 }
 
+// Names of the routes whose requests must be signed when signing is required
+const (
+	RouteStartStream = "start_stream"
+	RouteSendData    = "send_data"
+	RouteSendBatch   = "send_batch"
+)
+
+var signedRoutes = map[string]bool{RouteStartStream: true, RouteSendData: true, RouteSendBatch: true}
+
+// RequestSigningMiddleware verifies HMAC request signatures, rejecting stale timestamps and
+// replayed nonces, and stores the signing key's principal in the request context. When
+// required is set, unsigned requests to the producer endpoints are rejected.
+func RequestSigningMiddleware(required bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !auth.IsSigned(r) {
+				if route := mux.CurrentRoute(r); required && route != nil && signedRoutes[route.GetName()] {
+					audit.Record(r, audit.Event{Type: audit.EventAuthFailure, Outcome: audit.OutcomeFailure, Reason: "unsigned request to an endpoint that requires signing"})
+					http.Error(w, "Unauthorized: this endpoint requires a signed request", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			principal, err := auth.Authenticate(r)
+			if errors.Is(err, auth.ErrBodyTooLarge) {
+				http.Error(w, "Payload Too Large: "+err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				log.Printf("Rejected signed request %s %s: %v", r.Method, r.URL.Path, err)
+				authFailure(r, err)
+				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			authSuccess(r, principal)
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
 // LoggingMiddleware logs each request with method, path, status, and duration
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -77,72 +207,129 @@ func (sw *statusWriter) WriteHeader(code int) {
 	sw.ResponseWriter.WriteHeader(code)
 }
 
-// APIKeyAuthMiddleware verifies the API key from the request header
-func APIKeyAuthMiddleware(next http.Handler) http.Handler {
-	var (
-		once         sync.Once
-		cachedAPIKey string
-	)
-
-	// Load and cache API key once
-	loadAPIKey := func() {
-		cachedAPIKey = config.GetAPIKey()
-		if cachedAPIKey == "" {
-			log.Println("Warning: API_KEY is not set in the environment.")
-		}
+// Hijack hands the connection over to a WebSocket upgrade
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
 	}
+	sw.statusCode = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
 
+// APIKeyAuthMiddleware authenticates the caller like AuthMiddleware, responding with a JSON error body
+func APIKeyAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		once.Do(loadAPIKey)
-		apiKey := r.Header.Get("X-API-Key")
-		if apiKey != cachedAPIKey {
-			log.Println("Unauthorized request: invalid or missing API key")
-			http.Error(w, `{"error": "Unauthorized: invalid or missing API key"}`, http.StatusUnauthorized)
+		principal, err := auth.Authenticate(r)
+		if err != nil {
+			log.Printf("Unauthorized request %s %s from %s: %v", r.Method, r.URL.Path, audit.SourceIP(r), err)
+			authFailure(r, err)
+			auth.SetChallenge(w)
+			http.Error(w, `{"error": "Unauthorized: invalid or missing credentials"}`, http.StatusUnauthorized)
 			return
 		}
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
 	})
 }
 
-// RateLimiter manages rate limiting for each client
+// RateLimiter applies the rate limit policies in force to each request, keeping its buckets in
+// a backend
 type RateLimiter struct {
-	limiters map[string]*rate.Limiter
-	mu       sync.Mutex
-	rate     rate.Limit
-	burst    int
+	backend   ratelimit.Backend
+	policies  *ratelimit.Engine
+	clientKey string
+	failOpen  bool
 }
 
-// NewRateLimiter initializes a new RateLimiter instance with specified rate and burst limit
+// NewRateLimiter initializes a new RateLimiter instance with specified rate and burst limit,
+// keeping its buckets in memory
 func NewRateLimiter(r rate.Limit, b int) *RateLimiter {
-	return &RateLimiter{
-		limiters: make(map[string]*rate.Limiter),
-		rate:     r,
-		burst:    b,
+	return NewBackendRateLimiter(ratelimit.NewMemoryBackend(), r, b, true)
+}
+
+// NewBackendRateLimiter creates a RateLimiter applying the same limit to every client address and
+// keeping its buckets in backend. When failOpen is set, requests are let through while the
+// backend is failing; otherwise they are refused.
+func NewBackendRateLimiter(backend ratelimit.Backend, r rate.Limit, b int, failOpen bool) *RateLimiter {
+	policies, err := ratelimit.NewEngine(store.NewMemoryStore(), ratelimit.PolicySet{Default: ratelimit.Limit{Rate: float64(r), Burst: b}})
+	if err != nil {
+		log.Fatalf("Invalid rate limit: %v", err)
 	}
+	return NewPolicyRateLimiter(backend, policies, config.ClientKeyIP, failOpen)
 }
 
-// getLimiter retrieves or creates a rate limiter for a specific client IP
-func (rl *RateLimiter) getLimiter(clientIP string) *rate.Limiter {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+// NewPolicyRateLimiter creates a RateLimiter applying the policies held by policies, so they
+// can be changed while it runs. clientKey selects whether clients are told apart by address
+// (config.ClientKeyIP) or by the API key or identity they authenticated with
+// (config.ClientKeyPrincipal).
+func NewPolicyRateLimiter(backend ratelimit.Backend, policies *ratelimit.Engine, clientKey string, failOpen bool) *RateLimiter {
+	return &RateLimiter{backend: backend, policies: policies, clientKey: clientKey, failOpen: failOpen}
+}
 
-	limiter, exists := rl.limiters[clientIP]
-	if !exists {
-		limiter = rate.NewLimiter(rl.rate, rl.burst)
-		rl.limiters[clientIP] = limiter
+// rateLimitRequest collects what rate limit policies can match on
+func (rl *RateLimiter) rateLimitRequest(r *http.Request) ratelimit.Request {
+	// The address is resolved without its port, so that every connection from a client shares a
+	// bucket, and through trusted proxies only
+	req := ratelimit.Request{Client: clientip.ClientIP(r), Method: r.Method, Stream: mux.Vars(r)["stream_id"]}
+	if identity, ok := auth.ClientCertIdentity(r); ok {
+		// Callers with a client certificate are limited per certificate, not per address
+		req.Client = "cert:" + identity
+	}
+	if route := mux.CurrentRoute(r); route != nil {
+		req.Route, _ = route.GetPathTemplate()
+	}
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		req.Principal, req.Name, req.Tenant = principal.ID, principal.Name, models.TenantOf(principal.Tenant)
+		if rl.clientKey == config.ClientKeyPrincipal {
+			req.Client = "principal:" + principal.ID
+		}
+		if rl.policies.UsesTier() {
+			if tenant, err := tenants.Default().Get(req.Tenant); err == nil {
+				req.Tier = tenant.Tier
+			}
+		}
 	}
-	return limiter
+	return req
 }
 
-// RateLimitMiddleware applies rate limiting based on client IP and increments denial count on limit exceeded
+// allow takes one request from the bucket for key. Backend errors are logged, counted and
+// resolved according to failOpen, and reported as an unchecked result.
+func (rl *RateLimiter) allow(r *http.Request, key string, limit ratelimit.Limit) (ratelimit.Result, bool) {
+	result, err := rl.backend.Allow(r.Context(), key, limit)
+	if err != nil {
+		rateLimitBackendErrors.Inc()
+		log.Printf("Rate limiter unavailable for %s (fail open: %t): %v", key, rl.failOpen, err)
+		return ratelimit.Result{Allowed: rl.failOpen}, false
+	}
+	return result, true
+}
+
+// seconds rounds a duration up to whole seconds for rate limit headers
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// RateLimitMiddleware applies the first rate limit policy matching each request, sets the
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers, and answers 429 with
+// Retry-After when the policy's bucket is empty
 func RateLimitMiddleware(rl *RateLimiter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			clientIP := r.RemoteAddr // Adjust for proxy if needed
-			limiter := rl.getLimiter(clientIP)
-
-			if !limiter.Allow() {
+			req := rl.rateLimitRequest(r)
+			policy := rl.policies.Match(req)
+			result, checked := rl.allow(r, policy.Key(req), policy.Limit)
+			if checked {
+				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(policy.Burst))
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+				w.Header().Set("X-RateLimit-Reset", seconds(result.ResetAfter))
+			}
+			if !result.Allowed {
 				rateLimitDenials.Inc()
+				retryAfter := "1"
+				if checked && result.RetryAfter > time.Second {
+					retryAfter = seconds(result.RetryAfter)
+				}
+				w.Header().Set("Retry-After", retryAfter)
 				http.Error(w, "Too many requests", http.StatusTooManyRequests)
 				return
 			}
@@ -150,3 +337,135 @@ func RateLimitMiddleware(rl *RateLimiter) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// Names of the routes whose request bodies are limited to MAX_BODY_BYTES
+var bodyLimitRoutes = map[string]bool{RouteSendData: true, RouteSendBatch: true}
+
+// BodyLimitMiddleware refuses produce requests whose body is larger than maxBodyBytes with 413,
+// measuring bodies sent without a Content-Length. Byte quotas are charged by the produce handlers
+// once the caller is authorized and the payload is valid. Zero means no limit.
+func BodyLimitMiddleware(maxBodyBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if maxBodyBytes <= 0 || route == nil || !bodyLimitRoutes[route.GetName()] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			size := r.ContentLength
+			if size < 0 {
+				// Without a Content-Length the body has to be read to be measured
+				body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+				if err != nil {
+					http.Error(w, "Failed to read request body", http.StatusBadRequest)
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				size = int64(len(body))
+			}
+			if size > maxBodyBytes {
+				http.Error(w, fmt.Sprintf("Payload Too Large: bodies are limited to %d bytes", maxBodyBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Names of the routes that honour an Idempotency-Key header
+var idempotentRoutes = map[string]bool{RouteSendData: true, RouteSendBatch: true}
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+// recordingWriter passes a response through while keeping a copy of it
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	contentType string
+	body        bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status, rw.contentType = code, rw.ResponseWriter.Header().Get("Content-Type")
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// IdempotencyMiddleware remembers the successful response to each produce request carrying an
+// Idempotency-Key header, per stream and caller, and has the handler replay it with
+// idempotency.Replay when the request is retried with the same key instead of writing the data
+// again. A key sent with a different request is refused with 422, and one whose first request
+// is still running, including an asynchronous write deferred with idempotency.Defer, with 409.
+// Requests that fail are forgotten so that retrying them carries them out. Bodies larger than
+// maxBodyBytes are passed on unread, for the body limit to refuse.
+func IdempotencyMiddleware(cache *idempotency.Cache, maxBodyBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Idempotency-Key")
+			route := mux.CurrentRoute(r)
+			if header == "" || route == nil || !idempotentRoutes[route.GetName()] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(header) > maxIdempotencyKeyLength {
+				http.Error(w, fmt.Sprintf("Invalid Idempotency-Key: keys are limited to %d characters", maxIdempotencyKeyLength), http.StatusBadRequest)
+				return
+			}
+
+			reader := io.Reader(r.Body)
+			if maxBodyBytes > 0 {
+				reader = io.LimitReader(r.Body, maxBodyBytes+1)
+			}
+			body, err := io.ReadAll(reader)
+			if err != nil {
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			if maxBodyBytes > 0 && int64(len(body)) > maxBodyBytes {
+				r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+				next.ServeHTTP(w, r)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			principal, _ := auth.PrincipalFromContext(r.Context())
+			key := mux.Vars(r)["stream_id"] + "\x00" + principal.ID + "\x00" + header
+			hash := sha256.New()
+			fmt.Fprintf(hash, "%s\n%s\n", r.Method, r.URL.RequestURI())
+			hash.Write(body)
+			fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+			replay, err := cache.Begin(key, fingerprint)
+			switch {
+			case errors.Is(err, idempotency.ErrInProgress):
+				http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+				return
+			case errors.Is(err, idempotency.ErrKeyReused):
+				http.Error(w, "Unprocessable Entity: "+err.Error(), http.StatusUnprocessableEntity)
+				return
+			case replay != nil:
+				// The handler replays it once the caller's access to the stream is checked again
+				next.ServeHTTP(w, r.WithContext(idempotency.WithReplay(r.Context(), *replay)))
+				return
+			}
+
+			ctx, respond := cache.Track(r.Context(), key)
+			rw := &recordingWriter{ResponseWriter: w}
+			defer func() {
+				respond(idempotency.Response{Status: rw.status, ContentType: rw.contentType, Body: rw.body.Bytes()})
+			}()
+			next.ServeHTTP(rw, r.WithContext(ctx))
+		})
+	}
+}
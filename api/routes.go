@@ -3,6 +3,7 @@ package api
 import (
 	"blockhouse/api/handlers"
 	"blockhouse/api/middleware"
+	"blockhouse/config"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -14,18 +15,51 @@ func SetupRoutes() *mux.Router {
 
 	// Apply global middlewares
 	router.Use(
+		middleware.RequestIDMiddleware,
 		middleware.LoggingMiddleware,
+		middleware.RequestSigningMiddleware(config.GetEnvBool("REQUIRE_REQUEST_SIGNING", false)),
 		middleware.AuthMiddleware,
 		middleware.APIKeyAuthMiddleware,
 	)
 
 	// Define API routes with HTTP methods
 	apiRoutes := router.PathPrefix("/stream").Subrouter()
-	apiRoutes.HandleFunc("/start", handlers.StartStream).Methods(http.MethodPost)
-	apiRoutes.HandleFunc("/{stream_id}/send", handlers.SendData).Methods(http.MethodPost)
+	apiRoutes.HandleFunc("", handlers.ListStreams).Methods(http.MethodGet)
+	apiRoutes.HandleFunc("/start", handlers.StartStream).Methods(http.MethodPost).Name(middleware.RouteStartStream)
+	apiRoutes.HandleFunc("/{stream_id}", handlers.GetStream).Methods(http.MethodGet)
+	apiRoutes.HandleFunc("/{stream_id}", handlers.DeleteStream).Methods(http.MethodDelete)
+	apiRoutes.HandleFunc("/{stream_id}/restore", handlers.RestoreStream).Methods(http.MethodPost)
+	apiRoutes.HandleFunc("/{stream_id}/schema", handlers.GetSchema).Methods(http.MethodGet)
+	apiRoutes.HandleFunc("/{stream_id}/schema", handlers.PutSchema).Methods(http.MethodPut)
+	apiRoutes.HandleFunc("/{stream_id}/tokens", handlers.IssueStreamTokens).Methods(http.MethodPost)
+	apiRoutes.HandleFunc("/{stream_id}/tokens", handlers.RevokeStreamTokens).Methods(http.MethodDelete)
+	apiRoutes.HandleFunc("/{stream_id}/acl", handlers.GetACL).Methods(http.MethodGet)
+	apiRoutes.HandleFunc("/{stream_id}/acl", handlers.GrantAccess).Methods(http.MethodPost)
+	apiRoutes.HandleFunc("/{stream_id}/acl", handlers.RevokeAccess).Methods(http.MethodDelete)
+	apiRoutes.HandleFunc("/{stream_id}/send", handlers.SendData).Methods(http.MethodPost).Name(middleware.RouteSendData)
+	apiRoutes.HandleFunc("/{stream_id}/send/batch", handlers.SendBatch).Methods(http.MethodPost).Name(middleware.RouteSendBatch)
 	apiRoutes.HandleFunc("/{stream_id}/results", handlers.GetResults).Methods(http.MethodGet)
 
-	// Define WebSocket route
+	// Define admin routes for managing API keys, tenants and rate limits and reading the audit log
+	// and quota usage
+	adminRoutes := router.PathPrefix("/admin").Subrouter()
+	adminRoutes.HandleFunc("/audit", handlers.GetAuditLog).Methods(http.MethodGet)
+	adminRoutes.HandleFunc("/keys", handlers.ListKeys).Methods(http.MethodGet)
+	adminRoutes.HandleFunc("/keys", handlers.CreateKey).Methods(http.MethodPost)
+	adminRoutes.HandleFunc("/keys/{key_id}", handlers.RevokeKey).Methods(http.MethodDelete)
+	adminRoutes.HandleFunc("/quotas", handlers.ListQuotas).Methods(http.MethodGet)
+	adminRoutes.HandleFunc("/quotas/{principal_id}", handlers.GetPrincipalQuota).Methods(http.MethodGet)
+	adminRoutes.HandleFunc("/ratelimits", handlers.GetRateLimits).Methods(http.MethodGet)
+	adminRoutes.HandleFunc("/ratelimits", handlers.SetRateLimits).Methods(http.MethodPut)
+	adminRoutes.HandleFunc("/ratelimits", handlers.ResetRateLimits).Methods(http.MethodDelete)
+	adminRoutes.HandleFunc("/tenants", handlers.ListTenants).Methods(http.MethodGet)
+	adminRoutes.HandleFunc("/tenants", handlers.CreateTenant).Methods(http.MethodPost)
+	adminRoutes.HandleFunc("/tenants/{tenant_id}", handlers.GetTenant).Methods(http.MethodGet)
+	adminRoutes.HandleFunc("/tenants/{tenant_id}/limits", handlers.SetTenantLimits).Methods(http.MethodPut)
+	adminRoutes.HandleFunc("/tenants/{tenant_id}/tier", handlers.SetTenantTier).Methods(http.MethodPut)
+
+	// Define WebSocket routes
+	router.HandleFunc("/ws/ticket", handlers.IssueTicket).Methods(http.MethodPost)
 	router.HandleFunc("/ws/{stream_id}", handlers.StreamResults).Methods(http.MethodGet)
 
 	// Root route for API description
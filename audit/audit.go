@@ -0,0 +1,196 @@
+package audit
+
+import (
+	"blockhouse/clientip"
+	"blockhouse/config"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Types of audited events
+const (
+	EventAuthSuccess   = "auth.success"       // A request authenticated
+	EventAuthFailure   = "auth.failure"       // A request presented missing or invalid credentials
+	EventAccessDenied  = "access.denied"      // An authenticated caller lacked a scope or stream role
+	EventKeyCreate     = "key.create"         // An API key was issued
+	EventKeyRevoke     = "key.revoke"         // An API key was revoked
+	EventStreamCreate  = "stream.create"      // A stream was started
+	EventStreamDelete  = "stream.delete"      // A stream was deleted, soft-deleted or purged
+	EventStreamRestore = "stream.restore"     // A soft-deleted stream was restored
+	EventTokensRevoke  = "tokens.revoke"      // A stream's tokens were revoked
+	EventACLGrant      = "acl.grant"          // A role was granted on a stream
+	EventACLRevoke     = "acl.revoke"         // Roles were removed from a stream
+	EventTenantCreate  = "tenant.create"      // A tenant was registered
+	EventTenantLimits  = "tenant.limits"      // A tenant's limits or tier were changed
+	EventRateLimits    = "ratelimit.policies" // The rate limit policies were replaced or reset
+)
+
+// Outcomes of an audited event
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// ErrQueryUnavailable is returned when querying without a file to read events back from.
+var ErrQueryUnavailable = errors.New("audit log file is not configured")
+
+// Event is a single audit record.
+type Event struct {
+	Time      time.Time `json:"time"`                 // When the event happened
+	Type      string    `json:"type"`                 // One of the Event* constants
+	Outcome   string    `json:"outcome"`              // OutcomeSuccess, OutcomeFailure or OutcomeDenied
+	Principal string    `json:"principal,omitempty"`  // Principal ID of the caller, if known
+	Method    string    `json:"method,omitempty"`     // How the caller authenticated
+	SourceIP  string    `json:"source_ip,omitempty"`  // Address the request came from
+	RequestID string    `json:"request_id,omitempty"` // X-Request-ID of the request
+	Request   string    `json:"request,omitempty"`    // HTTP method and path
+	Resource  string    `json:"resource,omitempty"`   // Stream or key the event concerns
+	Reason    string    `json:"reason,omitempty"`     // Why the request failed or was denied, or other detail
+}
+
+// Filter selects events when querying the audit log. Zero fields match everything.
+type Filter struct {
+	From      time.Time
+	To        time.Time
+	Principal string
+	Type      string
+	Limit     int // Return at most this many of the most recent matching events
+}
+
+// Matches reports whether an event passes the filter, ignoring Limit.
+func (f Filter) Matches(e Event) bool {
+	if !f.From.IsZero() && e.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && e.Time.After(f.To) {
+		return false
+	}
+	if f.Principal != "" && e.Principal != f.Principal {
+		return false
+	}
+	if f.Type != "" && e.Type != f.Type {
+		return false
+	}
+	return true
+}
+
+// Sink receives audit events.
+type Sink interface {
+	Write(event Event) error
+}
+
+// Querier is a sink that can read events back.
+type Querier interface {
+	Query(filter Filter) ([]Event, error)
+}
+
+// Counts audit events by type and outcome, and events a sink failed to write
+var (
+	auditEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "audit_events_total",
+			Help: "Total number of audit events recorded",
+		},
+		[]string{"type", "outcome"},
+	)
+	auditWriteErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "audit_write_errors_total",
+			Help: "Total number of audit events a sink failed to write",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(auditEvents, auditWriteErrors)
+}
+
+// Logger fans audit events out to its sinks.
+type Logger struct {
+	sinks []Sink
+}
+
+// New creates a logger writing to the given sinks.
+func New(sinks ...Sink) *Logger {
+	return &Logger{sinks: sinks}
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultLogger *Logger
+)
+
+// Default returns the process-wide audit logger, writing to AUDIT_LOG_FILE (audit.jsonl by
+// default) and, when AUDIT_KAFKA_TOPIC is set, to that Kafka topic.
+func Default() *Logger {
+	defaultOnce.Do(func() {
+		var sinks []Sink
+		if path := config.GetEnvDefault("AUDIT_LOG_FILE", "audit.jsonl"); path != "off" {
+			file, err := NewFileSink(path)
+			if err != nil {
+				log.Printf("Error opening audit log %s, audit events will only be logged: %v", path, err)
+			} else {
+				sinks = append(sinks, file)
+			}
+		}
+		if topic := config.GetEnvDefault("AUDIT_KAFKA_TOPIC", ""); topic != "" {
+			sinks = append(sinks, NewKafkaSink(topic, 1024))
+		}
+		defaultLogger = New(sinks...)
+	})
+	return defaultLogger
+}
+
+// Log writes an event to every sink, stamping its time if unset. Sink failures are logged
+// and counted; with no sinks the event is written to the process log instead.
+func (l *Logger) Log(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	auditEvents.WithLabelValues(event.Type, event.Outcome).Inc()
+
+	if len(l.sinks) == 0 {
+		line, _ := json.Marshal(event)
+		log.Printf("AUDIT %s", line)
+		return
+	}
+	for _, sink := range l.sinks {
+		if err := sink.Write(event); err != nil {
+			auditWriteErrors.Inc()
+			log.Printf("Error writing %s audit event: %v", event.Type, err)
+		}
+	}
+}
+
+// Query returns matching events from the first sink that can be queried.
+func (l *Logger) Query(filter Filter) ([]Event, error) {
+	for _, sink := range l.sinks {
+		if querier, ok := sink.(Querier); ok {
+			return querier.Query(filter)
+		}
+	}
+	return nil, ErrQueryUnavailable
+}
+
+// Record fills in the request ID, source address and request line from r, when it is not
+// nil, and writes the event to the default logger.
+func Record(r *http.Request, event Event) {
+	if r != nil {
+		event.RequestID = RequestID(r)
+		event.SourceIP = SourceIP(r)
+		event.Request = r.Method + " " + r.URL.Path
+	}
+	Default().Log(event)
+}
+
+// SourceIP returns the address a request came from, looking through trusted proxies.
+func SourceIP(r *http.Request) string {
+	return clientip.ClientIP(r)
+}
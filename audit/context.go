@@ -0,0 +1,26 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+)
+
+// HeaderRequestID carries the request ID to and from clients.
+const HeaderRequestID = "X-Request-ID"
+
+// requestIDKey is the context key under which the request ID is stored
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID assigned to a request by the request ID middleware, falling back
+// to the X-Request-ID header the client sent.
+func RequestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return r.Header.Get(HeaderRequestID)
+}
@@ -0,0 +1,49 @@
+package audit
+
+import (
+	"blockhouse/kafka"
+	"encoding/json"
+	"errors"
+	"log"
+)
+
+// ErrQueueFull is returned when the Kafka sink cannot keep up and drops an event.
+var ErrQueueFull = errors.New("audit Kafka queue is full")
+
+// KafkaSink publishes events to a Kafka topic from a background goroutine, so a slow or
+// unavailable broker never blocks the request being audited.
+type KafkaSink struct {
+	topic string
+	queue chan []byte
+}
+
+// NewKafkaSink starts a sink publishing to topic with room for queueSize pending events.
+func NewKafkaSink(topic string, queueSize int) *KafkaSink {
+	sink := &KafkaSink{topic: topic, queue: make(chan []byte, queueSize)}
+	go sink.run()
+	return sink
+}
+
+// Write queues an event for publishing.
+func (k *KafkaSink) Write(event Event) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	select {
+	case k.queue <- value:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// run publishes queued events until the process exits
+func (k *KafkaSink) run() {
+	for value := range k.queue {
+		if err := kafka.SendValueToKafka(k.topic, value); err != nil {
+			auditWriteErrors.Inc()
+			log.Printf("Error publishing audit event to %s: %v", k.topic, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"blockhouse/config"
+	"blockhouse/models"
+	"net/http"
+	"sync"
+)
+
+var (
+	certScopesOnce sync.Once
+	certScopes     []string
+	certTenant     string
+)
+
+// ClientCertIdentity returns the identity of the request's verified client certificate: its
+// first URI, DNS or email SAN, in that order, falling back to the subject common name.
+func ClientCertIdentity(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String(), true
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0], true
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0], true
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName, true
+	}
+	return "", false
+}
+
+// certPrincipal returns the principal for a client certificate identity, granted the
+// scopes configured with TLS_CLIENT_SCOPES in the TLS_CLIENT_TENANT tenant
+func certPrincipal(identity string) Principal {
+	certScopesOnce.Do(func() {
+		cfg := config.GetTLSConfig()
+		certTenant = models.TenantOf(cfg.ClientTenant)
+		for _, scope := range cfg.ClientScopes {
+			if validScopes[scope] {
+				certScopes = append(certScopes, scope)
+			}
+		}
+	})
+	return Principal{ID: "cert:" + identity, Name: identity, Method: MethodCert, Tenant: certTenant, Scopes: certScopes}
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"blockhouse/config"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// contextKey is the context key under which the authenticated principal is stored
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the authenticated principal.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored by WithPrincipal, if any.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(contextKey{}).(Principal)
+	return principal, ok
+}
+
+// RequestSecret returns the API key secret presented with a request in X-API-Key. Browsers
+// cannot set headers on WebSocket handshakes; they should use a ticket from POST /ws/ticket,
+// but with WS_QUERY_API_KEY=true upgrade requests may still pass the key as a query parameter.
+func RequestSecret(r *http.Request) string {
+	if secret := r.Header.Get("X-API-Key"); secret != "" {
+		return secret
+	}
+	if websocket.IsWebSocketUpgrade(r) && config.GetEnvBool("WS_QUERY_API_KEY", false) {
+		return r.URL.Query().Get("X-API-Key")
+	}
+	return ""
+}
+
+// Authenticate returns the principal for a request, reusing the one stored in the request
+// context by earlier middleware when present. Depending on AUTH_MODE the caller presents an
+// API key (directly or as a request signature), a bearer JWT, or either; in "both" mode a
+// bearer token takes precedence. A request with none of these but a verified client
+// certificate is identified by the certificate. A WebSocket upgrade carrying a ticket is
+// authenticated by redeeming the ticket alone.
+func Authenticate(r *http.Request) (Principal, error) {
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		return principal, nil
+	}
+	if ticket := RequestTicket(r); ticket != "" {
+		return DefaultTickets().Redeem(ticket)
+	}
+	if identity, ok := ClientCertIdentity(r); ok && !hasCredentials(r) {
+		return certPrincipal(identity), nil
+	}
+
+	mode := Mode()
+	if mode != config.AuthModeJWT && bearerToken(r) == "" && IsSigned(r) {
+		return DefaultSignatureVerifier().Verify(r)
+	}
+	if token := bearerToken(r); mode == config.AuthModeJWT || (mode == config.AuthModeBoth && token != "") {
+		if token == "" {
+			return Principal{}, ErrInvalidBearer
+		}
+		return DefaultVerifier().Verify(r.Context(), token)
+	}
+
+	return DefaultAPIKeys().Authenticate(RequestSecret(r))
+}
+
+// hasCredentials reports whether a request presents an API key, bearer token or signature
+func hasCredentials(r *http.Request) bool {
+	return RequestSecret(r) != "" || bearerToken(r) != "" || IsSigned(r)
+}
+
+// IsUnauthenticated reports whether err means the caller presented no valid credential,
+// as opposed to a failure looking the credential up.
+func IsUnauthenticated(err error) bool {
+	return errors.Is(err, ErrInvalidKey) || errors.Is(err, ErrInvalidBearer) || errors.Is(err, ErrInvalidTicket) ||
+		errors.Is(err, ErrInvalidSignature) || errors.Is(err, ErrStaleRequest) || errors.Is(err, ErrReplayedRequest)
+}
+
+// RequestStreamToken returns the stream token presented with a request in the X-Stream-Token
+// header, or for WebSocket upgrades in the "token" query parameter.
+func RequestStreamToken(r *http.Request) string {
+	if token := r.Header.Get("X-Stream-Token"); token != "" {
+		return token
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		return r.URL.Query().Get("token")
+	}
+	return ""
+}
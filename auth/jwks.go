@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// jwksMinRefetch limits how often an unknown key ID can trigger a JWKS reload
+const jwksMinRefetch = 30 * time.Second
+
+// ErrUnknownKey is returned when no JWKS key matches a token's key ID and algorithm.
+var ErrUnknownKey = errors.New("no matching JWKS key")
+
+// jwk is a single JSON Web Key as it appears in a JWKS document
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`   // RSA modulus
+	E   string `json:"e"`   // RSA exponent
+	Crv string `json:"crv"` // EC curve
+	X   string `json:"x"`   // EC point
+	Y   string `json:"y"`
+	K   string `json:"k"` // Symmetric key
+}
+
+// verificationKey is a parsed JWKS key with the signing algorithm it may be used for
+type verificationKey struct {
+	kid string
+	alg string
+	key interface{} // *rsa.PublicKey, *ecdsa.PublicKey or []byte
+}
+
+// JWKS holds the keys used to verify JWTs. Keys are loaded from a URL or local file and
+// reloaded once the refresh interval has passed, or sooner when a token names an unknown key.
+type JWKS struct {
+	mu         sync.Mutex
+	source     string
+	fetch      func(ctx context.Context) ([]byte, error)
+	refresh    time.Duration
+	keys       []verificationKey
+	loadedAt   time.Time
+	tried      time.Time
+	refreshing chan struct{} // Closed when the reload in progress ends; nil while none is
+}
+
+// NewJWKS creates a key set loaded from url or, when url is empty, from file. A failed
+// initial load is logged and retried when a key is next needed.
+func NewJWKS(url, file string, refresh time.Duration) (*JWKS, error) {
+	j := &JWKS{refresh: refresh}
+	switch {
+	case url != "":
+		j.source = url
+		j.fetch = func(ctx context.Context) ([]byte, error) { return fetchJWKS(ctx, url) }
+	case file != "":
+		j.source = file
+		j.fetch = func(context.Context) ([]byte, error) { return os.ReadFile(file) }
+	default:
+		return nil, errors.New("JWT authentication requires JWT_JWKS_URL or JWT_JWKS_FILE")
+	}
+	if err := j.reload(context.Background()); err != nil {
+		log.Printf("Error loading JWKS from %s: %v", j.source, err)
+	}
+	return j, nil
+}
+
+// fetchJWKS downloads a JWKS document
+func fetchJWKS(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// reload fetches and parses the key set, keeping the previous keys if either step fails. The
+// fetch runs without holding j.mu; a caller arriving while another reload is in progress waits
+// for it instead of fetching again.
+func (j *JWKS) reload(ctx context.Context) error {
+	j.mu.Lock()
+	if done := j.refreshing; done != nil {
+		j.mu.Unlock()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	done := make(chan struct{})
+	j.refreshing = done
+	tried := time.Now()
+	j.tried = tried
+	j.mu.Unlock()
+
+	data, err := j.fetch(ctx)
+	var keys []verificationKey
+	if err == nil {
+		keys, err = parseJWKS(data)
+	}
+
+	j.mu.Lock()
+	if err == nil {
+		j.keys = keys
+		j.loadedAt = tried
+	}
+	j.refreshing = nil
+	j.mu.Unlock()
+	close(done)
+	return err
+}
+
+// Key returns the verification key for a token's key ID and algorithm. Tokens without a
+// key ID match any single key usable with the algorithm.
+func (j *JWKS) Key(ctx context.Context, kid, alg string) (interface{}, error) {
+	if j.mayRefetch(true) {
+		if err := j.reload(ctx); err != nil {
+			log.Printf("Error refreshing JWKS from %s: %v", j.source, err)
+		}
+	}
+	key, err := j.match(kid, alg)
+	if errors.Is(err, ErrUnknownKey) && kid != "" && j.mayRefetch(false) {
+		// The issuer may have rotated in a new key since the last load
+		if err := j.reload(ctx); err != nil {
+			log.Printf("Error refreshing JWKS from %s: %v", j.source, err)
+		}
+		key, err = j.match(kid, alg)
+	}
+	return key, err
+}
+
+// mayRefetch reports whether the last load attempt is long enough ago to try again, and when
+// onlyIfStale is set, whether the loaded keys are also due for a refresh
+func (j *JWKS) mayRefetch(onlyIfStale bool) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if onlyIfStale && time.Since(j.loadedAt) < j.refresh {
+		return false
+	}
+	return time.Since(j.tried) >= jwksMinRefetch
+}
+
+// match finds the loaded key for kid and alg
+func (j *JWKS) match(kid, alg string) (interface{}, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var found []verificationKey
+	for _, key := range j.keys {
+		if key.alg == alg && (kid == "" || key.kid == kid) {
+			found = append(found, key)
+		}
+	}
+	if len(found) != 1 {
+		return nil, fmt.Errorf("%w for kid %q and alg %s", ErrUnknownKey, kid, alg)
+	}
+	return found[0].key, nil
+}
+
+// parseJWKS parses the RS256, ES256 and HS256 signing keys in a JWKS document. Keys of other
+// types, or meant for encryption, are skipped.
+func parseJWKS(data []byte) ([]verificationKey, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JWKS document: %w", err)
+	}
+
+	var keys []verificationKey
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, alg, err := k.parse()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %w", k.Kid, err)
+		}
+		if key == nil || (k.Alg != "" && k.Alg != alg) {
+			continue
+		}
+		keys = append(keys, verificationKey{kid: k.Kid, alg: alg, key: key})
+	}
+	return keys, nil
+}
+
+// parse decodes the key material and returns the algorithm it is used with, or a nil key
+// for unsupported key types
+func (k jwk) parse() (interface{}, string, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, "", err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, "", err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, "RS256", nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, "", nil
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, "", err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, "", err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, "ES256", nil
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil || len(secret) == 0 {
+			return nil, "", errors.New("invalid symmetric key")
+		}
+		return secret, "HS256", nil
+	}
+	return nil, "", nil
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
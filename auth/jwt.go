@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"blockhouse/config"
+	"blockhouse/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidBearer is returned when a bearer token is missing, malformed, badly signed,
+// expired, or issued by or for someone else.
+var ErrInvalidBearer = errors.New("invalid bearer token")
+
+// JWTVerifier validates bearer JWTs against a JWKS and maps their claims to a principal.
+type JWTVerifier struct {
+	keys         *JWKS
+	parser       *jwt.Parser
+	scopeClaim   string
+	subjectClaim string
+	tenantClaim  string
+}
+
+// NewJWTVerifier creates a verifier from the JWT settings in cfg.
+func NewJWTVerifier(cfg config.AuthConfig) (*JWTVerifier, error) {
+	keys, err := NewJWKS(cfg.JWKSURL, cfg.JWKSFile, cfg.JWKSRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "ES256", "HS256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+	return &JWTVerifier{
+		keys:         keys,
+		parser:       jwt.NewParser(options...),
+		scopeClaim:   cfg.ScopeClaim,
+		subjectClaim: cfg.SubjectClaim,
+		tenantClaim:  cfg.TenantClaim,
+	}, nil
+}
+
+// Verify checks a bearer token and returns the principal it identifies. Scopes come from the
+// configured scope claim, either a space-separated string or an array; values that are not
+// known scopes are ignored.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.Key(ctx, kid, t.Method.Alg())
+	})
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrInvalidBearer, err)
+	}
+
+	subject, _ := claims[v.subjectClaim].(string)
+	if subject == "" {
+		return Principal{}, fmt.Errorf("%w: missing %s claim", ErrInvalidBearer, v.subjectClaim)
+	}
+
+	var scopes []string
+	switch value := claims[v.scopeClaim].(type) {
+	case string:
+		scopes = strings.Fields(value)
+	case []interface{}:
+		for _, item := range value {
+			if scope, ok := item.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	granted := []string{}
+	for _, scope := range scopes {
+		if validScopes[scope] {
+			granted = append(granted, scope)
+		}
+	}
+
+	tenant, _ := claims[v.tenantClaim].(string)
+	return Principal{ID: "jwt:" + subject, Name: subject, Method: MethodJWT, Tenant: models.TenantOf(tenant), Scopes: granted}, nil
+}
+
+var (
+	authConfigOnce sync.Once
+	authConfig     config.AuthConfig
+	verifierOnce   sync.Once
+	verifier       *JWTVerifier
+)
+
+// Mode returns the authentication mode configured with AUTH_MODE.
+func Mode() string {
+	authConfigOnce.Do(func() {
+		authConfig = config.GetAuthConfig()
+		switch authConfig.Mode {
+		case config.AuthModeAPIKey, config.AuthModeJWT, config.AuthModeBoth:
+		default:
+			log.Fatalf("Unsupported AUTH_MODE %q: expected %s, %s or %s",
+				authConfig.Mode, config.AuthModeAPIKey, config.AuthModeJWT, config.AuthModeBoth)
+		}
+	})
+	return authConfig.Mode
+}
+
+// DefaultVerifier returns the process-wide JWT verifier built from the JWT_* settings.
+func DefaultVerifier() *JWTVerifier {
+	verifierOnce.Do(func() {
+		Mode()
+		v, err := NewJWTVerifier(authConfig)
+		if err != nil {
+			log.Fatalf("Failed to set up JWT authentication: %v", err)
+		}
+		verifier = v
+	})
+	return verifier
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// SetChallenge adds a WWW-Authenticate header inviting a bearer token when JWTs are accepted.
+func SetChallenge(w http.ResponseWriter) {
+	if Mode() != config.AuthModeAPIKey {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="blockhouse"`)
+	}
+}
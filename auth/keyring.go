@@ -0,0 +1,342 @@
+package auth
+
+import (
+	"blockhouse/config"
+	"blockhouse/models"
+	"blockhouse/signing"
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SourceKey is an operator-managed API key loaded from a credential source.
+type SourceKey struct {
+	Name      string     `json:"name"`                 // Label shared by every version of the key
+	Version   string     `json:"version,omitempty"`    // Identifies this version during a rotation; defaults to the key ID
+	Secret    string     `json:"secret,omitempty"`     // Plaintext secret; either this or SHA256 is required
+	SHA256    string     `json:"sha256,omitempty"`     // Hex SHA-256 of the secret; recognises X-API-Key without holding plaintext, but cannot sign requests
+	Tenant    string     `json:"tenant,omitempty"`     // Tenant the key acts for; defaults to the default tenant
+	Scopes    []string   `json:"scopes"`               // Scopes granted to the key
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Time after which the version is rejected
+}
+
+// hash returns the hex SHA-256 of the key's secret
+func (k SourceKey) hash() string {
+	if k.Secret != "" {
+		return hashSecret(k.Secret)
+	}
+	return strings.ToLower(k.SHA256)
+}
+
+// CredentialSource supplies operator-managed API keys. Sources are read again whenever the
+// key ring reloads.
+type CredentialSource interface {
+	// Describe names the source in logs.
+	Describe() string
+	// Load returns the keys the source currently holds.
+	Load() ([]SourceKey, error)
+}
+
+// EnvSource reads API_KEY, and API_KEY_PREVIOUS while a rotation is in progress, as admin
+// keys named "default". API_KEY_VERSION and API_KEY_PREVIOUS_VERSION optionally label them.
+type EnvSource struct{}
+
+// Describe names the source in logs.
+func (EnvSource) Describe() string { return "environment" }
+
+// Load returns the keys set in the environment.
+func (EnvSource) Load() ([]SourceKey, error) {
+	var keys []SourceKey
+	if secret := os.Getenv("API_KEY"); secret != "" {
+		keys = append(keys, SourceKey{Name: "default", Version: os.Getenv("API_KEY_VERSION"), Secret: secret, Scopes: []string{"admin"}})
+	}
+	if secret := os.Getenv("API_KEY_PREVIOUS"); secret != "" {
+		keys = append(keys, SourceKey{Name: "default", Version: os.Getenv("API_KEY_PREVIOUS_VERSION"), Secret: secret, Scopes: []string{"admin"}})
+	}
+	return keys, nil
+}
+
+// FileSource reads keys from a JSON file of the form {"keys": [SourceKey, ...]}.
+type FileSource struct {
+	Path string
+}
+
+// Describe names the source in logs.
+func (f FileSource) Describe() string { return f.Path }
+
+// Load parses the key file.
+func (f FileSource) Load() ([]SourceKey, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Keys []SourceKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", f.Path, err)
+	}
+	return doc.Keys, nil
+}
+
+// ringKey is a loaded source key indexed by fingerprint
+type ringKey struct {
+	SourceKey
+	id         string
+	hash       string
+	signingKey []byte // Derived from a plaintext secret; keys given by hash cannot sign requests
+	source     int    // Index of the credential source the key was loaded from
+}
+
+// KeyRing holds the operator-managed API keys from its credential sources in memory. Reloading
+// swaps in the new set atomically; a key that disappears stays valid for the rotation window
+// so clients can move to its replacement without downtime.
+type KeyRing struct {
+	sources []CredentialSource
+	window  time.Duration
+
+	mu       sync.RWMutex
+	keys     map[string]ringKey
+	modTimes map[string]time.Time
+}
+
+// NewKeyRing creates a key ring over the given sources and loads them.
+func NewKeyRing(window time.Duration, sources ...CredentialSource) (*KeyRing, error) {
+	k := &KeyRing{sources: sources, window: window, keys: make(map[string]ringKey)}
+	return k, k.Reload()
+}
+
+var (
+	ringOnce    sync.Once
+	defaultRing *KeyRing
+)
+
+// DefaultKeyRing returns the process-wide key ring over the environment and, when set, the
+// API_KEYS_FILE. Load errors are logged; the ring then holds whatever loaded successfully.
+func DefaultKeyRing() *KeyRing {
+	ringOnce.Do(func() {
+		sources := []CredentialSource{EnvSource{}}
+		if path := os.Getenv("API_KEYS_FILE"); path != "" {
+			sources = append(sources, FileSource{Path: path})
+		}
+		ring, err := NewKeyRing(config.GetEnvDuration("API_KEY_ROTATION_WINDOW", time.Hour), sources...)
+		if err != nil {
+			log.Printf("Error loading API keys: %v", err)
+		}
+		defaultRing = ring
+	})
+	return defaultRing
+}
+
+// Reload reads every source again and replaces the loaded keys. If a source fails, the keys it
+// loaded previously are kept and the error is returned once the rest are applied.
+func (k *KeyRing) Reload() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	loaded := make(map[string]ringKey)
+	modTimes := make(map[string]time.Time)
+	var errs []error
+	for i, source := range k.sources {
+		if file, ok := source.(FileSource); ok {
+			if info, err := os.Stat(file.Path); err == nil {
+				modTimes[file.Path] = info.ModTime()
+			}
+		}
+		keys, err := source.Load()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source.Describe(), err))
+			for id, key := range k.keys {
+				if key.source == i {
+					loaded[id] = key
+				}
+			}
+			continue
+		}
+		for _, key := range keys {
+			entry, err := newRingKey(key)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", source.Describe(), err))
+				continue
+			}
+			entry.source = i
+			loaded[entry.id] = entry
+		}
+	}
+
+	// Keys that were removed keep working until the rotation window closes
+	for id, old := range k.keys {
+		if _, ok := loaded[id]; ok {
+			continue
+		}
+		until := now.Add(k.window)
+		if old.ExpiresAt != nil && old.ExpiresAt.Before(until) {
+			until = *old.ExpiresAt
+		}
+		if until.After(now) {
+			old.ExpiresAt = &until
+			loaded[id] = old
+			if k.window > 0 {
+				log.Printf("API key %s version %s was removed and stays valid until %s", old.Name, old.Version, until.Format(time.RFC3339))
+			}
+		}
+	}
+
+	k.keys = loaded
+	k.modTimes = modTimes
+	return errors.Join(errs...)
+}
+
+// newRingKey validates a source key and indexes it by fingerprint
+func newRingKey(key SourceKey) (ringKey, error) {
+	hash := key.hash()
+	raw, err := hex.DecodeString(hash)
+	if err != nil || len(raw) != 32 {
+		return ringKey{}, fmt.Errorf("key %s version %s needs a secret or a hex sha256", key.Name, key.Version)
+	}
+	if err := checkScopes(key.Scopes); err != nil {
+		return ringKey{}, fmt.Errorf("key %s version %s: %w", key.Name, key.Version, err)
+	}
+	id := signing.KeyIDFromHash(raw)
+	if key.Version == "" {
+		key.Version = id
+	}
+	var signingKey []byte
+	if key.Secret != "" {
+		signingKey = signing.SigningKey(key.Secret)
+	}
+	key.Secret = ""
+	return ringKey{SourceKey: key, id: id, hash: hash, signingKey: signingKey}, nil
+}
+
+// lookup returns the usable key with the given fingerprint
+func (k *KeyRing) lookup(id string) (ringKey, error) {
+	k.mu.RLock()
+	key, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok {
+		return ringKey{}, ErrInvalidKey
+	}
+	if key.ExpiresAt != nil && !time.Now().Before(*key.ExpiresAt) {
+		return ringKey{}, fmt.Errorf("%w: key %s version %s expired at %s", ErrInvalidKey, key.Name, key.Version, key.ExpiresAt.Format(time.RFC3339))
+	}
+	return key, nil
+}
+
+// principal returns the principal for a ring key
+func (key ringKey) principal() Principal {
+	return Principal{ID: key.id, Name: key.Name, Method: MethodAPIKey, Tenant: models.TenantOf(key.Tenant), Scopes: key.Scopes, KeyVersion: key.Version}
+}
+
+// Authenticate returns the principal for a secret held by the ring, or ErrInvalidKey.
+func (k *KeyRing) Authenticate(secret string) (Principal, error) {
+	if secret == "" {
+		return Principal{}, ErrInvalidKey
+	}
+	key, err := k.lookup(Fingerprint(secret))
+	if err != nil {
+		return Principal{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.hash), []byte(hashSecret(secret))) != 1 {
+		return Principal{}, ErrInvalidKey
+	}
+	return key.principal(), nil
+}
+
+// SigningKey returns the request signing key for a ring key loaded with its plaintext secret.
+func (k *KeyRing) SigningKey(id string) (Principal, []byte, error) {
+	key, err := k.lookup(id)
+	if err != nil {
+		return Principal{}, nil, err
+	}
+	if len(key.signingKey) == 0 {
+		return Principal{}, nil, fmt.Errorf("%w: key %s version %s is configured by sha256 and cannot sign requests", ErrInvalidKey, key.Name, key.Version)
+	}
+	return key.principal(), key.signingKey, nil
+}
+
+// changed reports whether a key file has been modified since it was loaded
+func (k *KeyRing) changed() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, source := range k.sources {
+		file, ok := source.(FileSource)
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			continue
+		}
+		if loaded, ok := k.modTimes[file.Path]; !ok || !info.ModTime().Equal(loaded) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads the ring whenever a key file changes, checking every interval until ctx is cancelled.
+func (k *KeyRing) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !k.changed() {
+				continue
+			}
+			if err := k.Reload(); err != nil {
+				log.Printf("Error reloading API keys: %v", err)
+				continue
+			}
+			log.Printf("Reloaded API keys after a key file changed")
+		}
+	}
+}
+
+// APIKeys resolves API keys from the operator key ring first and then the key store.
+type APIKeys struct {
+	Ring  *KeyRing
+	Store *KeyStore
+}
+
+// DefaultAPIKeys returns the process-wide key ring and key store.
+func DefaultAPIKeys() APIKeys {
+	return APIKeys{Ring: DefaultKeyRing(), Store: Default()}
+}
+
+// Authenticate returns the principal for an API key secret. Secrets unknown to the ring are
+// looked up in the store; a ring key that has expired is reported as such.
+func (a APIKeys) Authenticate(secret string) (Principal, error) {
+	principal, err := a.Ring.Authenticate(secret)
+	if err != ErrInvalidKey {
+		return principal, err
+	}
+	key, err := a.Store.Authenticate(secret)
+	if err != nil {
+		return Principal{}, err
+	}
+	return keyPrincipal(key), nil
+}
+
+// SigningKey returns the principal and request signing key for an API key ID.
+func (a APIKeys) SigningKey(id string) (Principal, []byte, error) {
+	principal, signingKey, err := a.Ring.SigningKey(id)
+	if err != ErrInvalidKey {
+		return principal, signingKey, err
+	}
+	return a.Store.SigningKey(id)
+}
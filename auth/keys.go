@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"blockhouse/models"
+	"blockhouse/signing"
+	"blockhouse/store"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// secretPrefix marks secrets generated by the server so they are easy to recognise in configs and logs
+const secretPrefix = "bh_"
+
+// sealingSecretKey is the SecretsBucket key under which a generated sealing key is kept
+const sealingSecretKey = "api_key_sealing"
+
+var (
+	// ErrInvalidKey is returned when a secret does not match an enabled, unexpired key.
+	ErrInvalidKey = errors.New("invalid or missing API key")
+	// ErrKeyNotFound is returned when no key has the requested ID.
+	ErrKeyNotFound = errors.New("API key not found")
+	// ErrInvalidScope is returned when a key is created with an unknown scope or none at all.
+	ErrInvalidScope = errors.New("invalid scope")
+)
+
+// validScopes lists every scope a key may be granted
+var validScopes = map[string]bool{
+	models.ScopeStreamCreate: true,
+	models.ScopeStreamWrite:  true,
+	models.ScopeStreamRead:   true,
+	models.ScopeAdmin:        true,
+}
+
+// KeyStore manages API keys persisted in the metadata store.
+// Records are keyed by the fingerprint of their secret so a presented key can be looked up directly.
+// Each record carries the key's request signing key sealed with AES-GCM, so that the server can
+// verify signed requests without ever storing the signing key in the clear.
+type KeyStore struct {
+	mu         sync.Mutex
+	store      store.Store
+	sealingKey []byte
+
+	sealOnce sync.Once
+	aead     cipher.AEAD
+	sealErr  error
+}
+
+// NewKeyStore creates a key store backed by the given store. Signing keys are sealed with
+// sealingKey; when it is empty, a random one is generated and kept in the store's secrets bucket.
+func NewKeyStore(s store.Store, sealingKey []byte) *KeyStore {
+	return &KeyStore{store: s, sealingKey: sealingKey}
+}
+
+var (
+	defaultOnce sync.Once
+	defaultKeys *KeyStore
+)
+
+// Default returns the process-wide key store, sealing signing keys with API_KEY_SEALING_KEY.
+// Operator-supplied keys such as API_KEY are not stored here but held by the DefaultKeyRing so
+// they can be rotated without a restart.
+func Default() *KeyStore {
+	defaultOnce.Do(func() {
+		defaultKeys = NewKeyStore(store.Default(), []byte(os.Getenv("API_KEY_SEALING_KEY")))
+	})
+	return defaultKeys
+}
+
+// Fingerprint returns a short, non-reversible identifier for a key secret. It is used as
+// the key ID and recorded as the owner of streams the key creates.
+func Fingerprint(secret string) string {
+	return signing.KeyID(secret)
+}
+
+// legacyFingerprint returns the ID keys were stored under before IDs were derived with their own
+// label, so that keys created then keep authenticating
+func legacyFingerprint(secret string) string {
+	return hex.EncodeToString(signing.HashSecret(secret)[:8])
+}
+
+// hashSecret returns the hex SHA-256 of a key secret
+func hashSecret(secret string) string {
+	return hex.EncodeToString(signing.HashSecret(secret))
+}
+
+// sealer returns the AEAD sealing signing keys, loading or generating the sealing key on first use
+func (k *KeyStore) sealer() (cipher.AEAD, error) {
+	k.sealOnce.Do(func() {
+		key := k.sealingKey
+		if len(key) == 0 {
+			if key, k.sealErr = storedSecret(k.store, sealingSecretKey); k.sealErr != nil {
+				return
+			}
+		}
+		sum := sha256.Sum256(key)
+		block, err := aes.NewCipher(sum[:])
+		if err != nil {
+			k.sealErr = err
+			return
+		}
+		k.aead, k.sealErr = cipher.NewGCM(block)
+	})
+	return k.aead, k.sealErr
+}
+
+// seal encrypts the signing key of the key with the given ID, binding it to that ID
+func (k *KeyStore) seal(id string, signingKey []byte) (string, error) {
+	aead, err := k.sealer()
+	if err != nil {
+		return "", fmt.Errorf("failed to load API key sealing key: %w", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to seal signing key: %w", err)
+	}
+	return base64.RawStdEncoding.EncodeToString(aead.Seal(nonce, nonce, signingKey, []byte(id))), nil
+}
+
+// unseal decrypts the signing key sealed in a key record
+func (k *KeyStore) unseal(key models.APIKey) ([]byte, error) {
+	if key.Sealed == "" {
+		return nil, fmt.Errorf("%w: key %s predates request signing with derived keys; create a new key to sign requests", ErrInvalidKey, key.ID)
+	}
+	aead, err := k.sealer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API key sealing key: %w", err)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(key.Sealed)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid sealed signing key for key %s", key.ID)
+	}
+	signingKey, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(key.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unseal signing key for key %s: %w", key.ID, err)
+	}
+	return signingKey, nil
+}
+
+// checkScopes rejects empty or unknown scope lists
+func checkScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	for _, scope := range scopes {
+		if !validScopes[scope] {
+			return fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+	}
+	return nil
+}
+
+// Create generates a new key acting for tenant and returns its record along with the secret.
+// The secret is not stored and cannot be recovered later; only its hash and sealed signing key are.
+func (k *KeyStore) Create(name, tenant string, scopes []string, expiresAt *time.Time) (models.APIKey, string, error) {
+	if err := checkScopes(scopes); err != nil {
+		return models.APIKey{}, "", err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return models.APIKey{}, "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	secret := secretPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	id := Fingerprint(secret)
+	sealed, err := k.seal(id, signing.SigningKey(secret))
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+	key := models.APIKey{
+		ID:        id,
+		Name:      name,
+		Tenant:    models.TenantOf(tenant),
+		Hash:      hashSecret(secret),
+		Sealed:    sealed,
+		Scopes:    scopes,
+		Enabled:   true,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := store.PutJSON(k.store, store.APIKeysBucket, key.ID, key); err != nil {
+		return models.APIKey{}, "", err
+	}
+	return key, secret, nil
+}
+
+// Authenticate returns the key matching secret, or ErrInvalidKey if the secret is unknown,
+// revoked or expired.
+func (k *KeyStore) Authenticate(secret string) (models.APIKey, error) {
+	if secret == "" {
+		return models.APIKey{}, ErrInvalidKey
+	}
+
+	var key models.APIKey
+	err := store.GetJSON(k.store, store.APIKeysBucket, Fingerprint(secret), &key)
+	if errors.Is(err, store.ErrNotFound) {
+		err = store.GetJSON(k.store, store.APIKeysBucket, legacyFingerprint(secret), &key)
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return models.APIKey{}, ErrInvalidKey
+		}
+		return models.APIKey{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashSecret(secret))) != 1 {
+		return models.APIKey{}, ErrInvalidKey
+	}
+	if err := checkUsable(key); err != nil {
+		return models.APIKey{}, err
+	}
+	return key, nil
+}
+
+// SigningKey returns the principal and request signing key for an enabled, unexpired key,
+// unsealing the signing key kept with its record.
+func (k *KeyStore) SigningKey(id string) (Principal, []byte, error) {
+	key, err := k.Get(id)
+	if errors.Is(err, ErrKeyNotFound) {
+		return Principal{}, nil, ErrInvalidKey
+	}
+	if err != nil {
+		return Principal{}, nil, err
+	}
+	if err := checkUsable(key); err != nil {
+		return Principal{}, nil, err
+	}
+	signingKey, err := k.unseal(key)
+	if err != nil {
+		return Principal{}, nil, err
+	}
+	return keyPrincipal(key), signingKey, nil
+}
+
+// checkUsable rejects revoked and expired keys
+func checkUsable(key models.APIKey) error {
+	if !key.Enabled {
+		return fmt.Errorf("%w: key %s has been revoked", ErrInvalidKey, key.ID)
+	}
+	if key.ExpiresAt != nil && !time.Now().Before(*key.ExpiresAt) {
+		return fmt.Errorf("%w: key %s expired at %s", ErrInvalidKey, key.ID, key.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Get returns the key with the given ID.
+func (k *KeyStore) Get(id string) (models.APIKey, error) {
+	var key models.APIKey
+	if err := store.GetJSON(k.store, store.APIKeysBucket, id, &key); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return models.APIKey{}, ErrKeyNotFound
+		}
+		return models.APIKey{}, err
+	}
+	return key, nil
+}
+
+// List returns every key, including revoked and expired ones, oldest first.
+func (k *KeyStore) List() ([]models.APIKey, error) {
+	keys := []models.APIKey{}
+	err := k.store.ForEach(store.APIKeysBucket, func(id string, value []byte) error {
+		var key models.APIKey
+		if err := json.Unmarshal(value, &key); err != nil {
+			return fmt.Errorf("failed to unmarshal API key record %s: %w", id, err)
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// Revoke disables a key so it is no longer accepted. The record is kept so streams
+// it created can still be attributed to it.
+func (k *KeyStore) Revoke(id string) (models.APIKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, err := k.Get(id)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if !key.Enabled {
+		return key, nil
+	}
+	now := time.Now().UTC()
+	key.Enabled = false
+	key.RevokedAt = &now
+	if err := store.PutJSON(k.store, store.APIKeysBucket, id, key); err != nil {
+		return models.APIKey{}, err
+	}
+	return key, nil
+}
@@ -0,0 +1,34 @@
+package auth
+
+import "blockhouse/models"
+
+// Methods a principal can authenticate with
+const (
+	MethodAPIKey = "apikey"
+	MethodJWT    = "jwt"
+	MethodCert   = "cert"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	ID     string   // Key fingerprint for API keys, "jwt:<subject>" or "cert:<identity>" otherwise
+	Name   string   // Key name, token subject or certificate identity
+	Method string   // MethodAPIKey, MethodJWT, MethodCert or MethodTicket
+	Tenant string   // Tenant the caller acts for; it can only reach that tenant's streams
+	Scopes []string // Scopes granted to the caller
+	// KeyVersion identifies which version of an API key authenticated the request: the
+	// configured version for operator keys, or the key ID for keys created through the API
+	KeyVersion string
+	// Stream, when set, is the only stream the principal may access, as for WebSocket tickets
+	Stream string
+}
+
+// HasScope reports whether the principal was granted scope. The admin scope grants every scope.
+func (p Principal) HasScope(scope string) bool {
+	return models.GrantsScope(p.Scopes, scope)
+}
+
+// keyPrincipal returns the principal for an authenticated API key
+func keyPrincipal(key models.APIKey) Principal {
+	return Principal{ID: key.ID, Name: key.Name, Method: MethodAPIKey, Tenant: models.TenantOf(key.Tenant), Scopes: key.Scopes, KeyVersion: key.ID}
+}
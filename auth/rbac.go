@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"blockhouse/models"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Actions on a stream that are checked against the caller's roles
+const (
+	ActionView    = "view"    // Read the stream record, schema and ACL
+	ActionProduce = "produce" // Send data
+	ActionConsume = "consume" // Read results and subscribe over WebSocket
+	ActionManage  = "manage"  // Delete, restore, change schemas, tokens and the ACL
+)
+
+var (
+	// ErrAccessDenied is returned when none of a principal's roles on a stream allows an action.
+	ErrAccessDenied = errors.New("access denied")
+	// ErrOtherTenant is returned, wrapping ErrAccessDenied, when a stream belongs to a tenant
+	// other than the principal's. Callers should treat the stream as if it did not exist.
+	ErrOtherTenant = fmt.Errorf("%w: stream belongs to another tenant", ErrAccessDenied)
+)
+
+// roleOrder lists the roles from least to most privileged, for stable error messages
+var roleOrder = []string{models.RoleProducer, models.RoleConsumer, models.RoleOwner, models.RoleAdmin}
+
+// roleActions lists the actions each role allows
+var roleActions = map[string][]string{
+	models.RoleProducer: {ActionView, ActionProduce},
+	models.RoleConsumer: {ActionView, ActionConsume},
+	models.RoleOwner:    {ActionView, ActionProduce, ActionConsume, ActionManage},
+	models.RoleAdmin:    {ActionView, ActionProduce, ActionConsume, ActionManage},
+}
+
+// GrantableRole reports whether role can be granted on a stream through its ACL.
+func GrantableRole(role string) bool {
+	return role == models.RoleProducer || role == models.RoleConsumer || role == models.RoleOwner
+}
+
+// StreamRoles returns the roles a principal holds on a stream: admin through the admin
+// scope, owner for the stream's creator, and any roles granted in the stream's ACL. A
+// principal holds no roles on another tenant's streams, whatever its scopes.
+func StreamRoles(principal Principal, stream models.Stream) []string {
+	if models.TenantOf(principal.Tenant) != models.TenantOf(stream.Tenant) {
+		return nil
+	}
+	var roles []string
+	if principal.HasScope(models.ScopeAdmin) {
+		roles = append(roles, models.RoleAdmin)
+	}
+	if stream.Owner != "" && stream.Owner == principal.ID {
+		roles = append(roles, models.RoleOwner)
+	}
+	for _, grant := range stream.ACL {
+		if grant.Principal == principal.ID {
+			roles = append(roles, grant.Role)
+		}
+	}
+	return roles
+}
+
+// roleAllows reports whether role permits action
+func roleAllows(role, action string) bool {
+	for _, allowed := range roleActions[role] {
+		if allowed == action {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckStreamAccess returns nil if one of the principal's roles on the stream allows action,
+// or an ErrAccessDenied error explaining which roles would.
+func CheckStreamAccess(principal Principal, stream models.Stream, action string) error {
+	if models.TenantOf(principal.Tenant) != models.TenantOf(stream.Tenant) {
+		return ErrOtherTenant
+	}
+	if principal.Stream != "" && principal.Stream != stream.ID {
+		return fmt.Errorf("%w: %s is restricted to stream %s", ErrAccessDenied, principal.ID, principal.Stream)
+	}
+	held := StreamRoles(principal, stream)
+	for _, role := range held {
+		if roleAllows(role, action) {
+			return nil
+		}
+	}
+
+	var needed []string
+	for _, role := range roleOrder {
+		if roleAllows(role, action) {
+			needed = append(needed, role)
+		}
+	}
+	if len(held) == 0 {
+		return fmt.Errorf("%w: %s has no role on stream %s; %s requires one of %s",
+			ErrAccessDenied, principal.ID, stream.ID, action, strings.Join(needed, ", "))
+	}
+	return fmt.Errorf("%w: %s holds %s on stream %s; %s requires one of %s",
+		ErrAccessDenied, principal.ID, strings.Join(held, ", "), stream.ID, action, strings.Join(needed, ", "))
+}
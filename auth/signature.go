@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"blockhouse/config"
+	"blockhouse/signing"
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidSignature is returned when a signed request's headers are incomplete or its
+	// signature does not match.
+	ErrInvalidSignature = errors.New("invalid request signature")
+	// ErrStaleRequest is returned when a signed request's timestamp is outside the allowed skew.
+	ErrStaleRequest = errors.New("request timestamp outside the allowed window")
+	// ErrReplayedRequest is returned when a signed request reuses a nonce.
+	ErrReplayedRequest = errors.New("request nonce has already been used")
+	// ErrBodyTooLarge is returned when a signed request's body is larger than the verifier reads.
+	ErrBodyTooLarge = errors.New("request body too large")
+)
+
+// IsSigned reports whether a request carries a request signature.
+func IsSigned(r *http.Request) bool {
+	return r.Header.Get(signing.HeaderSignature) != ""
+}
+
+// nonceCache remembers nonces until they fall outside the timestamp window, after which a
+// replay would be rejected as stale anyway
+type nonceCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	ttl       time.Duration
+	lastSweep time.Time
+}
+
+// add records a nonce, returning false if it was already seen
+func (c *nonceCache) add(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastSweep) >= c.ttl {
+		for n, expiry := range c.seen {
+			if now.After(expiry) {
+				delete(c.seen, n)
+			}
+		}
+		c.lastSweep = now
+	}
+	if expiry, ok := c.seen[nonce]; ok && !now.After(expiry) {
+		return false
+	}
+	c.seen[nonce] = now.Add(c.ttl)
+	return true
+}
+
+// KeyResolver looks up the principal and request signing key for an API key ID.
+type KeyResolver interface {
+	SigningKey(id string) (Principal, []byte, error)
+}
+
+// SignatureVerifier checks HMAC-signed requests against a set of API keys.
+type SignatureVerifier struct {
+	keys         KeyResolver
+	maxSkew      time.Duration
+	maxBodyBytes int64
+	nonces       *nonceCache
+}
+
+// NewSignatureVerifier creates a verifier accepting timestamps within maxSkew of the current time
+// and bodies of at most maxBodyBytes; zero means no limit.
+func NewSignatureVerifier(keys KeyResolver, maxSkew time.Duration, maxBodyBytes int64) *SignatureVerifier {
+	return &SignatureVerifier{
+		keys:         keys,
+		maxSkew:      maxSkew,
+		maxBodyBytes: maxBodyBytes,
+		// A nonce must be remembered for as long as its timestamp is acceptable, i.e. up to 2*maxSkew
+		nonces: &nonceCache{seen: make(map[string]time.Time), ttl: 2 * maxSkew},
+	}
+}
+
+var (
+	signatureOnce     sync.Once
+	signatureVerifier *SignatureVerifier
+)
+
+// DefaultSignatureVerifier returns the process-wide verifier using the default key ring and
+// key store, REQUEST_SIGNATURE_MAX_SKEW and MAX_BODY_BYTES.
+func DefaultSignatureVerifier() *SignatureVerifier {
+	signatureOnce.Do(func() {
+		maxSkew := config.GetEnvDuration("REQUEST_SIGNATURE_MAX_SKEW", 5*time.Minute)
+		signatureVerifier = NewSignatureVerifier(DefaultAPIKeys(), maxSkew, config.GetQuotaConfig().MaxBodyBytes)
+	})
+	return signatureVerifier
+}
+
+// Verify checks a signed request and returns the principal of the signing key. The body is
+// read to hash it and replaced so handlers can still read it; a body over the verifier's limit
+// is refused with ErrBodyTooLarge.
+func (v *SignatureVerifier) Verify(r *http.Request) (Principal, error) {
+	keyID := r.Header.Get(signing.HeaderKey)
+	nonce := r.Header.Get(signing.HeaderNonce)
+	signature := r.Header.Get(signing.HeaderSignature)
+	timestamp, err := strconv.ParseInt(r.Header.Get(signing.HeaderTimestamp), 10, 64)
+	if keyID == "" || nonce == "" || signature == "" || err != nil {
+		return Principal{}, fmt.Errorf("%w: missing or malformed signature headers", ErrInvalidSignature)
+	}
+
+	now := time.Now()
+	signedAt := time.Unix(timestamp, 0)
+	if signedAt.Before(now.Add(-v.maxSkew)) || signedAt.After(now.Add(v.maxSkew)) {
+		return Principal{}, ErrStaleRequest
+	}
+
+	principal, signingKey, err := v.keys.SigningKey(keyID)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	var body []byte
+	if r.Body != nil {
+		reader := io.Reader(r.Body)
+		if v.maxBodyBytes > 0 {
+			reader = io.LimitReader(r.Body, v.maxBodyBytes+1)
+		}
+		body, err = io.ReadAll(reader)
+		r.Body.Close()
+		if err != nil {
+			return Principal{}, fmt.Errorf("failed to read request body: %w", err)
+		}
+		if v.maxBodyBytes > 0 && int64(len(body)) > v.maxBodyBytes {
+			return Principal{}, fmt.Errorf("%w: bodies are limited to %d bytes", ErrBodyTooLarge, v.maxBodyBytes)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	canonical := signing.CanonicalRequest(r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(signing.Sign(signingKey, canonical)), []byte(signature)) {
+		return Principal{}, ErrInvalidSignature
+	}
+
+	// Only record the nonce once the signature is known to be genuine, so forged requests
+	// cannot burn nonces
+	if !v.nonces.add(keyID+":"+nonce, now) {
+		return Principal{}, ErrReplayedRequest
+	}
+	return principal, nil
+}
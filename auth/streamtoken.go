@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"blockhouse/store"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Uses a stream token can be issued for
+const (
+	TokenProduce = "produce" // Send data to the stream
+	TokenConsume = "consume" // Read results from the stream over HTTP or WebSocket
+)
+
+// streamTokenSecretKey is the SecretsBucket key under which a generated signing secret is kept
+const streamTokenSecretKey = "stream_tokens"
+
+// ErrInvalidToken is returned when a stream token is malformed, forged, expired, revoked
+// or issued for a different stream or use.
+var ErrInvalidToken = errors.New("invalid stream token")
+
+// StreamClaims are the signed contents of a stream token.
+type StreamClaims struct {
+	StreamID   string `json:"sid"`           // Stream the token grants access to
+	Use        string `json:"use"`           // TokenProduce or TokenConsume
+	Generation int    `json:"gen"`           // Stream token generation the token was issued under
+	IssuedAt   int64  `json:"iat"`           // Unix time the token was issued
+	ExpiresAt  int64  `json:"exp,omitempty"` // Unix time after which the token is rejected; zero for no expiry
+}
+
+// TokenSigner issues and verifies HMAC-SHA256 signed stream tokens.
+type TokenSigner struct {
+	secret []byte
+}
+
+// NewTokenSigner creates a signer using the given secret.
+func NewTokenSigner(secret []byte) *TokenSigner {
+	return &TokenSigner{secret: secret}
+}
+
+var (
+	signerOnce    sync.Once
+	defaultSigner *TokenSigner
+)
+
+// DefaultSigner returns the process-wide stream token signer. It signs with STREAM_TOKEN_SECRET
+// when set; otherwise a random secret is generated once and kept in the metadata store, so
+// tokens stay valid across restarts when the store is persistent.
+func DefaultSigner() *TokenSigner {
+	signerOnce.Do(func() {
+		if secret := os.Getenv("STREAM_TOKEN_SECRET"); secret != "" {
+			defaultSigner = NewTokenSigner([]byte(secret))
+			return
+		}
+		secret, err := storedSecret(store.Default(), streamTokenSecretKey)
+		if err != nil {
+			log.Fatalf("Failed to load stream token secret: %v", err)
+		}
+		defaultSigner = NewTokenSigner(secret)
+	})
+	return defaultSigner
+}
+
+// storedSecret returns the secret kept under key in the secrets bucket, generating one if needed
+func storedSecret(s store.Store, key string) ([]byte, error) {
+	secret, err := s.Get(store.SecretsBucket, key)
+	if err == nil {
+		return secret, nil
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	secret = make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+	if err := s.Put(store.SecretsBucket, key, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Issue signs a token for the given stream and use. A zero ttl issues a token that only
+// stops working when the stream's tokens are revoked.
+func (s *TokenSigner) Issue(streamID, use string, generation int, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := StreamClaims{StreamID: streamID, Use: use, Generation: generation, IssuedAt: now.Unix()}
+	if ttl > 0 {
+		claims.ExpiresAt = now.Add(ttl).Unix()
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal stream token: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.sign(encoded)), nil
+}
+
+// Verify checks a token's signature and that it grants use on streamID under the stream's
+// current token generation.
+func (s *TokenSigner) Verify(token, streamID, use string, generation int) (StreamClaims, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return StreamClaims{}, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.sign(encoded)) {
+		return StreamClaims{}, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return StreamClaims{}, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+	var claims StreamClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return StreamClaims{}, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	switch {
+	case claims.StreamID != streamID:
+		return StreamClaims{}, fmt.Errorf("%w: issued for another stream", ErrInvalidToken)
+	case claims.Use != use:
+		return StreamClaims{}, fmt.Errorf("%w: not a %s token", ErrInvalidToken, use)
+	case claims.Generation != generation:
+		return StreamClaims{}, fmt.Errorf("%w: revoked", ErrInvalidToken)
+	case claims.ExpiresAt != 0 && time.Now().Unix() >= claims.ExpiresAt:
+		return StreamClaims{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// sign returns the HMAC-SHA256 of an encoded token payload
+func (s *TokenSigner) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"blockhouse/config"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// MethodTicket marks a principal authenticated by redeeming a WebSocket ticket.
+const MethodTicket = "ticket"
+
+// TicketSubprotocolPrefix prefixes a ticket passed in Sec-WebSocket-Protocol, e.g. "ticket.<ticket>".
+const TicketSubprotocolPrefix = "ticket."
+
+// maxTicketTTL caps WS_TICKET_TTL so tickets stay short-lived
+const maxTicketTTL = time.Minute
+
+// ErrInvalidTicket is returned when a ticket is unknown, already used or expired.
+var ErrInvalidTicket = errors.New("invalid, used or expired WebSocket ticket")
+
+// ticket is an outstanding WebSocket ticket
+type ticket struct {
+	principal Principal
+	expiresAt time.Time
+}
+
+// TicketStore issues single-use WebSocket tickets bound to a stream. Tickets live in memory,
+// so a ticket must be redeemed on the instance that issued it.
+type TicketStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	tickets map[string]ticket
+}
+
+// NewTicketStore creates a ticket store whose tickets expire after ttl.
+func NewTicketStore(ttl time.Duration) *TicketStore {
+	return &TicketStore{ttl: ttl, tickets: make(map[string]ticket)}
+}
+
+var (
+	ticketOnce     sync.Once
+	defaultTickets *TicketStore
+)
+
+// DefaultTickets returns the process-wide ticket store with WS_TICKET_TTL (10s by default,
+// at most a minute).
+func DefaultTickets() *TicketStore {
+	ticketOnce.Do(func() {
+		ttl := config.GetEnvDuration("WS_TICKET_TTL", 10*time.Second)
+		if ttl <= 0 || ttl > maxTicketTTL {
+			log.Printf("WS_TICKET_TTL %s is out of range, using %s", ttl, maxTicketTTL)
+			ttl = maxTicketTTL
+		}
+		defaultTickets = NewTicketStore(ttl)
+	})
+	return defaultTickets
+}
+
+// Issue returns a new ticket letting principal open a WebSocket on streamID once, and the
+// time it expires.
+func (s *TicketStore) Issue(principal Principal, streamID string) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	value := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	expiresAt := now.Add(s.ttl)
+	principal.Stream = streamID
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for value, t := range s.tickets {
+		if !now.Before(t.expiresAt) {
+			delete(s.tickets, value)
+		}
+	}
+	s.tickets[value] = ticket{principal: principal, expiresAt: expiresAt}
+	return value, expiresAt, nil
+}
+
+// Redeem consumes a ticket and returns the principal it was issued to, restricted to the
+// ticket's stream. A ticket can only be redeemed once.
+func (s *TicketStore) Redeem(value string) (Principal, error) {
+	s.mu.Lock()
+	t, ok := s.tickets[value]
+	delete(s.tickets, value)
+	s.mu.Unlock()
+
+	if !ok || !time.Now().Before(t.expiresAt) {
+		return Principal{}, ErrInvalidTicket
+	}
+	principal := t.principal
+	principal.Method = MethodTicket
+	return principal, nil
+}
+
+// RequestTicket returns the ticket presented with a WebSocket upgrade, either as the
+// "ticket" query parameter or as a "ticket.<ticket>" entry in Sec-WebSocket-Protocol.
+func RequestTicket(r *http.Request) string {
+	if !websocket.IsWebSocketUpgrade(r) {
+		return ""
+	}
+	if value := r.URL.Query().Get("ticket"); value != "" {
+		return value
+	}
+	for _, protocol := range websocket.Subprotocols(r) {
+		if strings.HasPrefix(protocol, TicketSubprotocolPrefix) {
+			return strings.TrimPrefix(protocol, TicketSubprotocolPrefix)
+		}
+	}
+	return ""
+}
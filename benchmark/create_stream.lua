@@ -7,6 +7,9 @@ wrk.method = "POST"
 wrk.headers["Content-Type"] = "application/json"
 wrk.headers["X-API-Key"] = "your_secret_api_key_here"  -- Replace with your actual API key
 
+-- Expire benchmark streams automatically so their topics do not accumulate
+wrk.body = '{"idle_timeout": "10m"}'
+
 -- Function to format request for stream creation
 function request()
     -- Return formatted POST request with necessary headers
@@ -6,7 +6,7 @@
 wrk.method = "POST"
 wrk.headers["Content-Type"] = "application/json"
 wrk.headers["X-API-Key"] = "your_secret_api_key_here"  -- Replace with actual API key
-wrk.headers["X-Stream-ID"] = "your-test-stream-id"      -- Replace with an actual stream ID for testing
+wrk.headers["X-Stream-Token"] = "your-producer-token"  -- Replace with the producer token returned by /stream/start
 
 -- Payload and endpoint path
 local payload = '{"key": "integration-test-value"}'
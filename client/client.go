@@ -0,0 +1,122 @@
+package client
+
+import (
+	"blockhouse/signing"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignRequest adds HMAC request signature headers to req using the given API key secret.
+// The body is read to hash it and replaced so the request can still be sent.
+func SignRequest(req *http.Request, apiKey string) error {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	}
+
+	nonce, err := signing.NewNonce()
+	if err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	timestamp := time.Now().Unix()
+	canonical := signing.CanonicalRequest(req.Method, req.URL.RequestURI(), timestamp, nonce, body)
+
+	req.Header.Set(signing.HeaderKey, signing.KeyID(apiKey))
+	req.Header.Set(signing.HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(signing.HeaderNonce, nonce)
+	req.Header.Set(signing.HeaderSignature, signing.Sign(signing.SigningKey(apiKey), canonical))
+	return nil
+}
+
+// Tokens are the stream tokens returned when a stream is started
+type Tokens struct {
+	Producer string `json:"producer"`
+	Consumer string `json:"consumer"`
+}
+
+// Stream is the part of the StartStream response the client needs
+type Stream struct {
+	StreamID string `json:"stream_id"`
+	Tokens   Tokens `json:"tokens"`
+}
+
+// Client calls the streaming API, signing producer requests instead of sending the API key.
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+// New creates a client for the API at baseURL authenticating with apiKey.
+func New(baseURL, apiKey string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// StartStream creates a stream. settings is the optional StartStream body and may be nil.
+func (c *Client) StartStream(ctx context.Context, settings interface{}) (Stream, error) {
+	var stream Stream
+	err := c.post(ctx, "/stream/start", "", settings, http.StatusCreated, &stream)
+	return stream, err
+}
+
+// Send sends a JSON payload to a stream using its producer token.
+func (c *Client) Send(ctx context.Context, streamID, producerToken string, payload interface{}) error {
+	return c.post(ctx, "/stream/"+streamID+"/send", producerToken, payload, http.StatusAccepted, nil)
+}
+
+// post sends a signed JSON request and decodes the response into out when it is not nil
+func (c *Client) post(ctx context.Context, path, streamToken string, body interface{}, want int, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if streamToken != "" {
+		req.Header.Set("X-Stream-Token", streamToken)
+	}
+	if err := SignRequest(req, c.APIKey); err != nil {
+		return err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("POST %s: %s: %s", path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
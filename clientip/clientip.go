@@ -0,0 +1,129 @@
+package clientip
+
+import (
+	"blockhouse/config"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// Resolver works out which address a request came from. Forwarding headers are only believed
+// when the connection comes from a trusted proxy, since any client can send them.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver creates a resolver trusting the proxies at the given addresses or CIDR ranges.
+func NewResolver(proxies []string) (*Resolver, error) {
+	res := &Resolver{}
+	for _, proxy := range proxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: must be an address or CIDR range", proxy)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		res.trusted = append(res.trusted, prefix.Masked())
+	}
+	return res, nil
+}
+
+var (
+	defaultOnce     sync.Once
+	defaultResolver *Resolver
+)
+
+// Default returns the process-wide resolver trusting the proxies listed in TRUSTED_PROXIES.
+func Default() *Resolver {
+	defaultOnce.Do(func() {
+		res, err := NewResolver(config.GetTrustedProxies())
+		if err != nil {
+			log.Fatalf("Failed to parse TRUSTED_PROXIES: %v", err)
+		}
+		defaultResolver = res
+	})
+	return defaultResolver
+}
+
+// ClientIP returns the address a request came from according to the default resolver.
+func ClientIP(r *http.Request) string {
+	return Default().ClientIP(r)
+}
+
+// Trusted reports whether addr belongs to a trusted proxy.
+func (res *Resolver) Trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range res.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address a request came from, without its port. When the connection
+// comes from a trusted proxy, the forwarding chain is walked from the nearest hop back to the
+// first address that is not a trusted proxy.
+func (res *Resolver) ClientIP(r *http.Request) string {
+	client, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			return host
+		}
+		return r.RemoteAddr
+	}
+
+	hops := forwardedFor(r)
+	for i := len(hops) - 1; i >= 0 && res.Trusted(client); i-- {
+		addr, ok := parseAddr(hops[i])
+		if !ok {
+			// An obfuscated or malformed hop ends what can be known about the chain
+			break
+		}
+		client = addr
+	}
+	return client.String()
+}
+
+// forwardedFor returns the addresses a request was forwarded for, client first, taken from the
+// standard Forwarded header or, when it is absent, from X-Forwarded-For
+func forwardedFor(r *http.Request) []string {
+	var hops []string
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(name, "for") {
+					hop = value
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	return hops
+}
+
+// parseAddr parses an address with or without a port, such as 192.0.2.1, 192.0.2.1:4711,
+// "[2001:db8::1]:4711" or 2001:db8::1
+func parseAddr(value string) (netip.Addr, bool) {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
@@ -3,15 +3,61 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"github.com/joho/godotenv"
 )
 
+// fileEnv records the variables that were set from the .env file rather than the process
+// environment, so ReloadEnv knows which ones it may change
+var (
+	fileEnvMu sync.Mutex
+	fileEnv   = make(map[string]bool)
+)
+
 // LoadEnv loads environment variables from a .env file, logging an error if unsuccessful.
+// Variables already set in the process environment take precedence over the file.
 func LoadEnv() {
-	if err := godotenv.Load(); err != nil {
+	values, err := godotenv.Read()
+	if err != nil {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
+	applyEnvFile(values)
+}
+
+// ReloadEnv reads the .env file again so values such as API_KEY can be rotated without a
+// restart. Variables removed from the file are unset; the process environment still wins.
+func ReloadEnv() error {
+	values, err := godotenv.Read()
+	if err != nil {
+		return err
+	}
+	applyEnvFile(values)
+	return nil
+}
+
+// applyEnvFile sets the variables read from the .env file
+func applyEnvFile(values map[string]string) {
+	fileEnvMu.Lock()
+	defer fileEnvMu.Unlock()
+
+	for key := range fileEnv {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+			delete(fileEnv, key)
+		}
+	}
+	for key, value := range values {
+		if _, set := os.LookupEnv(key); set && !fileEnv[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		fileEnv[key] = true
+	}
 }
 
 // GetEnv retrieves an environment variable by key.
@@ -28,3 +74,267 @@ func GetEnv(key string) string {
 func GetAPIKey() string {
 	return GetEnv("API_KEY")
 }
+
+// GetEnvDefault retrieves an optional environment variable, returning fallback when it is unset.
+func GetEnvDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// GetEnvDuration retrieves an optional duration such as "30s" or "10m", returning fallback
+// when the variable is unset or cannot be parsed.
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Warning: Environment variable %s has invalid duration %q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+// GetEnvInterval retrieves a duration that must be positive, such as how often a background
+// task runs, returning fallback when the variable is unset, invalid, zero or negative.
+func GetEnvInterval(key string, fallback time.Duration) time.Duration {
+	d := GetEnvDuration(key, fallback)
+	if d <= 0 {
+		log.Printf("Warning: Environment variable %s must be a positive duration, got %s, using %s", key, d, fallback)
+		return fallback
+	}
+	return d
+}
+
+// GetEnvBool retrieves an optional boolean such as "true" or "0", returning fallback
+// when the variable is unset or cannot be parsed.
+func GetEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Warning: Environment variable %s has invalid boolean %q, using %t", key, value, fallback)
+		return fallback
+	}
+	return b
+}
+
+// GetEnvInt retrieves an optional integer, returning fallback when the variable is unset or
+// cannot be parsed.
+func GetEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: Environment variable %s has invalid integer %q, using %d", key, value, fallback)
+		return fallback
+	}
+	return i
+}
+
+// GetKafkaBroker returns the Kafka broker address from KAFKA_BROKER, defaulting to localhost:9092.
+func GetKafkaBroker() string {
+	return GetEnvDefault("KAFKA_BROKER", "localhost:9092")
+}
+
+// StoreConfig describes which metadata store backend to use and where it keeps its data.
+type StoreConfig struct {
+	Backend string // "memory" or "bolt"
+	Path    string // Database file used by the bolt backend
+}
+
+// GetStoreConfig reads the metadata store settings from STORE_BACKEND and STORE_PATH.
+func GetStoreConfig() StoreConfig {
+	return StoreConfig{
+		Backend: GetEnvDefault("STORE_BACKEND", "memory"),
+		Path:    GetEnvDefault("STORE_PATH", "blockhouse.db"),
+	}
+}
+
+// How rate limited clients are identified, selected with RATE_LIMIT_CLIENT_KEY
+const (
+	ClientKeyIP        = "ip"        // Client address, or certificate identity under mTLS
+	ClientKeyPrincipal = "principal" // Authenticated caller's API key or identity; address when anonymous
+)
+
+// RateLimitConfig describes where rate limit state is kept, how clients are identified and
+// which policies apply.
+type RateLimitConfig struct {
+	Backend     string        // "memory" (per process) or "redis" (shared by every instance)
+	RedisURL    string        // redis:// or rediss:// URL of the server used by the redis backend
+	FailOpen    bool          // Let requests through when the backend cannot be reached
+	PolicyFile  string        // JSON file of rate limit policies; every client gets 10 req/s with bursts of 20 when unset
+	ClientKey   string        // ClientKeyIP or ClientKeyPrincipal
+	MaxClients  int           // Buckets the memory backend keeps before evicting the least recently used
+	IdleTimeout time.Duration // How long the memory backend keeps a bucket nobody uses
+}
+
+// GetRateLimitConfig reads the rate limiter settings from the RATE_LIMIT_* variables and
+// REDIS_URL.
+func GetRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Backend:     GetEnvDefault("RATE_LIMIT_BACKEND", "memory"),
+		RedisURL:    GetEnvDefault("REDIS_URL", "redis://localhost:6379/0"),
+		FailOpen:    GetEnvBool("RATE_LIMIT_FAIL_OPEN", true),
+		PolicyFile:  os.Getenv("RATE_LIMIT_POLICY_FILE"),
+		ClientKey:   GetEnvDefault("RATE_LIMIT_CLIENT_KEY", ClientKeyIP),
+		MaxClients:  GetEnvInt("RATE_LIMIT_MAX_CLIENTS", 100000),
+		IdleTimeout: GetEnvDuration("RATE_LIMIT_IDLE_TIMEOUT", 10*time.Minute),
+	}
+}
+
+// LoadShedConfig describes the adaptive concurrency limit placed on Kafka produce requests.
+type LoadShedConfig struct {
+	Enabled        bool          // Shed writes when the limit is reached
+	TargetLatency  time.Duration // Produce latency above which the limit is lowered
+	MinConcurrency int           // Lowest the limit may fall
+	MaxConcurrency int           // Highest the limit may rise, and where it starts
+}
+
+// GetLoadShedConfig reads the load shedding settings from the LOAD_SHED_* variables.
+func GetLoadShedConfig() LoadShedConfig {
+	return LoadShedConfig{
+		Enabled:        GetEnvBool("LOAD_SHED_ENABLED", true),
+		TargetLatency:  GetEnvDuration("LOAD_SHED_TARGET_LATENCY", 2*time.Second),
+		MinConcurrency: GetEnvInt("LOAD_SHED_MIN_CONCURRENCY", 10),
+		MaxConcurrency: GetEnvInt("LOAD_SHED_MAX_CONCURRENCY", 1000),
+	}
+}
+
+// QuotaConfig describes how many payload bytes callers and streams may send. Zero means
+// unlimited.
+type QuotaConfig struct {
+	MaxBodyBytes            int64 // Largest request body accepted by the produce endpoints
+	PrincipalBytesPerSecond int64 // Sustained payload bytes per second for each caller
+	PrincipalBytesPerDay    int64 // Payload bytes each caller may send per UTC day
+	StreamBytesPerSecond    int64 // Sustained payload bytes per second for each stream
+	StreamBytesPerDay       int64 // Payload bytes each stream may receive per UTC day
+}
+
+// GetQuotaConfig reads the byte quota settings from MAX_BODY_BYTES and the QUOTA_* variables.
+func GetQuotaConfig() QuotaConfig {
+	return QuotaConfig{
+		MaxBodyBytes:            int64(GetEnvInt("MAX_BODY_BYTES", 1<<20)),
+		PrincipalBytesPerSecond: int64(GetEnvInt("QUOTA_PRINCIPAL_BYTES_PER_SECOND", 0)),
+		PrincipalBytesPerDay:    int64(GetEnvInt("QUOTA_PRINCIPAL_BYTES_PER_DAY", 0)),
+		StreamBytesPerSecond:    int64(GetEnvInt("QUOTA_STREAM_BYTES_PER_SECOND", 0)),
+		StreamBytesPerDay:       int64(GetEnvInt("QUOTA_STREAM_BYTES_PER_DAY", 0)),
+	}
+}
+
+// How a batch containing invalid records is handled, selected with BATCH_MODE or ?mode=
+const (
+	BatchModeAllOrNothing = "all_or_nothing" // Write nothing unless every record is valid
+	BatchModeBestEffort   = "best_effort"    // Write the valid records and report the others
+)
+
+// BatchConfig describes the batch ingestion endpoint.
+type BatchConfig struct {
+	MaxRecords int    // Records a single batch may hold
+	Mode       string // BatchModeAllOrNothing or BatchModeBestEffort, used when a request does not choose
+}
+
+// GetBatchConfig reads the batch ingestion settings from the BATCH_* variables.
+func GetBatchConfig() BatchConfig {
+	return BatchConfig{
+		MaxRecords: GetEnvInt("BATCH_MAX_RECORDS", 1000),
+		Mode:       GetEnvDefault("BATCH_MODE", BatchModeAllOrNothing),
+	}
+}
+
+// IdempotencyConfig describes how long produce responses are remembered for their
+// Idempotency-Key.
+type IdempotencyConfig struct {
+	Window  time.Duration // How long a key is remembered after its first request
+	MaxKeys int           // Keys remembered before the oldest are forgotten early
+}
+
+// GetIdempotencyConfig reads the idempotency key settings from the IDEMPOTENCY_* variables.
+func GetIdempotencyConfig() IdempotencyConfig {
+	return IdempotencyConfig{
+		Window:  GetEnvDuration("IDEMPOTENCY_WINDOW", 24*time.Hour),
+		MaxKeys: GetEnvInt("IDEMPOTENCY_MAX_KEYS", 100000),
+	}
+}
+
+// GetTrustedProxies reads TRUSTED_PROXIES, the addresses or CIDR ranges of the reverse proxies
+// whose X-Forwarded-For and Forwarded headers are believed.
+func GetTrustedProxies() []string {
+	return strings.FieldsFunc(os.Getenv("TRUSTED_PROXIES"), isListSeparator)
+}
+
+// Authentication modes selected with AUTH_MODE
+const (
+	AuthModeAPIKey = "apikey" // X-API-Key only
+	AuthModeJWT    = "jwt"    // Authorization: Bearer JWTs only
+	AuthModeBoth   = "both"   // Either credential is accepted
+)
+
+// AuthConfig describes how requests are authenticated.
+type AuthConfig struct {
+	Mode         string        // AuthModeAPIKey, AuthModeJWT or AuthModeBoth
+	JWKSURL      string        // URL of the JWKS used to verify JWTs
+	JWKSFile     string        // Local JWKS file, used when JWKSURL is unset
+	JWKSRefresh  time.Duration // How often the JWKS is reloaded
+	Issuer       string        // Required "iss" claim, if set
+	Audience     string        // Required "aud" claim, if set
+	ScopeClaim   string        // Claim holding the caller's scopes
+	SubjectClaim string        // Claim identifying the caller
+	TenantClaim  string        // Claim naming the caller's tenant; callers without one belong to the default tenant
+}
+
+// GetAuthConfig reads the authentication settings from AUTH_MODE and the JWT_* variables.
+func GetAuthConfig() AuthConfig {
+	return AuthConfig{
+		Mode:         GetEnvDefault("AUTH_MODE", AuthModeAPIKey),
+		JWKSURL:      os.Getenv("JWT_JWKS_URL"),
+		JWKSFile:     os.Getenv("JWT_JWKS_FILE"),
+		JWKSRefresh:  GetEnvDuration("JWT_JWKS_REFRESH", 10*time.Minute),
+		Issuer:       os.Getenv("JWT_ISSUER"),
+		Audience:     os.Getenv("JWT_AUDIENCE"),
+		ScopeClaim:   GetEnvDefault("JWT_SCOPE_CLAIM", "scope"),
+		SubjectClaim: GetEnvDefault("JWT_SUBJECT_CLAIM", "sub"),
+		TenantClaim:  GetEnvDefault("JWT_TENANT_CLAIM", "tenant"),
+	}
+}
+
+// TLSConfig describes how the server terminates TLS and verifies client certificates.
+type TLSConfig struct {
+	CertFile       string        // PEM server certificate chain; TLS is disabled when empty
+	KeyFile        string        // PEM private key for CertFile
+	ClientCAFile   string        // PEM CA bundle used to verify client certificates
+	ClientAuth     string        // "none", "optional" or "require"
+	ClientScopes   []string      // Scopes granted to callers identified by a client certificate
+	ClientTenant   string        // Tenant of callers identified by a client certificate
+	ReloadInterval time.Duration // How often the certificate files are checked for changes
+}
+
+// Enabled reports whether a server certificate has been configured.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// GetTLSConfig reads the TLS settings from the TLS_* variables.
+func GetTLSConfig() TLSConfig {
+	return TLSConfig{
+		CertFile:       os.Getenv("TLS_CERT_FILE"),
+		KeyFile:        os.Getenv("TLS_KEY_FILE"),
+		ClientCAFile:   os.Getenv("TLS_CLIENT_CA_FILE"),
+		ClientAuth:     GetEnvDefault("TLS_CLIENT_AUTH", "none"),
+		ClientScopes:   strings.FieldsFunc(GetEnvDefault("TLS_CLIENT_SCOPES", "stream:create,stream:write,stream:read"), isListSeparator),
+		ClientTenant:   GetEnvDefault("TLS_CLIENT_TENANT", "default"),
+		ReloadInterval: GetEnvInterval("TLS_RELOAD_INTERVAL", 10*time.Second),
+	}
+}
+
+// isListSeparator splits list settings on commas and whitespace
+func isListSeparator(r rune) bool {
+	return r == ',' || unicode.IsSpace(r)
+}
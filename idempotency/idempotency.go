@@ -0,0 +1,261 @@
+package idempotency
+
+import (
+	"blockhouse/config"
+	"container/list"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// ErrInProgress is returned when a request with the same key has not completed yet.
+	ErrInProgress = errors.New("a request with this idempotency key is still in progress")
+	// ErrKeyReused is returned when a key is sent again with a different request.
+	ErrKeyReused = errors.New("idempotency key was already used for a different request")
+)
+
+var (
+	// Counts requests that reused a remembered idempotency key, by what was done with them
+	dedupHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "idempotency_dedup_hits_total",
+			Help: "Total number of requests whose idempotency key had already been seen",
+		},
+		[]string{"result"},
+	)
+
+	// Tracks how many idempotency keys are remembered
+	trackedKeys = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "idempotency_keys",
+			Help: "Number of idempotency keys currently remembered",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(dedupHits, trackedKeys)
+}
+
+// Response is a response remembered for an idempotency key.
+type Response struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// entry tracks one key from its first request until it expires
+type entry struct {
+	key         string
+	fingerprint string
+	created     time.Time
+	done        bool
+	response    Response
+	element     *list.Element
+}
+
+// Cache remembers the responses to requests carrying an idempotency key for a window, so that a
+// retried request gets the original response instead of being carried out twice. Keys are
+// kept in memory, so each instance only deduplicates the requests it served.
+type Cache struct {
+	window  time.Duration
+	maxKeys int
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	order   *list.List // Entries, oldest first
+}
+
+// New creates a cache remembering keys for window, and at most maxKeys of them; zero means no
+// limit.
+func New(window time.Duration, maxKeys int) *Cache {
+	return &Cache{window: window, maxKeys: maxKeys, entries: make(map[string]*entry), order: list.New()}
+}
+
+var (
+	defaultOnce  sync.Once
+	defaultCache *Cache
+)
+
+// Default returns the process-wide cache configured through the IDEMPOTENCY_* variables.
+func Default() *Cache {
+	defaultOnce.Do(func() {
+		cfg := config.GetIdempotencyConfig()
+		defaultCache = New(cfg.Window, cfg.MaxKeys)
+	})
+	return defaultCache
+}
+
+// Begin claims key for a request identified by fingerprint. When the key has already completed
+// with the same fingerprint, the remembered response is returned; otherwise the caller owns the
+// key and must end with Complete or Release. It returns ErrInProgress while the first request
+// with the key is running and ErrKeyReused when the fingerprints differ.
+func (c *Cache) Begin(key, fingerprint string) (*Response, error) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(now)
+
+	if e, ok := c.entries[key]; ok {
+		switch {
+		case e.fingerprint != fingerprint:
+			dedupHits.WithLabelValues("mismatch").Inc()
+			return nil, ErrKeyReused
+		case !e.done:
+			dedupHits.WithLabelValues("in_progress").Inc()
+			return nil, ErrInProgress
+		}
+		dedupHits.WithLabelValues("replayed").Inc()
+		response := e.response
+		return &response, nil
+	}
+
+	if c.maxKeys > 0 && len(c.entries) >= c.maxKeys {
+		// Make room by forgetting the oldest key early
+		c.remove(c.order.Front().Value.(*entry))
+	}
+	e := &entry{key: key, fingerprint: fingerprint, created: now}
+	e.element = c.order.PushBack(e)
+	c.entries[key] = e
+	trackedKeys.Set(float64(len(c.entries)))
+	return nil, nil
+}
+
+// Complete remembers the response to the request that claimed key, for Begin to replay.
+func (c *Cache) Complete(key string, response Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.done, e.response = true, response
+	}
+}
+
+// Release forgets a key whose request was not carried out, so that a retry runs again.
+func (c *Cache) Release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok && !e.done {
+		c.remove(e)
+	}
+}
+
+// Len returns how many keys are remembered.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(time.Now())
+	return len(c.entries)
+}
+
+// expire drops the keys older than the window; c.mu must be held
+func (c *Cache) expire(now time.Time) {
+	for front := c.order.Front(); front != nil; front = c.order.Front() {
+		e := front.Value.(*entry)
+		if now.Sub(e.created) < c.window {
+			return
+		}
+		c.remove(e)
+	}
+}
+
+// remove forgets an entry; c.mu must be held
+func (c *Cache) remove(e *entry) {
+	c.order.Remove(e.element)
+	delete(c.entries, e.key)
+	trackedKeys.Set(float64(len(c.entries)))
+}
+
+// contextKey is the context key under which a request's idempotency state reaches its handler
+type contextKey struct{}
+
+// claim follows a request that owns a key from the middleware into its handler, and settles
+// the key once both its response and any work the handler deferred are done
+type claim struct {
+	cache *Cache
+	key   string
+
+	mu       sync.Mutex
+	deferred bool      // The handler responds before its work is done
+	finished bool      // Deferred work has ended
+	err      error     // How deferred work ended
+	response *Response // Response sent to the client, once known
+	settled  bool
+}
+
+// settle completes or releases the key once everything it depends on is known; cl.mu must be held
+func (cl *claim) settle() {
+	if cl.settled || cl.response == nil {
+		return
+	}
+	success := cl.response.Status >= 200 && cl.response.Status < 300
+	if success && cl.deferred && !cl.finished {
+		return
+	}
+	cl.settled = true
+	if success && cl.err == nil {
+		cl.cache.Complete(cl.key, *cl.response)
+	} else {
+		cl.cache.Release(cl.key)
+	}
+}
+
+// Track attaches a key claimed with Begin to ctx and returns the function to call with the
+// response once the handler has returned. A successful response completes the key, unless the
+// handler called Defer, in which case the key completes only once the deferred work succeeds.
+func (c *Cache) Track(ctx context.Context, key string) (context.Context, func(Response)) {
+	cl := &claim{cache: c, key: key}
+	respond := func(response Response) {
+		cl.mu.Lock()
+		defer cl.mu.Unlock()
+		cl.response = &response
+		cl.settle()
+	}
+	return context.WithValue(ctx, contextKey{}, cl), respond
+}
+
+// Defer tells the middleware that the handler finishes its work after responding, for example
+// with an asynchronous write, and returns the function to call with the outcome of that work.
+// The key stays in progress until then and is released if the work fails, so that a retry
+// carries the request out again. Without a tracked key it returns a no-op.
+func Defer(ctx context.Context) func(error) {
+	cl, ok := ctx.Value(contextKey{}).(*claim)
+	if !ok {
+		return func(error) {}
+	}
+	cl.mu.Lock()
+	cl.deferred = true
+	cl.mu.Unlock()
+	return func(err error) {
+		cl.mu.Lock()
+		defer cl.mu.Unlock()
+		cl.finished, cl.err = true, err
+		cl.settle()
+	}
+}
+
+// WithReplay attaches a remembered response to ctx for the handler to replay with Replay.
+func WithReplay(ctx context.Context, response Response) context.Context {
+	return context.WithValue(ctx, contextKey{}, &response)
+}
+
+// Replay writes the remembered response attached to the request, marked with
+// Idempotent-Replayed: true, and reports whether there was one. Handlers call it once they have
+// checked the caller may still make the request.
+func Replay(w http.ResponseWriter, r *http.Request) bool {
+	response, ok := r.Context().Value(contextKey{}).(*Response)
+	if !ok {
+		return false
+	}
+	log.Printf("Replaying response to idempotency key %q for %s", r.Header.Get("Idempotency-Key"), r.URL.Path)
+	w.Header().Set("Content-Type", response.ContentType)
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(response.Status)
+	w.Write(response.Body)
+	return true
+}
@@ -1,9 +1,15 @@
 package kafka
 
 import (
+	"blockhouse/config"
+	"blockhouse/models"
+	"blockhouse/schemaregistry"
+	"blockhouse/store"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
@@ -13,7 +19,7 @@ import (
 // and logs each message received.
 func ConsumeMessages(topic string) {
 	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: []string{"localhost:9092"},
+		Brokers: []string{config.GetKafkaBroker()},
 		Topic:   topic,
 		GroupID: "my-group",
 	})
@@ -37,10 +43,23 @@ func ConsumeMessages(topic string) {
 // ProcessMessages consumes messages from a Kafka topic, processes each message,
 // and sends the transformed data through a result channel.
 func ProcessMessages(streamID string, resultChan chan<- string) {
+	ProcessMessagesContext(context.Background(), models.Stream{ID: streamID}, resultChan)
+}
+
+// ProcessMessagesContext behaves like ProcessMessages for a registered stream, reading its
+// topic in its tenant's consumer group, but stops when ctx is cancelled or when StopReaders
+// is called for the stream.
+func ProcessMessagesContext(ctx context.Context, stream models.Stream, resultChan chan<- string) {
+	streamID := stream.ID
+	ctx, cancel := context.WithCancel(ctx)
+	handle := trackReader(streamID, cancel)
+	defer untrackReader(streamID, handle)
+
+	groupID := consumerGroupID(stream)
 	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: []string{"localhost:9092"},
-		Topic:   streamID,
-		GroupID: "consumer-group-" + streamID,
+		Brokers: []string{config.GetKafkaBroker()},
+		Topic:   stream.Topic(),
+		GroupID: groupID,
 	})
 	defer func() {
 		if err := reader.Close(); err != nil {
@@ -48,23 +67,144 @@ func ProcessMessages(streamID string, resultChan chan<- string) {
 		}
 	}()
 
-	messageCounter := 0
-	log.Printf("Started message processing for stream %s", streamID)
+	// Resume message numbering from the persisted cursor, if any
+	cursor := loadCursor(streamID, groupID)
+	messageCounter := cursor.Count
+	log.Printf("Started message processing for stream %s at message #%d", streamID, messageCounter)
+
+	// Every cursor write is a store transaction, so the cursor is saved every few messages or
+	// seconds rather than per message, and once more when the reader stops
+	flushMessages := config.GetEnvInt("CURSOR_FLUSH_MESSAGES", 100)
+	flushInterval := config.GetEnvDuration("CURSOR_FLUSH_INTERVAL", 5*time.Second)
+	unsaved, lastSaved := 0, time.Now()
+	saveCursor := func() {
+		if unsaved == 0 {
+			return
+		}
+		if err := store.PutJSON(store.Default(), store.CursorsBucket, groupID, cursor); err != nil {
+			log.Printf("Error saving cursor for stream %s: %v", streamID, err)
+			return
+		}
+		unsaved, lastSaved = 0, time.Now()
+	}
+	defer saveCursor()
 
 	for {
 		// Read a message from the Kafka topic for the given stream
-		msg, err := reader.ReadMessage(context.Background())
+		msg, err := reader.ReadMessage(ctx)
 		if err != nil {
-			log.Printf("Error reading message from Kafka for stream %s: %v", streamID, err)
+			if ctx.Err() != nil {
+				log.Printf("Stopped message processing for stream %s", streamID)
+			} else {
+				log.Printf("Error reading message from Kafka for stream %s: %v", streamID, err)
+			}
 			break
 		}
 
 		messageCounter++
-		transformedMessage := formatMessage(messageCounter, msg.Value)
+		transformedMessage := formatMessage(messageCounter, decodeValue(ctx, streamID, msg.Value))
+
+		cursor.Partition = msg.Partition
+		cursor.Offset = msg.Offset
+		cursor.Count = messageCounter
+		cursor.UpdatedAt = time.Now().UTC()
+		if unsaved++; unsaved >= flushMessages || time.Since(lastSaved) >= flushInterval {
+			saveCursor()
+		}
 
 		log.Printf("Processed message from stream %s: %s", streamID, transformedMessage)
-		resultChan <- transformedMessage
+		select {
+		case resultChan <- transformedMessage:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+var (
+	readersMu sync.Mutex
+	readers   = make(map[string]map[int]context.CancelFunc) // Running readers by stream ID
+	readerSeq int
+)
+
+// trackReader registers a running reader's cancel function and returns its handle.
+func trackReader(streamID string, cancel context.CancelFunc) int {
+	readersMu.Lock()
+	defer readersMu.Unlock()
+
+	readerSeq++
+	if readers[streamID] == nil {
+		readers[streamID] = make(map[int]context.CancelFunc)
+	}
+	readers[streamID][readerSeq] = cancel
+	return readerSeq
+}
+
+// untrackReader removes a reader registered with trackReader.
+func untrackReader(streamID string, handle int) {
+	readersMu.Lock()
+	defer readersMu.Unlock()
+
+	if cancel, ok := readers[streamID][handle]; ok {
+		cancel()
+		delete(readers[streamID], handle)
+	}
+	if len(readers[streamID]) == 0 {
+		delete(readers, streamID)
+	}
+}
+
+// StopReaders cancels every running reader for a stream and returns how many were stopped.
+func StopReaders(streamID string) int {
+	readersMu.Lock()
+	defer readersMu.Unlock()
+
+	for _, cancel := range readers[streamID] {
+		cancel()
+	}
+	return len(readers[streamID])
+}
+
+// consumerGroupID returns the consumer group used by ProcessMessages for a stream. Groups are
+// scoped by tenant; streams created before tenants keep their original group and offsets.
+func consumerGroupID(stream models.Stream) string {
+	if stream.Tenant == "" {
+		return "consumer-group-" + stream.ID
+	}
+	return "consumer-group-" + stream.Tenant + "." + stream.ID
+}
+
+// DeleteCursor removes the persisted cursor for a stream's consumer group.
+func DeleteCursor(stream models.Stream) error {
+	return store.Default().Delete(store.CursorsBucket, consumerGroupID(stream))
+}
+
+// loadCursor returns the persisted cursor for a consumer group, or a fresh one if none exists.
+func loadCursor(streamID, groupID string) models.Cursor {
+	cursor := models.Cursor{StreamID: streamID, GroupID: groupID}
+	if err := store.GetJSON(store.Default(), store.CursorsBucket, groupID, &cursor); err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("Error loading cursor for stream %s: %v", streamID, err)
+	}
+	return cursor
+}
+
+// decodeValue converts schema registry encoded values (Avro or Protobuf) back to JSON.
+// Plain JSON values, and values that cannot be decoded, are returned unchanged.
+func decodeValue(ctx context.Context, streamID string, value []byte) []byte {
+	if !schemaregistry.IsWireFormat(value) {
+		return value
+	}
+	client := schemaregistry.Default()
+	if client == nil {
+		log.Printf("Received encoded message on stream %s but no schema registry is configured", streamID)
+		return value
+	}
+	decoded, err := client.Deserialize(ctx, value)
+	if err != nil {
+		log.Printf("Error decoding message on stream %s: %v", streamID, err)
+		return value
 	}
+	return decoded
 }
 
 // formatMessage applies consistent formatting to a Kafka message for logging and channel transmission.
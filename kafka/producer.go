@@ -1,10 +1,15 @@
 package kafka
 
 import (
+	"blockhouse/config"
+	"blockhouse/models"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"strconv"
 	"sync"
 	"time"
 
@@ -36,75 +41,160 @@ func init() {
 }
 
 var (
-	topicConnOnce sync.Once
-	topicConn     *kafka.Conn
+	// ErrBrokerUnavailable is returned when the Kafka broker or controller cannot be reached.
+	ErrBrokerUnavailable = errors.New("kafka broker unavailable")
+	// ErrInvalidTopicConfig is returned when the broker rejects the requested topic settings.
+	ErrInvalidTopicConfig = errors.New("invalid topic configuration")
 )
 
-// getKafkaConnection creates or retrieves a Kafka connection for topic operations.
+var (
+	topicConnMu sync.Mutex
+	topicConn   *kafka.Conn
+)
+
+// getKafkaConnection creates or retrieves a connection to the cluster controller for topic operations.
+// A failed dial is not cached, so the next call retries.
 func getKafkaConnection(broker string) (*kafka.Conn, error) {
-	var err error
-	topicConnOnce.Do(func() {
-		topicConn, err = kafka.Dial("tcp", broker)
-	})
+	topicConnMu.Lock()
+	defer topicConnMu.Unlock()
+
+	if topicConn != nil {
+		return topicConn, nil
+	}
+
+	conn, err := kafka.Dial("tcp", broker)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to Kafka broker: %w", err)
 	}
+	defer conn.Close()
+
+	// Topic administration must go through the controller broker
+	controller, err := conn.Controller()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate Kafka controller: %w", err)
+	}
+	controllerConn, err := kafka.Dial("tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kafka controller: %w", err)
+	}
+	topicConn = controllerConn
 	return topicConn, nil
 }
 
-// CreateTopic ensures a Kafka topic exists or creates it if missing.
-func CreateTopic(broker, topic string, numPartitions int) error {
-	conn, err := getKafkaConnection(broker)
-	if err != nil {
-		return fmt.Errorf("failed to obtain Kafka connection: %w", err)
+// resetKafkaConnection drops the cached controller connection so the next operation redials.
+func resetKafkaConnection() {
+	topicConnMu.Lock()
+	defer topicConnMu.Unlock()
+
+	if topicConn != nil {
+		topicConn.Close()
+		topicConn = nil
 	}
+}
 
-	// Check for topic existence to avoid redundant creation
-	partitions, err := conn.ReadPartitions(topic)
-	if err == nil && len(partitions) > 0 {
-		return nil
+// CreateTopic creates a Kafka topic with the partition, replication and config settings from cfg.
+// Errors wrap ErrBrokerUnavailable or ErrInvalidTopicConfig where the cause is known.
+func CreateTopic(broker, topic string, cfg models.StreamConfig) error {
+	conn, err := getKafkaConnection(broker)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBrokerUnavailable, err)
 	}
 
-	// Create the topic if it doesn't already exist
+	// Existence is not checked up front: a metadata lookup can auto-create the
+	// topic with broker defaults, which would silently discard cfg.
 	err = conn.CreateTopics(kafka.TopicConfig{
 		Topic:             topic,
-		NumPartitions:     numPartitions,
-		ReplicationFactor: 1,
+		NumPartitions:     cfg.Partitions,
+		ReplicationFactor: cfg.ReplicationFactor,
+		ConfigEntries:     topicConfigEntries(cfg),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to create Kafka topic: %w", err)
+		return classifyTopicError(topic, err)
 	}
-	log.Printf("Topic %s created with %d partitions", topic, numPartitions)
+	log.Printf("Topic %s created with %d partitions and replication factor %d", topic, cfg.Partitions, cfg.ReplicationFactor)
 	return nil
 }
 
+// DeleteTopic deletes a Kafka topic. Deleting a topic that no longer exists is not an error.
+// Errors wrap ErrBrokerUnavailable where the broker could not be reached.
+func DeleteTopic(broker, topic string) error {
+	conn, err := getKafkaConnection(broker)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBrokerUnavailable, err)
+	}
+
+	if err := conn.DeleteTopics(topic); err != nil {
+		if errors.Is(err, kafka.UnknownTopicOrPartition) {
+			return nil
+		}
+		return classifyTopicError(topic, err)
+	}
+	log.Printf("Topic %s deleted", topic)
+	return nil
+}
+
+// topicConfigEntries converts the optional topic settings in cfg to Kafka config entries.
+func topicConfigEntries(cfg models.StreamConfig) []kafka.ConfigEntry {
+	var entries []kafka.ConfigEntry
+	if cfg.RetentionMs != 0 {
+		entries = append(entries, kafka.ConfigEntry{ConfigName: "retention.ms", ConfigValue: strconv.FormatInt(cfg.RetentionMs, 10)})
+	}
+	if cfg.CleanupPolicy != "" {
+		entries = append(entries, kafka.ConfigEntry{ConfigName: "cleanup.policy", ConfigValue: cfg.CleanupPolicy})
+	}
+	if cfg.MaxMessageBytes != 0 {
+		entries = append(entries, kafka.ConfigEntry{ConfigName: "max.message.bytes", ConfigValue: strconv.Itoa(cfg.MaxMessageBytes)})
+	}
+	return entries
+}
+
+// classifyTopicError wraps a topic administration error with a sentinel describing its cause.
+func classifyTopicError(topic string, err error) error {
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) {
+		switch kafkaErr {
+		case kafka.InvalidTopic, kafka.InvalidPartitionNumber, kafka.InvalidReplicationFactor,
+			kafka.InvalidReplicaAssignment, kafka.InvalidConfiguration, kafka.PolicyViolation, kafka.InvalidRequest:
+			return fmt.Errorf("%w: topic %s: %v", ErrInvalidTopicConfig, topic, err)
+		case kafka.NotController, kafka.BrokerNotAvailable, kafka.RequestTimedOut:
+			resetKafkaConnection()
+			return fmt.Errorf("%w: topic %s: %v", ErrBrokerUnavailable, topic, err)
+		}
+		return fmt.Errorf("topic operation failed for %s: %w", topic, err)
+	}
+
+	// Anything else is a transport failure; drop the connection so it is re-established
+	resetKafkaConnection()
+	return fmt.Errorf("%w: topic %s: %v", ErrBrokerUnavailable, topic, err)
+}
+
 var (
-	writerOnce    sync.Once
-	kafkaWriter   *kafka.Writer
-	brokerAddress = "localhost:9092" // Default Kafka broker address
+	writerOnce  sync.Once
+	kafkaWriter *kafka.Writer
 )
 
 // getKafkaWriter initializes or reuses a Kafka writer for message production.
-func getKafkaWriter(topic string) *kafka.Writer {
+// The writer is not bound to a topic; each message carries its own.
+func getKafkaWriter() *kafka.Writer {
 	writerOnce.Do(func() {
-		kafkaWriter = kafka.NewWriter(kafka.WriterConfig{
-			Brokers:  []string{brokerAddress},
-			Topic:    topic,
+		kafkaWriter = &kafka.Writer{
+			Addr:     kafka.TCP(config.GetKafkaBroker()),
 			Balancer: &kafka.CRC32Balancer{},
-		})
+		}
 	})
 	return kafkaWriter
 }
 
 // ProduceMessage sends a text message to a specific Kafka topic.
 func ProduceMessage(topic, message string) {
-	writer := getKafkaWriter(topic)
+	writer := getKafkaWriter()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	startTime := time.Now()
 
 	if err := writer.WriteMessages(ctx, kafka.Message{
+		Topic: topic,
 		Key:   []byte("key"),
 		Value: []byte(message),
 	}); err != nil {
@@ -119,15 +209,18 @@ func ProduceMessage(topic, message string) {
 
 // SendToKafka marshals and sends structured data to the specified Kafka topic.
 func SendToKafka(streamID string, data map[string]interface{}) error {
-	topic := streamID
-	writer := getKafkaWriter(topic)
-
 	// Marshal data to JSON
 	message, err := json.Marshal(data)
 	if err != nil {
-		return fmt.Errorf("failed to marshal data for topic %s: %w", topic, err)
+		return fmt.Errorf("failed to marshal data for topic %s: %w", streamID, err)
 	}
-	log.Printf("Preparing message for topic %s: %s", topic, message)
+	log.Printf("Preparing message for topic %s: %s", streamID, message)
+	return SendValueToKafka(streamID, message)
+}
+
+// SendValueToKafka sends an already-encoded message value to the specified Kafka topic.
+func SendValueToKafka(topic string, value []byte) error {
+	writer := getKafkaWriter()
 
 	// Set timeout and start timer for metrics
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -136,8 +229,9 @@ func SendToKafka(streamID string, data map[string]interface{}) error {
 
 	// Send message to Kafka
 	if err := writer.WriteMessages(ctx, kafka.Message{
-		Key:   []byte(streamID),
-		Value: message,
+		Topic: topic,
+		Key:   []byte(topic),
+		Value: value,
 	}); err != nil {
 		return fmt.Errorf("failed to write message to Kafka for topic %s: %w", topic, err)
 	}
@@ -148,6 +242,112 @@ func SendToKafka(streamID string, data map[string]interface{}) error {
 	return nil
 }
 
+// SendValuesToKafka sends already-encoded message values to the specified Kafka topic in a
+// single write. When only some of them fail, the error is a kafka.WriteErrors holding the error
+// of each message in order.
+func SendValuesToKafka(topic string, values [][]byte) error {
+	writer := getKafkaWriter()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	startTime := time.Now()
+
+	messages := make([]kafka.Message, len(values))
+	for i, value := range values {
+		messages[i] = kafka.Message{Topic: topic, Key: []byte(topic), Value: value}
+	}
+	if err := writer.WriteMessages(ctx, messages...); err != nil {
+		return fmt.Errorf("failed to write %d messages to Kafka for topic %s: %w", len(values), topic, err)
+	}
+
+	duration := time.Since(startTime).Seconds()
+	kafkaMessageCount.WithLabelValues(topic).Add(float64(len(values)))
+	kafkaMessageDuration.WithLabelValues(topic).Observe(duration)
+	log.Printf("Batch of %d messages successfully sent to topic %s", len(values), topic)
+	return nil
+}
+
+// Ack is the broker's acknowledgement of a message written by ProduceSync.
+type Ack struct {
+	Partition int       `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+var (
+	clientOnce  sync.Once
+	kafkaClient *kafka.Client
+)
+
+// getKafkaClient initializes or reuses the client used for synchronous produce requests.
+func getKafkaClient() *kafka.Client {
+	clientOnce.Do(func() {
+		kafkaClient = &kafka.Client{Addr: kafka.TCP(config.GetKafkaBroker())}
+	})
+	return kafkaClient
+}
+
+// ProduceSync writes already-encoded message values to the specified Kafka topic in a single
+// produce request and waits until every in-sync replica has them or ctx is done. The messages
+// go to the partition the writer would choose, and each one's partition, offset and broker
+// timestamp is returned. The timestamp is the log append time for topics that use it and the
+// time the messages were sent otherwise.
+func ProduceSync(ctx context.Context, topic string, values [][]byte) ([]Ack, error) {
+	client := getKafkaClient()
+	startTime := time.Now()
+
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata for topic %s: %w", topic, err)
+	}
+	if len(metadata.Topics) != 1 || metadata.Topics[0].Error != nil || len(metadata.Topics[0].Partitions) == 0 {
+		err = errors.New("no partitions")
+		if len(metadata.Topics) == 1 && metadata.Topics[0].Error != nil {
+			err = metadata.Topics[0].Error
+		}
+		return nil, fmt.Errorf("failed to read metadata for topic %s: %w", topic, err)
+	}
+	partitions := make([]int, len(metadata.Topics[0].Partitions))
+	for i := range partitions {
+		partitions[i] = i
+	}
+
+	key := []byte(topic)
+	partition := kafka.CRC32Balancer{}.Balance(kafka.Message{Key: key}, partitions...)
+	sentAt := time.Now().Truncate(time.Millisecond).UTC()
+	records := make([]kafka.Record, len(values))
+	for i, value := range values {
+		records[i] = kafka.Record{Time: sentAt, Key: kafka.NewBytes(key), Value: kafka.NewBytes(value)}
+	}
+	res, err := client.Produce(ctx, &kafka.ProduceRequest{
+		Topic:        topic,
+		Partition:    partition,
+		RequiredAcks: kafka.RequireAll,
+		Records:      kafka.NewRecordReader(records...),
+	})
+	if err == nil {
+		err = res.Error
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write %d messages to Kafka for topic %s: %w", len(values), topic, err)
+	}
+
+	timestamp := res.LogAppendTime
+	if timestamp.IsZero() {
+		timestamp = sentAt
+	}
+	acks := make([]Ack, len(values))
+	for i := range acks {
+		acks[i] = Ack{Partition: partition, Offset: res.BaseOffset + int64(i), Timestamp: timestamp}
+	}
+
+	duration := time.Since(startTime).Seconds()
+	kafkaMessageCount.WithLabelValues(topic).Add(float64(len(values)))
+	kafkaMessageDuration.WithLabelValues(topic).Observe(duration)
+	log.Printf("%d messages acknowledged by topic %s partition %d at offset %d", len(values), topic, partition, res.BaseOffset)
+	return acks, nil
+}
+
 // logMessageMetrics records message metrics to Prometheus.
 func logMessageMetrics(topic string, duration float64) {
 	kafkaMessageCount.WithLabelValues(topic).Inc()
@@ -0,0 +1,184 @@
+package loadshed
+
+import (
+	"blockhouse/config"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Counts produce requests admitted or shed by the limiter
+	decisions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "load_shed_decisions_total",
+			Help: "Total number of produce requests admitted or shed by the adaptive concurrency limiter",
+		},
+		[]string{"decision"},
+	)
+
+	// Tracks how many produce requests may currently be in flight
+	concurrencyLimit = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "load_shed_concurrency_limit",
+			Help: "Number of produce requests the adaptive concurrency limiter currently allows in flight",
+		},
+	)
+
+	// Tracks how many produce requests are in flight
+	inFlightRequests = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "load_shed_in_flight",
+			Help: "Number of produce requests currently in flight",
+		},
+	)
+
+	// Tracks the smoothed produce latency the limiter reacts to
+	produceLatency = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "load_shed_latency_seconds",
+			Help: "Exponentially weighted average latency of produce requests",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(decisions, concurrencyLimit, inFlightRequests, produceLatency)
+}
+
+const (
+	// backoff is the factor the limit is multiplied by when producing is congested
+	backoff = 0.8
+	// smoothing is the weight of the newest sample in the average latency
+	smoothing = 0.2
+)
+
+// Limiter adapts how many produce requests may be in flight with additive increase,
+// multiplicative decrease (AIMD): every write completing within the target latency raises the
+// limit by a fraction of a request, and a slow or failed write cuts it by a fifth. Writes
+// beyond the limit are shed so that a slow broker cannot pile up unbounded work.
+type Limiter struct {
+	enabled bool
+	target  time.Duration
+	min     float64
+	max     float64
+
+	mu           sync.Mutex
+	limit        float64
+	inFlight     int
+	latency      time.Duration // Exponentially weighted average of produce latency
+	lastDecrease time.Time
+}
+
+// New creates a limiter starting at cfg.MaxConcurrency.
+func New(cfg config.LoadShedConfig) *Limiter {
+	lowest := math.Max(1, float64(cfg.MinConcurrency))
+	highest := math.Max(lowest, float64(cfg.MaxConcurrency))
+	concurrencyLimit.Set(highest)
+	return &Limiter{enabled: cfg.Enabled, target: cfg.TargetLatency, min: lowest, max: highest, limit: highest}
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultLimiter *Limiter
+)
+
+// Default returns the process-wide limiter configured through the LOAD_SHED_* variables.
+func Default() *Limiter {
+	defaultOnce.Do(func() {
+		defaultLimiter = New(config.GetLoadShedConfig())
+	})
+	return defaultLimiter
+}
+
+// Ticket is held by an admitted produce request until it completes.
+type Ticket struct {
+	limiter *Limiter
+	start   time.Time
+}
+
+// Acquire admits a produce request unless as many as the limit are already in flight. An
+// admitted request must end with exactly one call to Done or Cancel on its ticket.
+func (l *Limiter) Acquire() (Ticket, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.enabled && float64(l.inFlight) >= math.Floor(l.limit) {
+		decisions.WithLabelValues("shed").Inc()
+		return Ticket{}, false
+	}
+	l.inFlight++
+	inFlightRequests.Inc()
+	decisions.WithLabelValues("admitted").Inc()
+	return Ticket{limiter: l, start: time.Now()}, true
+}
+
+// Done reports that the request's write completed, successfully or with err, and adapts the
+// limit to how long it took.
+func (t Ticket) Done(err error) {
+	t.limiter.release(t.start, time.Since(t.start), err != nil, true)
+}
+
+// Cancel releases the request's slot without a write, for requests refused after admission.
+func (t Ticket) Cancel() {
+	t.limiter.release(t.start, 0, false, false)
+}
+
+// release frees a slot and, with feedback, moves the limit
+func (l *Limiter) release(start time.Time, latency time.Duration, failed, feedback bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	inFlightRequests.Dec()
+	if !feedback {
+		return
+	}
+
+	if l.latency == 0 {
+		l.latency = latency
+	} else {
+		l.latency = time.Duration(smoothing*float64(latency) + (1-smoothing)*float64(l.latency))
+	}
+	produceLatency.Set(l.latency.Seconds())
+
+	switch {
+	case failed || latency > l.target:
+		// Writes started before the last cut saw the same congestion; count it once
+		if start.After(l.lastDecrease) {
+			l.limit = math.Max(l.min, l.limit*backoff)
+			l.lastDecrease = time.Now()
+		}
+	case float64(l.inFlight+1)*2 >= l.limit:
+		// Only grow while the limit is in use, so that it cannot drift up while idle
+		l.limit = math.Min(l.max, l.limit+1/l.limit)
+	}
+	concurrencyLimit.Set(math.Floor(l.limit))
+}
+
+// Limit returns how many produce requests may currently be in flight.
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// InFlight returns how many admitted produce requests have not completed yet.
+func (l *Limiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// RetryAfter suggests how long a shed client should wait: the average produce latency, but at
+// least a second.
+func (l *Limiter) RetryAfter() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.latency < time.Second {
+		return time.Second
+	}
+	return l.latency
+}
@@ -2,11 +2,22 @@ package main
 
 import (
 	"blockhouse/api"
+	"blockhouse/api/handlers"
 	"blockhouse/api/middleware"
+	"blockhouse/auth"
+	"blockhouse/clientip"
 	"blockhouse/config"
+	"blockhouse/idempotency"
 	"blockhouse/kafka"
+	"blockhouse/quotas"
+	"blockhouse/ratelimit"
+	"blockhouse/tlsconfig"
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -48,16 +59,42 @@ func main() {
 	// Load environment configuration
 	config.LoadEnv()
 
+	// Fail fast on authentication misconfiguration rather than on the first request
+	if auth.Mode() != config.AuthModeAPIKey {
+		auth.DefaultVerifier()
+	}
+
+	// Pick up rotated API keys from the key file as it changes, and from .env on SIGHUP
+	keys := auth.DefaultKeyRing()
+	go keys.Watch(context.Background(), config.GetEnvInterval("API_KEYS_RELOAD_INTERVAL", 10*time.Second))
+	reloadOnSignal(keys)
+
 	// Set up router with middleware and routes
 	router := setupRouter()
 
 	// Start Kafka consumer in a separate goroutine
 	initializeKafkaConsumer("stream_topic")
 
-	// Start the HTTP server
+	// Purge soft-deleted streams once their grace period ends
+	handlers.StartJanitor(context.Background(), config.GetEnvInterval("STREAM_JANITOR_INTERVAL", time.Minute))
+
+	// Start the HTTP server, over TLS when a certificate is configured
 	port := config.GetEnv("WEBSOCKET_PORT")
-	log.Printf("Server is starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, router))
+	tlsCfg := config.GetTLSConfig()
+	if !tlsCfg.Enabled() {
+		log.Printf("Server is starting on port %s", port)
+		log.Fatal(http.ListenAndServe(":"+port, router))
+	}
+
+	certs, err := tlsconfig.New(tlsCfg)
+	if err != nil {
+		log.Fatalf("Failed to load TLS configuration: %v", err)
+	}
+	go certs.Watch(context.Background(), tlsCfg.ReloadInterval)
+
+	server := &http.Server{Addr: ":" + port, Handler: router, TLSConfig: certs.TLSConfig()}
+	log.Printf("Server is starting with TLS on port %s (client certificates: %s)", port, tlsCfg.ClientAuth)
+	log.Fatal(server.ListenAndServeTLS("", ""))
 }
 
 // setupRouter configures API routes, applies middleware, and sets up the Prometheus endpoint
@@ -67,19 +104,55 @@ func setupRouter() *mux.Router {
 	// Add Prometheus metrics endpoint
 	router.Handle("/metrics", promhttp.Handler())
 
-	// Initialize rate limiter with a limit of 10 requests per second and burst capacity of 20
-	rateLimiter := middleware.NewRateLimiter(10, 20)
+	// Initialize rate limiter with the policies from RATE_LIMIT_POLICY_FILE (by default 10 requests
+	// per second with bursts of 20 per client), keeping its buckets in the backend selected by
+	// RATE_LIMIT_BACKEND
+	limitConfig := config.GetRateLimitConfig()
+	backend, err := ratelimit.Open(limitConfig)
+	if err != nil {
+		log.Fatalf("Failed to set up %s rate limiter: %v", limitConfig.Backend, err)
+	}
+	log.Printf("Using %s rate limit backend", limitConfig.Backend)
+	if limitConfig.ClientKey != config.ClientKeyIP && limitConfig.ClientKey != config.ClientKeyPrincipal {
+		log.Fatalf("Invalid RATE_LIMIT_CLIENT_KEY %q: must be %q or %q", limitConfig.ClientKey, config.ClientKeyIP, config.ClientKeyPrincipal)
+	}
+	clientip.Default() // Fail fast on malformed TRUSTED_PROXIES
+	if mode := config.GetBatchConfig().Mode; mode != config.BatchModeAllOrNothing && mode != config.BatchModeBestEffort {
+		log.Fatalf("Invalid BATCH_MODE %q: must be %q or %q", mode, config.BatchModeAllOrNothing, config.BatchModeBestEffort)
+	}
+	rateLimiter := middleware.NewPolicyRateLimiter(backend, ratelimit.DefaultEngine(), limitConfig.ClientKey, limitConfig.FailOpen)
+	maxBody := quotas.Default().MaxBodyBytes()
 
 	// Apply middlewares in the preferred order
 	router.Use(
-		middleware.LoggingMiddleware,                // Logs incoming requests
-		middleware.AuthMiddleware,                   // Validates the API key
-		middleware.RateLimitMiddleware(rateLimiter), // Throttles excessive requests
+		middleware.LoggingMiddleware,                                     // Logs incoming requests
+		middleware.AuthMiddleware,                                        // Validates the API key
+		middleware.RateLimitMiddleware(rateLimiter),                      // Throttles excessive requests
+		middleware.IdempotencyMiddleware(idempotency.Default(), maxBody), // Replays writes retried with the same Idempotency-Key
+		middleware.BodyLimitMiddleware(maxBody),                          // Refuses write bodies over MAX_BODY_BYTES
 	)
 
 	return router
 }
 
+// reloadOnSignal reloads the .env file and the API key ring whenever the process receives SIGHUP
+func reloadOnSignal(keys *auth.KeyRing) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := config.ReloadEnv(); err != nil {
+				log.Printf("Error reloading .env file: %v", err)
+			}
+			if err := keys.Reload(); err != nil {
+				log.Printf("Error reloading API keys: %v", err)
+				continue
+			}
+			log.Printf("Reloaded API keys on SIGHUP")
+		}
+	}()
+}
+
 // initializeKafkaConsumer starts a Kafka consumer to process messages in a separate goroutine
 func initializeKafkaConsumer(topic string) {
 	go kafka.ConsumeMessages(topic) // Start Kafka consumer
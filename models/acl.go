@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Roles a principal can hold on a stream
+const (
+	RoleProducer = "producer" // Send data to the stream
+	RoleConsumer = "consumer" // Read results and subscribe over WebSocket
+	RoleOwner    = "owner"    // Everything on the stream, including deleting it and managing its ACL
+	RoleAdmin    = "admin"    // Held on every stream by principals with the admin scope; cannot be granted
+)
+
+// Grant gives a principal a role on a single stream.
+type Grant struct {
+	Principal string    `json:"principal"`            // Principal ID: key ID, "jwt:<subject>" or "cert:<identity>"
+	Role      string    `json:"role"`                 // RoleProducer, RoleConsumer or RoleOwner
+	GrantedBy string    `json:"granted_by,omitempty"` // Principal ID that added the grant
+	GrantedAt time.Time `json:"granted_at"`           // Time the grant was added
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Scopes that can be granted to an API key
+const (
+	ScopeStreamCreate = "stream:create" // Create, delete and restore streams and manage their schemas
+	ScopeStreamWrite  = "stream:write"  // Send data to streams
+	ScopeStreamRead   = "stream:read"   // List streams and read their results
+	ScopeAdmin        = "admin"         // Manage API keys; implies every other scope
+)
+
+// APIKey is the persisted record of an API key accepted by the server.
+// Only a hash of the secret and its sealed signing key are stored, never the secret itself.
+type APIKey struct {
+	ID        string     `json:"id"`                   // Fingerprint of the key secret
+	Name      string     `json:"name"`                 // Human-readable label for the key
+	Tenant    string     `json:"tenant,omitempty"`     // Tenant the key acts for; empty means the default tenant
+	Hash      string     `json:"hash,omitempty"`       // Hex SHA-256 of the key secret
+	Sealed    string     `json:"sealed,omitempty"`     // Request signing key, encrypted with the key store's sealing key
+	Scopes    []string   `json:"scopes"`               // Scopes granted to the key
+	Enabled   bool       `json:"enabled"`              // False once the key has been revoked
+	CreatedAt time.Time  `json:"created_at"`           // Time the key was first registered
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Time after which the key is no longer accepted
+	RevokedAt *time.Time `json:"revoked_at,omitempty"` // Time the key was revoked
+}
+
+// HasScope reports whether the key grants scope. The admin scope grants every scope.
+func (k APIKey) HasScope(scope string) bool {
+	return GrantsScope(k.Scopes, scope)
+}
+
+// GrantsScope reports whether a list of granted scopes includes scope, directly or through admin.
+func GrantsScope(granted []string, scope string) bool {
+	for _, g := range granted {
+		if g == scope || g == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
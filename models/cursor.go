@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Cursor records how far a consumer group has progressed through a stream.
+type Cursor struct {
+	StreamID  string    `json:"stream_id"`  // Stream the consumer group reads from
+	GroupID   string    `json:"group_id"`   // Kafka consumer group ID
+	Partition int       `json:"partition"`  // Partition of the last processed message
+	Offset    int64     `json:"offset"`     // Offset of the last processed message
+	Count     int       `json:"count"`      // Number of messages processed so far
+	UpdatedAt time.Time `json:"updated_at"` // Time the cursor last advanced
+}
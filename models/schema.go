@@ -0,0 +1,26 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// SchemaVersion is one registered version of a stream's JSON Schema.
+type SchemaVersion struct {
+	Version   int             `json:"version"`    // Version number, starting at 1
+	Schema    json.RawMessage `json:"schema"`     // JSON Schema document
+	CreatedAt time.Time       `json:"created_at"` // Time the version was registered
+}
+
+// SchemaSet holds every schema version registered for a stream and the
+// compatibility mode new versions are checked against.
+type SchemaSet struct {
+	StreamID      string          `json:"stream_id"`     // Stream the schemas apply to
+	Compatibility string          `json:"compatibility"` // "none", "backward", "forward" or "full"
+	Versions      []SchemaVersion `json:"versions"`      // Registered versions, oldest first
+}
+
+// Latest returns the most recently registered schema version.
+func (s SchemaSet) Latest() SchemaVersion {
+	return s.Versions[len(s.Versions)-1]
+}
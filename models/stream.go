@@ -1,8 +1,62 @@
 package models
 
-// Stream represents a data model for a streaming session, identified by a unique ID
-// and carrying associated data as a string payload.
+import "time"
+
+// StreamStatus describes where a stream is in its lifecycle.
+type StreamStatus string
+
+const (
+	StreamStatusActive          StreamStatus = "active"           // Stream accepts data and subscribers
+	StreamStatusPendingDeletion StreamStatus = "pending_deletion" // Stream is soft-deleted and can be restored until its grace period ends
+	StreamStatusDeleted         StreamStatus = "deleted"          // Stream has been removed and rejects all traffic
+)
+
+// Value encodings a stream can use when writing payloads to Kafka.
+const (
+	EncodingJSON     = "json"     // Payloads are written as JSON (default)
+	EncodingAvro     = "avro"     // Payloads are Avro-encoded in the schema registry wire format
+	EncodingProtobuf = "protobuf" // Payloads are Protobuf-encoded in the schema registry wire format
+)
+
+// StreamConfig holds the settings a stream was created with, including the
+// Kafka topic settings applied when its topic was provisioned.
+type StreamConfig struct {
+	Topic             string `json:"topic"`                       // Kafka topic backing the stream
+	Partitions        int    `json:"partitions"`                  // Number of topic partitions
+	ReplicationFactor int    `json:"replication_factor"`          // Number of replicas per partition
+	RetentionMs       int64  `json:"retention_ms,omitempty"`      // Topic retention.ms; zero uses the broker default
+	CleanupPolicy     string `json:"cleanup_policy,omitempty"`    // Topic cleanup.policy; empty uses the broker default
+	MaxMessageBytes   int    `json:"max_message_bytes,omitempty"` // Topic max.message.bytes; zero uses the broker default
+	TTL               string `json:"ttl,omitempty"`               // Maximum lifetime of the stream, e.g. "24h"
+	IdleTimeout       string `json:"idle_timeout,omitempty"`      // Expire after this long without data or subscribers, e.g. "30m"
+	Encoding          string `json:"encoding"`                    // Value encoding: json, avro or protobuf
+	SchemaID          int    `json:"schema_id,omitempty"`         // Schema registry ID used to encode values
+	MessageType       string `json:"message_type,omitempty"`      // Protobuf message used to encode values
+}
+
+// Stream represents a streaming session registered by StartStream, identified by a unique ID.
 type Stream struct {
-	ID   string `json:"id"`   // Unique identifier for the stream
-	Data string `json:"data"` // Data payload associated with the stream
+	ID           string       `json:"id"`                      // Unique identifier for the stream
+	Tenant       string       `json:"tenant,omitempty"`        // Tenant the stream belongs to; empty for streams created before tenants
+	Owner        string       `json:"owner"`                   // Principal ID of the caller that created the stream
+	ACL          []Grant      `json:"acl,omitempty"`           // Roles granted on the stream to principals other than the owner
+	CreatedAt    time.Time    `json:"created_at"`              // Time the stream was registered
+	Config       StreamConfig `json:"config"`                  // Settings the stream was created with
+	Status       StreamStatus `json:"status"`                  // Current lifecycle status
+	DeletedAt    *time.Time   `json:"deleted_at,omitempty"`    // Time the stream was deleted, if it has been
+	DeleteAfter  *time.Time   `json:"delete_after,omitempty"`  // End of the soft-delete grace period, if one is pending
+	ExpiresAt    *time.Time   `json:"expires_at,omitempty"`    // Time the stream's TTL runs out, if it has one
+	LastActive   *time.Time   `json:"last_active,omitempty"`   // Last time data was sent or a subscriber was attached
+	WrittenBytes int64        `json:"written_bytes,omitempty"` // Bytes accepted for the stream's topic, counted against its tenant's write volume limit
+
+	// Stream tokens carry the generation they were issued under; bumping it revokes them all
+	TokenGeneration int `json:"token_generation"`
+}
+
+// Topic returns the Kafka topic backing the stream. Streams registered without one use their ID.
+func (s Stream) Topic() string {
+	if s.Config.Topic != "" {
+		return s.Config.Topic
+	}
+	return s.ID
 }
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// DefaultTenant owns keys and streams that were not assigned a tenant, including everything
+// created before tenants existed.
+const DefaultTenant = "default"
+
+// TenantOf returns the tenant ID recorded on a key, principal or stream, treating an empty
+// one as the default tenant.
+func TenantOf(id string) string {
+	if id == "" {
+		return DefaultTenant
+	}
+	return id
+}
+
+// TenantLimits caps what a tenant's streams may use. Zero values mean unlimited.
+type TenantLimits struct {
+	MaxStreams        int     `json:"max_streams,omitempty"`         // Streams that may exist at once, including those pending deletion
+	MessagesPerSecond float64 `json:"messages_per_second,omitempty"` // Sustained produce rate across all of the tenant's streams
+	Burst             int     `json:"burst,omitempty"`               // Messages that may be sent at once above the rate; defaults to one second's worth
+	MaxWrittenBytes   int64   `json:"max_written_bytes,omitempty"`   // Bytes that may be written to the tenant's streams that still exist, whatever Kafka has since dropped
+}
+
+// Tenant is an isolated customer of the deployment. Its streams live under their own topic
+// prefix and consumer groups, are invisible to other tenants and share its limits.
+type Tenant struct {
+	ID        string       `json:"id"`             // Unique identifier, also used as the Kafka topic prefix
+	Name      string       `json:"name"`           // Human-readable label for the tenant
+	Tier      string       `json:"tier,omitempty"` // Service tier selecting the tenant's rate limit policies
+	Limits    TenantLimits `json:"limits"`         // Quotas enforced on the tenant's streams
+	CreatedAt time.Time    `json:"created_at"`     // Time the tenant was registered
+}
@@ -0,0 +1,287 @@
+package quotas
+
+import (
+	"blockhouse/config"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+var (
+	// ErrRateExceeded is returned when a write would exceed a bytes per second quota.
+	ErrRateExceeded = errors.New("byte rate quota exceeded")
+	// ErrDailyExceeded is returned when a write would exceed a bytes per day quota.
+	ErrDailyExceeded = errors.New("daily byte quota exceeded")
+)
+
+var (
+	// Counts payload bytes accepted by the quota check, by whether they were charged to a principal or a stream
+	acceptedBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quota_bytes_accepted_total",
+			Help: "Total number of payload bytes accepted by the byte quotas",
+		},
+		[]string{"scope"},
+	)
+
+	// Counts writes refused because a principal or stream reached a byte quota
+	rejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quota_rejections_total",
+			Help: "Total number of writes refused because a byte quota was reached",
+		},
+		[]string{"scope", "limit"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(acceptedBytes, rejections)
+}
+
+// Scopes a quota is charged to
+const (
+	ScopePrincipal = "principal"
+	ScopeStream    = "stream"
+)
+
+// Limits are the byte quotas applying to one principal or stream. Zero means unlimited.
+type Limits struct {
+	BytesPerSecond int64 `json:"bytes_per_second,omitempty"`
+	BytesPerDay    int64 `json:"bytes_per_day,omitempty"`
+}
+
+// Usage reports how many payload bytes a principal or stream has sent.
+type Usage struct {
+	ID         string `json:"id"`
+	Day        string `json:"day"`         // UTC day BytesToday counts, as YYYY-MM-DD
+	BytesToday int64  `json:"bytes_today"` // Bytes accepted since the start of Day
+	BytesTotal int64  `json:"bytes_total"` // Bytes accepted since the process started
+	Requests   int64  `json:"requests"`    // Writes accepted since the process started
+	Rejected   int64  `json:"rejected"`    // Writes refused by a quota since the process started
+	Limits     Limits `json:"limits"`
+}
+
+// counter tracks one principal or stream
+type counter struct {
+	limiter *rate.Limiter // Nil without a bytes per second quota
+	usage   Usage
+}
+
+// Manager charges payload bytes to the principal sending them and the stream receiving them.
+// Usage is tracked in memory, so each instance enforces the quotas on its own traffic.
+type Manager struct {
+	cfg config.QuotaConfig
+
+	mu         sync.Mutex
+	principals map[string]*counter
+	streams    map[string]*counter
+}
+
+// New creates a manager enforcing the quotas in cfg.
+func New(cfg config.QuotaConfig) *Manager {
+	return &Manager{cfg: cfg, principals: make(map[string]*counter), streams: make(map[string]*counter)}
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultManager *Manager
+)
+
+// Default returns the process-wide quota manager configured through MAX_BODY_BYTES and the
+// QUOTA_* variables.
+func Default() *Manager {
+	defaultOnce.Do(func() {
+		defaultManager = New(config.GetQuotaConfig())
+	})
+	return defaultManager
+}
+
+// MaxBodyBytes returns the largest request body the produce endpoints accept, or zero when
+// there is no limit.
+func (m *Manager) MaxBodyBytes() int64 {
+	return m.cfg.MaxBodyBytes
+}
+
+// limits returns the quotas configured for a scope
+func (m *Manager) limits(scope string) Limits {
+	if scope == ScopeStream {
+		return Limits{BytesPerSecond: m.cfg.StreamBytesPerSecond, BytesPerDay: m.cfg.StreamBytesPerDay}
+	}
+	return Limits{BytesPerSecond: m.cfg.PrincipalBytesPerSecond, BytesPerDay: m.cfg.PrincipalBytesPerDay}
+}
+
+// counter retrieves or creates the counter for id, starting a new day if needed; m.mu must be held
+func (m *Manager) counter(scope, id string, now time.Time) *counter {
+	counters := m.principals
+	if scope == ScopeStream {
+		counters = m.streams
+	}
+	c, ok := counters[id]
+	if !ok {
+		limits := m.limits(scope)
+		c = &counter{usage: Usage{ID: id, Limits: limits}}
+		if limits.BytesPerSecond > 0 {
+			// Any body the endpoints accept must fit in the bucket, or it could never be sent
+			burst := int(math.Max(float64(limits.BytesPerSecond), float64(m.cfg.MaxBodyBytes)))
+			c.limiter = rate.NewLimiter(rate.Limit(limits.BytesPerSecond), burst)
+		}
+		counters[id] = c
+	}
+	if day := now.UTC().Format(time.DateOnly); c.usage.Day != day {
+		c.usage.Day, c.usage.BytesToday = day, 0
+	}
+	return c
+}
+
+// check reports whether n more bytes fit in a counter's quotas, reserving them from its rate
+// bucket if so; the reservation must be cancelled if the write is refused elsewhere
+func check(scope string, c *counter, n int64, now time.Time) (*rate.Reservation, time.Duration, error) {
+	if limit := c.usage.Limits.BytesPerDay; limit > 0 && c.usage.BytesToday+n > limit {
+		midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return nil, midnight.Sub(now), fmt.Errorf("%w: %s %s has sent %d of %d bytes today", ErrDailyExceeded, scope, c.usage.ID, c.usage.BytesToday, limit)
+	}
+	if c.limiter == nil {
+		return nil, 0, nil
+	}
+	reservation := c.limiter.ReserveN(now, int(n))
+	if !reservation.OK() {
+		return nil, 0, fmt.Errorf("%w: %d bytes exceed the %s bucket of %d bytes", ErrRateExceeded, n, scope, c.limiter.Burst())
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return nil, delay, fmt.Errorf("%w: %s %s may send %d bytes per second", ErrRateExceeded, scope, c.usage.ID, c.usage.Limits.BytesPerSecond)
+	}
+	return reservation, 0, nil
+}
+
+// Charge records n payload bytes sent by principal to stream, or refuses them with
+// ErrRateExceeded or ErrDailyExceeded and how long to wait before retrying. Either ID may be
+// empty to skip that scope.
+func (m *Manager) Charge(principal, stream string, n int64) (time.Duration, error) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type charge struct {
+		scope   string
+		counter *counter
+	}
+	var charges []charge
+	if principal != "" {
+		charges = append(charges, charge{ScopePrincipal, m.counter(ScopePrincipal, principal, now)})
+	}
+	if stream != "" {
+		charges = append(charges, charge{ScopeStream, m.counter(ScopeStream, stream, now)})
+	}
+
+	var reservations []*rate.Reservation
+	for _, ch := range charges {
+		reservation, retryAfter, err := check(ch.scope, ch.counter, n, now)
+		if err != nil {
+			for _, r := range reservations {
+				r.CancelAt(now)
+			}
+			limit := "second"
+			if errors.Is(err, ErrDailyExceeded) {
+				limit = "day"
+			}
+			rejections.WithLabelValues(ch.scope, limit).Inc()
+			for _, c := range charges {
+				c.counter.usage.Rejected++
+			}
+			return retryAfter, err
+		}
+		if reservation != nil {
+			reservations = append(reservations, reservation)
+		}
+	}
+
+	for _, ch := range charges {
+		ch.counter.usage.BytesToday += n
+		ch.counter.usage.BytesTotal += n
+		ch.counter.usage.Requests++
+		acceptedBytes.WithLabelValues(ch.scope).Add(float64(n))
+	}
+	return 0, nil
+}
+
+// Refund gives back n bytes charged to principal and stream by a write that was refused or failed
+// after it was charged. Daily and total usage are reduced; the per-second bucket is not refilled,
+// as it recovers within seconds anyway, and quota_bytes_accepted_total keeps counting the bytes.
+func (m *Manager) Refund(principal, stream string, n int64) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refund := func(id string, counters map[string]*counter) {
+		c, ok := counters[id]
+		if id == "" || !ok {
+			return
+		}
+		if day := now.UTC().Format(time.DateOnly); c.usage.Day == day && c.usage.BytesToday >= n {
+			c.usage.BytesToday -= n
+		}
+		if c.usage.BytesTotal >= n && c.usage.Requests > 0 {
+			c.usage.BytesTotal -= n
+			c.usage.Requests--
+		}
+	}
+	refund(principal, m.principals)
+	refund(stream, m.streams)
+}
+
+// usage copies a counter's usage, as of now
+func (m *Manager) usage(scope, id string, now time.Time) Usage {
+	counters := m.principals
+	if scope == ScopeStream {
+		counters = m.streams
+	}
+	c, ok := counters[id]
+	if !ok {
+		return Usage{ID: id, Day: now.UTC().Format(time.DateOnly), Limits: m.limits(scope)}
+	}
+	usage := c.usage
+	if day := now.UTC().Format(time.DateOnly); usage.Day != day {
+		usage.Day, usage.BytesToday = day, 0
+	}
+	return usage
+}
+
+// PrincipalUsage returns what a principal has sent; a principal that has sent nothing has
+// zero usage.
+func (m *Manager) PrincipalUsage(id string) Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usage(ScopePrincipal, id, time.Now())
+}
+
+// List returns the usage of every principal and stream that has sent or received bytes,
+// each sorted by ID.
+func (m *Manager) List() (principals, streams []Usage) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	collect := func(scope string, counters map[string]*counter) []Usage {
+		list := make([]Usage, 0, len(counters))
+		for id := range counters {
+			list = append(list, m.usage(scope, id, now))
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		return list
+	}
+	return collect(ScopePrincipal, m.principals), collect(ScopeStream, m.streams)
+}
+
+// ForgetStream drops the usage of a deleted stream.
+func (m *Manager) ForgetStream(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.streams, id)
+}
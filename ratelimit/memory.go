@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limits applied by NewMemoryBackend
+const (
+	DefaultMaxKeys     = 100000
+	DefaultIdleTimeout = 10 * time.Minute
+)
+
+// memoryEntry is a bucket and when it was last used
+type memoryEntry struct {
+	key      string
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// MemoryBackend keeps a token bucket per key in the process. Each instance enforces its
+// limits on its own traffic only. Buckets unused for the idle timeout are dropped, and once
+// the backend holds its maximum number of buckets the least recently used one makes room.
+type MemoryBackend struct {
+	maxKeys     int
+	idleTimeout time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Entries by last use, most recent first
+}
+
+// NewMemoryBackend creates an empty in-memory backend with the default size and idle timeout.
+func NewMemoryBackend() *MemoryBackend {
+	return NewBoundedMemoryBackend(DefaultMaxKeys, DefaultIdleTimeout)
+}
+
+// NewBoundedMemoryBackend creates an empty in-memory backend holding at most maxKeys buckets
+// and dropping those unused for idleTimeout. Zero disables either bound. The idle timeout
+// should exceed the time a bucket takes to refill, or an idle client gets a full bucket early.
+func NewBoundedMemoryBackend(maxKeys int, idleTimeout time.Duration) *MemoryBackend {
+	return &MemoryBackend{maxKeys: maxKeys, idleTimeout: idleTimeout, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// remove drops an entry, counting why; m.mu must be held
+func (m *MemoryBackend) remove(element *list.Element, reason string) {
+	m.order.Remove(element)
+	delete(m.entries, element.Value.(*memoryEntry).key)
+	trackedClients.Dec()
+	evictions.WithLabelValues(reason).Inc()
+}
+
+// limiter retrieves or creates the bucket for key, adjusted to limit, evicting idle buckets
+// and, when full, the least recently used one
+func (m *MemoryBackend) limiter(key string, limit Limit, now time.Time) *rate.Limiter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.idleTimeout > 0 {
+		for oldest := m.order.Back(); oldest != nil && now.Sub(oldest.Value.(*memoryEntry).lastSeen) > m.idleTimeout; oldest = m.order.Back() {
+			m.remove(oldest, "idle")
+		}
+	}
+
+	if element, ok := m.entries[key]; ok {
+		entry := element.Value.(*memoryEntry)
+		entry.lastSeen = now
+		m.order.MoveToFront(element)
+		if entry.limiter.Limit() != rate.Limit(limit.Rate) {
+			entry.limiter.SetLimitAt(now, rate.Limit(limit.Rate))
+		}
+		if entry.limiter.Burst() != limit.Burst {
+			entry.limiter.SetBurstAt(now, limit.Burst)
+		}
+		return entry.limiter
+	}
+
+	if m.maxKeys > 0 && len(m.entries) >= m.maxKeys {
+		m.remove(m.order.Back(), "capacity")
+	}
+	entry := &memoryEntry{key: key, limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst), lastSeen: now}
+	m.entries[key] = m.order.PushFront(entry)
+	trackedClients.Inc()
+	return entry.limiter
+}
+
+// Allow takes one request from the bucket for key.
+func (m *MemoryBackend) Allow(_ context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+	limiter := m.limiter(key, limit, now)
+
+	reservation := limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		// A zero burst never allows anything
+		return Result{}, nil
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return Result{RetryAfter: delay, ResetAfter: resetAfter(limiter, limit, now)}, nil
+	}
+	return Result{Allowed: true, Remaining: int(limiter.TokensAt(now)), ResetAfter: resetAfter(limiter, limit, now)}, nil
+}
+
+// resetAfter returns how long the bucket takes to refill completely
+func resetAfter(limiter *rate.Limiter, limit Limit, now time.Time) time.Duration {
+	missing := float64(limit.Burst) - limiter.TokensAt(now)
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / limit.Rate * float64(time.Second))
+}
+
+// Len returns how many buckets the backend currently holds.
+func (m *MemoryBackend) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// Close drops every bucket.
+func (m *MemoryBackend) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	trackedClients.Sub(float64(len(m.entries)))
+	m.entries = make(map[string]*list.Element)
+	m.order.Init()
+	return nil
+}
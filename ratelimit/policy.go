@@ -0,0 +1,278 @@
+package ratelimit
+
+import (
+	"blockhouse/config"
+	"blockhouse/store"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// What a policy counts requests by; each distinct value gets its own bucket
+const (
+	PerClient    = "client"    // Client address or certificate identity (the default)
+	PerPrincipal = "principal" // Authenticated caller, or the client for anonymous requests
+	PerTenant    = "tenant"    // Caller's tenant, or the client for anonymous requests
+	PerStream    = "stream"    // Stream in the path, or the client on other routes
+	PerGlobal    = "global"    // One bucket shared by every request the policy matches
+)
+
+// DefaultPolicy names the limit applied to requests no policy matches.
+const DefaultPolicy = "default"
+
+// policiesKey is the metadata store key holding the policies set at runtime
+const policiesKey = "policies"
+
+// ErrInvalidPolicy is returned for a policy set that cannot be applied.
+var ErrInvalidPolicy = errors.New("invalid rate limit policy")
+
+// Request describes what a policy can match a request on.
+type Request struct {
+	Client    string // Client address or certificate identity
+	Principal string // Authenticated caller's ID; empty for anonymous requests
+	Name      string // Authenticated caller's name, such as an API key name
+	Tenant    string // Authenticated caller's tenant
+	Tier      string // Tier of the caller's tenant
+	Stream    string // Stream ID in the path, if any
+	Route     string // Path template of the matched route, such as /stream/{stream_id}/send
+	Method    string // HTTP method
+}
+
+// Policy limits the requests matching all of its non-empty conditions.
+type Policy struct {
+	Name      string `json:"name"`                // Unique name, also part of the bucket key
+	Principal string `json:"principal,omitempty"` // Caller's principal ID or key name
+	Tenant    string `json:"tenant,omitempty"`    // Caller's tenant
+	Tier      string `json:"tier,omitempty"`      // Tier of the caller's tenant
+	Stream    string `json:"stream,omitempty"`    // Stream ID in the path
+	Route     string `json:"route,omitempty"`     // Route path template
+	Method    string `json:"method,omitempty"`    // HTTP method
+	Per       string `json:"per,omitempty"`       // What requests are counted by; PerClient when empty
+	Limit
+}
+
+// matches reports whether every condition of the policy holds for req
+func (p Policy) matches(req Request) bool {
+	return (p.Principal == "" || p.Principal == req.Principal || (req.Principal != "" && p.Principal == req.Name)) &&
+		(p.Tenant == "" || p.Tenant == req.Tenant) &&
+		(p.Tier == "" || p.Tier == req.Tier) &&
+		(p.Stream == "" || p.Stream == req.Stream) &&
+		(p.Route == "" || p.Route == req.Route) &&
+		(p.Method == "" || strings.EqualFold(p.Method, req.Method))
+}
+
+// Key returns the bucket counting req under the policy.
+func (p Policy) Key(req Request) string {
+	prefix := "policy:" + p.Name + ":"
+	switch {
+	case p.Per == PerGlobal:
+		return prefix + "global"
+	case p.Per == PerPrincipal && req.Principal != "":
+		return prefix + "principal:" + req.Principal
+	case p.Per == PerTenant && req.Principal != "":
+		return prefix + "tenant:" + req.Tenant
+	case p.Per == PerStream && req.Stream != "":
+		return prefix + "stream:" + req.Stream
+	default:
+		return prefix + "client:" + req.Client
+	}
+}
+
+// PolicySet is an ordered list of policies; the first one matching a request applies, and
+// Default applies per client to requests none match.
+type PolicySet struct {
+	Default  Limit    `json:"default"`
+	Policies []Policy `json:"policies"`
+}
+
+// checkLimit rejects limits that would refuse every request
+func checkLimit(name string, limit Limit) error {
+	if limit.Rate <= 0 || limit.Burst <= 0 {
+		return fmt.Errorf("%w: %s must have a positive rate and burst", ErrInvalidPolicy, name)
+	}
+	return nil
+}
+
+// Validate checks that every limit is positive and every policy has a unique name and a known
+// per value.
+func (s PolicySet) Validate() error {
+	if err := checkLimit("the default limit", s.Default); err != nil {
+		return err
+	}
+	names := map[string]bool{DefaultPolicy: true}
+	for _, policy := range s.Policies {
+		if policy.Name == "" || strings.Contains(policy.Name, ":") {
+			return fmt.Errorf("%w: every policy needs a name without ':'", ErrInvalidPolicy)
+		}
+		if names[policy.Name] {
+			return fmt.Errorf("%w: policy name %q is used twice or reserved", ErrInvalidPolicy, policy.Name)
+		}
+		names[policy.Name] = true
+		if err := checkLimit("policy "+policy.Name, policy.Limit); err != nil {
+			return err
+		}
+		switch policy.Per {
+		case "", PerClient, PerPrincipal, PerTenant, PerStream, PerGlobal:
+		default:
+			return fmt.Errorf("%w: policy %s counts per unknown %q", ErrInvalidPolicy, policy.Name, policy.Per)
+		}
+	}
+	return nil
+}
+
+// Match returns the first policy matching req, or the default limit per client.
+func (s PolicySet) Match(req Request) Policy {
+	for _, policy := range s.Policies {
+		if policy.matches(req) {
+			return policy
+		}
+	}
+	return Policy{Name: DefaultPolicy, Per: PerClient, Limit: s.Default}
+}
+
+// UsesTier reports whether any policy matches on the tenant tier, which costs a lookup.
+func (s PolicySet) UsesTier() bool {
+	for _, policy := range s.Policies {
+		if policy.Tier != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadPolicyFile reads a JSON policy set. Without a "default" it falls back to fallback.
+func LoadPolicyFile(path string, fallback Limit) (PolicySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PolicySet{}, err
+	}
+	set := PolicySet{Default: fallback}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&set); err != nil {
+		return PolicySet{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := set.Validate(); err != nil {
+		return PolicySet{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return set, nil
+}
+
+// Engine holds the policies in force: those from the configuration, or those set at runtime,
+// which are kept in the metadata store and survive restarts until they are reset.
+type Engine struct {
+	store store.Store
+	base  PolicySet // Policies from the configuration
+
+	mu       sync.RWMutex
+	current  PolicySet
+	override bool // Whether current was set at runtime
+}
+
+// NewEngine creates an engine enforcing base unless policies were set at runtime earlier.
+func NewEngine(s store.Store, base PolicySet) (*Engine, error) {
+	if err := base.Validate(); err != nil {
+		return nil, err
+	}
+	if base.Policies == nil {
+		base.Policies = []Policy{}
+	}
+	e := &Engine{store: s, base: base, current: base}
+	var stored PolicySet
+	err := store.GetJSON(s, store.LimitsBucket, policiesKey, &stored)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		return nil, err
+	case stored.Validate() != nil:
+		log.Printf("Ignoring stored rate limit policies: %v", stored.Validate())
+	default:
+		e.current, e.override = stored, true
+	}
+	return e, nil
+}
+
+var (
+	defaultEngineOnce sync.Once
+	defaultEngine     *Engine
+)
+
+// DefaultEngine returns the process-wide policy engine, configured through
+// RATE_LIMIT_POLICY_FILE and shared by the rate limiter and the admin endpoints.
+func DefaultEngine() *Engine {
+	defaultEngineOnce.Do(func() {
+		base := PolicySet{Default: Limit{Rate: 10, Burst: 20}}
+		if path := config.GetRateLimitConfig().PolicyFile; path != "" {
+			loaded, err := LoadPolicyFile(path, base.Default)
+			if err != nil {
+				log.Fatalf("Failed to load rate limit policies: %v", err)
+			}
+			base = loaded
+		}
+		e, err := NewEngine(store.Default(), base)
+		if err != nil {
+			log.Fatalf("Failed to load rate limit policies: %v", err)
+		}
+		if e.override {
+			log.Printf("Using rate limit policies set at runtime")
+		}
+		defaultEngine = e
+	})
+	return defaultEngine
+}
+
+// Policies returns the policies in force and whether they were set at runtime.
+func (e *Engine) Policies() (PolicySet, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.current, e.override
+}
+
+// Match returns the policy applying to req.
+func (e *Engine) Match(req Request) Policy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.current.Match(req)
+}
+
+// UsesTier reports whether any policy in force matches on the tenant tier.
+func (e *Engine) UsesTier() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.current.UsesTier()
+}
+
+// Set replaces the policies in force until they are reset. Buckets are keyed by policy name,
+// so a policy keeps its state across updates.
+func (e *Engine) Set(set PolicySet) error {
+	if err := set.Validate(); err != nil {
+		return err
+	}
+	if set.Policies == nil {
+		set.Policies = []Policy{}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := store.PutJSON(e.store, store.LimitsBucket, policiesKey, set); err != nil {
+		return err
+	}
+	e.current, e.override = set, true
+	return nil
+}
+
+// Reset discards the policies set at runtime and goes back to those from the configuration.
+func (e *Engine) Reset() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.store.Delete(store.LimitsBucket, policiesKey); err != nil {
+		return err
+	}
+	e.current, e.override = e.base, false
+	return nil
+}
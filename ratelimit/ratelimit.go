@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"blockhouse/config"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Tracks how many clients the in-memory backend holds a bucket for
+	trackedClients = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rate_limit_tracked_clients",
+			Help: "Number of rate limit buckets held in memory",
+		},
+	)
+
+	// Counts buckets dropped from memory because they were idle or to make room for new ones
+	evictions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limit_evictions_total",
+			Help: "Total number of rate limit buckets evicted from memory",
+		},
+		[]string{"reason"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(trackedClients, evictions)
+}
+
+// Limit is a token bucket: Rate requests per second on average, with bursts of up to Burst.
+type Limit struct {
+	Rate  float64 `json:"rate"`  // Requests per second; must be positive
+	Burst int     `json:"burst"` // Requests that may be made at once
+}
+
+// Result describes the outcome of a rate limit check.
+type Result struct {
+	Allowed    bool          // Whether the request may proceed
+	Remaining  int           // Requests that could still be made immediately
+	RetryAfter time.Duration // How long to wait before a refused request would be allowed
+	ResetAfter time.Duration // How long until the bucket is full again
+}
+
+// Backend keeps rate limit state and decides whether a request may proceed.
+type Backend interface {
+	// Allow takes one request from the bucket for key, creating it with limit if needed.
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+	// Close releases any resources held by the backend.
+	Close() error
+}
+
+// Open creates the backend selected by cfg: "memory" keeps up to cfg.MaxClients buckets in the
+// process and "redis" shares them between every instance through the Redis server at
+// cfg.RedisURL, where unused buckets expire once full.
+func Open(cfg config.RateLimitConfig) (Backend, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return NewBoundedMemoryBackend(cfg.MaxClients, cfg.IdleTimeout), nil
+	case "redis":
+		return DialRedis(cfg.RedisURL)
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend %q", cfg.Backend)
+	}
+}
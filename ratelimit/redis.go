@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces rate limit keys in a Redis database shared with other data
+const redisKeyPrefix = "blockhouse:ratelimit:"
+
+// gcraScript implements the generic cell rate algorithm. The key holds the theoretical
+// arrival time (TAT) of the next request in microseconds of the Redis clock, so every
+// instance sees the same time. ARGV[1] is the emission interval and ARGV[2] the burst, and
+// the script returns {allowed, remaining, retry after, reset after} with times in microseconds.
+var gcraScript = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+if tat < now then
+	tat = now
+end
+local new_tat = tat + interval
+local diff = now - (new_tat - interval * burst)
+if diff < 0 then
+	return {0, 0, -diff, tat - now}
+end
+
+local reset_after = new_tat - now
+redis.call('SET', KEYS[1], string.format('%.0f', new_tat), 'PX', math.ceil(reset_after / 1000))
+return {1, math.floor(diff / interval), 0, reset_after}
+`)
+
+// RedisBackend keeps buckets in Redis so every instance shares the same limits.
+type RedisBackend struct {
+	client redis.UniversalClient
+}
+
+// NewRedisBackend creates a backend using an existing Redis client.
+func NewRedisBackend(client redis.UniversalClient) *RedisBackend {
+	return &RedisBackend{client: client}
+}
+
+// DialRedis creates a backend for the Redis server at a redis:// or rediss:// URL. The server
+// does not have to be reachable yet; requests fail until it is.
+func DialRedis(url string) (*RedisBackend, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	return NewRedisBackend(redis.NewClient(options)), nil
+}
+
+// Allow takes one request from the bucket for key.
+func (b *RedisBackend) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.Burst <= 0 {
+		return Result{}, nil
+	}
+	interval := math.Max(1, math.Round(1e6/limit.Rate))
+	values, err := gcraScript.Run(ctx, b.client, []string{redisKeyPrefix + key}, interval, limit.Burst).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("rate limit check for %s failed: %w", key, err)
+	}
+	if len(values) != 4 {
+		return Result{}, fmt.Errorf("rate limit check for %s returned %d values", key, len(values))
+	}
+	return Result{
+		Allowed:    values[0] == 1,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Microsecond,
+		ResetAfter: time.Duration(values[3]) * time.Microsecond,
+	}, nil
+}
+
+// Close closes the Redis client.
+func (b *RedisBackend) Close() error {
+	return b.client.Close()
+}
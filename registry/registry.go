@@ -0,0 +1,443 @@
+package registry
+
+import (
+	"blockhouse/models"
+	"blockhouse/store"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrStreamNotFound is returned when a stream is unknown or has been deleted.
+	ErrStreamNotFound = errors.New("stream not found")
+	// ErrStreamExists is returned when registering a stream ID that is already taken.
+	ErrStreamExists = errors.New("stream already exists")
+	// ErrStreamNotPending is returned when restoring a stream that is not pending deletion.
+	ErrStreamNotPending = errors.New("stream is not pending deletion")
+	// ErrGrantNotFound is returned when removing a grant a stream does not have.
+	ErrGrantNotFound = errors.New("grant not found")
+)
+
+// Registry records the streams created through the API and their lifecycle status.
+// Records are persisted through the metadata store so they survive restarts.
+type Registry struct {
+	mu    sync.Mutex // Serializes read-modify-write cycles against the store
+	store store.Store
+
+	// Activity and bytes written are tracked in memory so hot paths like SendData
+	// do not write to the store; FlushActivity persists them periodically.
+	activityMu sync.Mutex
+	activity   map[string]time.Time
+	written    map[string]int64
+}
+
+// Expiry describes a stream that has outlived its TTL or idle timeout.
+type Expiry struct {
+	Stream models.Stream
+	Reason string // "ttl" or "idle"
+}
+
+// TenantUsage summarises the streams a tenant currently holds.
+type TenantUsage struct {
+	Streams      int   `json:"streams"`       // Streams that have not been deleted, including those pending deletion
+	WrittenBytes int64 `json:"written_bytes"` // Bytes written to those streams
+}
+
+// New creates a stream registry backed by the given store.
+func New(s store.Store) *Registry {
+	return &Registry{store: s, activity: make(map[string]time.Time), written: make(map[string]int64)}
+}
+
+var (
+	defaultOnce     sync.Once
+	defaultRegistry *Registry
+)
+
+// Default returns the process-wide registry shared by the API handlers.
+func Default() *Registry {
+	defaultOnce.Do(func() {
+		defaultRegistry = New(store.Default())
+	})
+	return defaultRegistry
+}
+
+// load reads a stream record regardless of its status, with unflushed activity applied.
+func (r *Registry) load(id string) (*models.Stream, error) {
+	stream, err := r.loadStored(id)
+	if err != nil {
+		return nil, err
+	}
+	r.applyActivity(stream)
+	return stream, nil
+}
+
+// loadStored reads a stream record as it is persisted, without unflushed activity.
+func (r *Registry) loadStored(id string) (*models.Stream, error) {
+	var stream models.Stream
+	if err := store.GetJSON(r.store, store.StreamsBucket, id, &stream); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, ErrStreamNotFound
+		}
+		return nil, err
+	}
+	return &stream, nil
+}
+
+// applyActivity overlays unflushed in-memory activity and bytes written onto a stream record.
+func (r *Registry) applyActivity(stream *models.Stream) {
+	r.activityMu.Lock()
+	defer r.activityMu.Unlock()
+
+	if last, ok := r.activity[stream.ID]; ok && (stream.LastActive == nil || last.After(*stream.LastActive)) {
+		stream.LastActive = &last
+	}
+	stream.WrittenBytes += r.written[stream.ID]
+}
+
+// Register adds a new stream to the registry.
+func (r *Registry) Register(stream models.Stream) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.load(stream.ID); err == nil {
+		return ErrStreamExists
+	} else if !errors.Is(err, ErrStreamNotFound) {
+		return err
+	}
+	if stream.Status == "" {
+		stream.Status = models.StreamStatusActive
+	}
+	return store.PutJSON(r.store, store.StreamsBucket, stream.ID, stream)
+}
+
+// Get returns the stream with the given ID, or ErrStreamNotFound if it is unknown or deleted.
+// Streams pending deletion are returned; callers check Status before accepting traffic.
+func (r *Registry) Get(id string) (models.Stream, error) {
+	stream, err := r.load(id)
+	if err != nil {
+		return models.Stream{}, err
+	}
+	if stream.Status == models.StreamStatusDeleted {
+		return models.Stream{}, ErrStreamNotFound
+	}
+	return *stream, nil
+}
+
+// all returns every stream record, including deleted ones.
+func (r *Registry) all() ([]models.Stream, error) {
+	var streams []models.Stream
+	err := r.store.ForEach(store.StreamsBucket, func(key string, value []byte) error {
+		var stream models.Stream
+		if err := json.Unmarshal(value, &stream); err != nil {
+			return fmt.Errorf("failed to unmarshal stream record %s: %w", key, err)
+		}
+		r.applyActivity(&stream)
+		streams = append(streams, stream)
+		return nil
+	})
+	return streams, err
+}
+
+// List returns all streams that have not been deleted, oldest first.
+// Streams pending deletion are included so they can still be inspected and restored.
+func (r *Registry) List() ([]models.Stream, error) {
+	records, err := r.all()
+	if err != nil {
+		return nil, err
+	}
+	streams := []models.Stream{}
+	for _, stream := range records {
+		if stream.Status != models.StreamStatusDeleted {
+			streams = append(streams, stream)
+		}
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].CreatedAt.Before(streams[j].CreatedAt)
+	})
+	return streams, nil
+}
+
+// TenantUsage counts the streams a tenant holds and the bytes written to them. Streams
+// created before tenants existed belong to the default tenant.
+func (r *Registry) TenantUsage(tenant string) (TenantUsage, error) {
+	records, err := r.all()
+	if err != nil {
+		return TenantUsage{}, err
+	}
+	var usage TenantUsage
+	for _, stream := range records {
+		if stream.Status == models.StreamStatusDeleted || models.TenantOf(stream.Tenant) != models.TenantOf(tenant) {
+			continue
+		}
+		usage.Streams++
+		usage.WrittenBytes += stream.WrittenBytes
+	}
+	return usage, nil
+}
+
+// DueForDeletion returns the streams whose soft-delete grace period has ended by now.
+func (r *Registry) DueForDeletion(now time.Time) ([]models.Stream, error) {
+	records, err := r.all()
+	if err != nil {
+		return nil, err
+	}
+	var due []models.Stream
+	for _, stream := range records {
+		if stream.Status == models.StreamStatusPendingDeletion && stream.DeleteAfter != nil && !stream.DeleteAfter.After(now) {
+			due = append(due, stream)
+		}
+	}
+	return due, nil
+}
+
+// Expired returns the active streams whose TTL has run out, or that have gone longer than
+// their idle timeout without activity. Streams for which busy reports true (for example,
+// because subscribers are attached) are never considered idle.
+func (r *Registry) Expired(now time.Time, busy func(streamID string) bool) ([]Expiry, error) {
+	records, err := r.all()
+	if err != nil {
+		return nil, err
+	}
+	var expired []Expiry
+	for _, stream := range records {
+		if stream.Status != models.StreamStatusActive {
+			continue
+		}
+		if stream.ExpiresAt != nil && !stream.ExpiresAt.After(now) {
+			expired = append(expired, Expiry{Stream: stream, Reason: "ttl"})
+			continue
+		}
+		if stream.Config.IdleTimeout == "" || busy(stream.ID) {
+			continue
+		}
+		idleTimeout, err := time.ParseDuration(stream.Config.IdleTimeout)
+		if err != nil || idleTimeout <= 0 {
+			continue
+		}
+		lastActive := stream.CreatedAt
+		if stream.LastActive != nil {
+			lastActive = *stream.LastActive
+		}
+		if now.Sub(lastActive) >= idleTimeout {
+			expired = append(expired, Expiry{Stream: stream, Reason: "idle"})
+		}
+	}
+	return expired, nil
+}
+
+// Touch records activity on a stream, postponing its idle expiry.
+func (r *Registry) Touch(id string) {
+	r.activityMu.Lock()
+	defer r.activityMu.Unlock()
+
+	r.activity[id] = time.Now().UTC()
+}
+
+// AddBytes records n bytes written to a stream's topic.
+func (r *Registry) AddBytes(id string, n int64) {
+	r.activityMu.Lock()
+	defer r.activityMu.Unlock()
+
+	r.written[id] += n
+}
+
+// FlushActivity persists activity recorded by Touch and bytes recorded by AddBytes to the store.
+func (r *Registry) FlushActivity() error {
+	r.activityMu.Lock()
+	pending := r.activity
+	written := r.written
+	r.activity = make(map[string]time.Time)
+	r.written = make(map[string]int64)
+	r.activityMu.Unlock()
+
+	var firstErr error
+	flush := func(id string, fn func(stream *models.Stream) error, retry func()) {
+		r.mu.Lock()
+		_, err := r.persist(id, fn)
+		r.mu.Unlock()
+		if err != nil && !errors.Is(err, ErrStreamNotFound) {
+			// Keep what was recorded so the next flush retries it
+			r.activityMu.Lock()
+			retry()
+			r.activityMu.Unlock()
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	for id, last := range pending {
+		id, last := id, last
+		flush(id, func(stream *models.Stream) error {
+			stream.LastActive = &last
+			return nil
+		}, func() {
+			if newer, ok := r.activity[id]; !ok || newer.Before(last) {
+				r.activity[id] = last
+			}
+		})
+	}
+	for id, n := range written {
+		id, n := id, n
+		flush(id, func(stream *models.Stream) error {
+			stream.WrittenBytes += n
+			return nil
+		}, func() {
+			r.written[id] += n
+		})
+	}
+	return firstErr
+}
+
+// update applies fn to a stream that has not been deleted and persists the result. The
+// returned stream has unflushed activity applied.
+func (r *Registry) update(id string, fn func(stream *models.Stream) error) (models.Stream, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stream, err := r.persist(id, fn)
+	if err != nil {
+		return models.Stream{}, err
+	}
+	r.applyActivity(&stream)
+	return stream, nil
+}
+
+// persist applies fn to the stored record of a stream that has not been deleted and writes
+// it back. The caller must hold r.mu.
+func (r *Registry) persist(id string, fn func(stream *models.Stream) error) (models.Stream, error) {
+	stream, err := r.loadStored(id)
+	if err != nil {
+		return models.Stream{}, err
+	}
+	if stream.Status == models.StreamStatusDeleted {
+		return models.Stream{}, ErrStreamNotFound
+	}
+	if err := fn(stream); err != nil {
+		return models.Stream{}, err
+	}
+	if err := store.PutJSON(r.store, store.StreamsBucket, id, stream); err != nil {
+		return models.Stream{}, err
+	}
+	return *stream, nil
+}
+
+// RevokeTokens advances a stream's token generation so every stream token issued
+// so far is rejected. The updated stream is returned.
+func (r *Registry) RevokeTokens(id string) (models.Stream, error) {
+	return r.update(id, func(stream *models.Stream) error {
+		stream.TokenGeneration++
+		return nil
+	})
+}
+
+// AddGrant gives a principal a role on a stream. Granting a role the principal already
+// holds is a no-op. The updated stream is returned.
+func (r *Registry) AddGrant(id string, grant models.Grant) (models.Stream, error) {
+	return r.update(id, func(stream *models.Stream) error {
+		for _, existing := range stream.ACL {
+			if existing.Principal == grant.Principal && existing.Role == grant.Role {
+				return nil
+			}
+		}
+		stream.ACL = append(stream.ACL, grant)
+		return nil
+	})
+}
+
+// RemoveGrants takes a principal's roles on a stream away: just role if it is set, otherwise
+// all of them. It returns ErrGrantNotFound if nothing matched.
+func (r *Registry) RemoveGrants(id, principal, role string) (models.Stream, error) {
+	return r.update(id, func(stream *models.Stream) error {
+		kept := stream.ACL[:0]
+		for _, grant := range stream.ACL {
+			if grant.Principal != principal || (role != "" && grant.Role != role) {
+				kept = append(kept, grant)
+			}
+		}
+		if len(kept) == len(stream.ACL) {
+			return ErrGrantNotFound
+		}
+		stream.ACL = kept
+		return nil
+	})
+}
+
+// ScheduleDeletion soft-deletes a stream: it stops accepting traffic but can be
+// restored until deleteAfter, when it becomes due for permanent deletion.
+func (r *Registry) ScheduleDeletion(id string, deleteAfter time.Time) (models.Stream, error) {
+	return r.update(id, func(stream *models.Stream) error {
+		deleteAfter = deleteAfter.UTC()
+		stream.Status = models.StreamStatusPendingDeletion
+		stream.DeleteAfter = &deleteAfter
+		return nil
+	})
+}
+
+// Restore reactivates a stream that is pending deletion.
+func (r *Registry) Restore(id string) (models.Stream, error) {
+	return r.update(id, func(stream *models.Stream) error {
+		if stream.Status != models.StreamStatusPendingDeletion {
+			return ErrStreamNotPending
+		}
+		stream.Status = models.StreamStatusActive
+		stream.DeleteAfter = nil
+		return nil
+	})
+}
+
+// Unregister removes a stream's record outright, without leaving a tombstone, for a stream
+// whose creation could not be completed.
+func (r *Registry) Unregister(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.activityMu.Lock()
+	delete(r.activity, id)
+	delete(r.written, id)
+	r.activityMu.Unlock()
+	return r.store.Delete(store.StreamsBucket, id)
+}
+
+// Delete marks a stream as permanently deleted. The record is kept as a tombstone until
+// PurgeTombstones removes it.
+func (r *Registry) Delete(id string) error {
+	_, err := r.update(id, func(stream *models.Stream) error {
+		now := time.Now().UTC()
+		stream.Status = models.StreamStatusDeleted
+		stream.DeletedAt = &now
+		stream.DeleteAfter = nil
+		return nil
+	})
+	return err
+}
+
+// PurgeTombstones removes the records of streams deleted before cutoff, so that listings and
+// janitor passes stop scanning them. It returns how many were removed.
+func (r *Registry) PurgeTombstones(cutoff time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records, err := r.all()
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, stream := range records {
+		if stream.Status != models.StreamStatusDeleted || (stream.DeletedAt != nil && stream.DeletedAt.After(cutoff)) {
+			continue
+		}
+		if err := r.store.Delete(store.StreamsBucket, stream.ID); err != nil {
+			return purged, err
+		}
+		r.activityMu.Lock()
+		delete(r.activity, stream.ID)
+		delete(r.written, stream.ID)
+		r.activityMu.Unlock()
+		purged++
+	}
+	return purged, nil
+}
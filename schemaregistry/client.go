@@ -0,0 +1,176 @@
+package schemaregistry
+
+import (
+	"blockhouse/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schema types understood by the registry.
+const (
+	TypeAvro     = "AVRO"
+	TypeProtobuf = "PROTOBUF"
+)
+
+var (
+	// ErrRegistryUnavailable is returned when the schema registry cannot be reached or fails.
+	ErrRegistryUnavailable = errors.New("schema registry unavailable")
+	// ErrSchemaRejected is returned when the registry refuses a schema, e.g. as invalid or incompatible.
+	ErrSchemaRejected = errors.New("schema rejected by registry")
+)
+
+// Schema is a schema document as stored in the registry.
+type Schema struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType,omitempty"` // Empty means AVRO
+}
+
+// Type returns the schema type, applying the registry's AVRO default.
+func (s Schema) Type() string {
+	if s.SchemaType == "" {
+		return TypeAvro
+	}
+	return s.SchemaType
+}
+
+// Client talks to a Confluent-compatible schema registry over its REST API.
+// Schemas and codecs are cached by ID, since registered IDs are immutable.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	username   string
+	password   string
+
+	mu      sync.Mutex
+	schemas map[int]Schema
+	codecs  map[int]codec
+}
+
+// NewClient creates a client for the registry at baseURL.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		schemas:    make(map[int]Schema),
+		codecs:     make(map[int]codec),
+	}
+}
+
+// SetBasicAuth configures HTTP basic authentication for registry requests.
+func (c *Client) SetBasicAuth(username, password string) {
+	c.username = username
+	c.password = password
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultClient *Client
+)
+
+// Default returns the client configured through SCHEMA_REGISTRY_URL, or nil if no
+// registry is configured.
+func Default() *Client {
+	defaultOnce.Do(func() {
+		baseURL := config.GetEnvDefault("SCHEMA_REGISTRY_URL", "")
+		if baseURL == "" {
+			return
+		}
+		defaultClient = NewClient(baseURL)
+		if username := config.GetEnvDefault("SCHEMA_REGISTRY_USERNAME", ""); username != "" {
+			defaultClient.SetBasicAuth(username, config.GetEnvDefault("SCHEMA_REGISTRY_PASSWORD", ""))
+		}
+	})
+	return defaultClient
+}
+
+// Register registers schema under subject and returns its global schema ID.
+// Registering an identical schema again returns the existing ID.
+func (c *Client) Register(ctx context.Context, subject string, schema Schema) (int, error) {
+	body, err := json.Marshal(schema)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+
+	var response struct {
+		ID int `json:"id"`
+	}
+	path := "/subjects/" + url.PathEscape(subject) + "/versions"
+	if err := c.do(ctx, http.MethodPost, path, body, &response); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	c.schemas[response.ID] = schema
+	c.mu.Unlock()
+	return response.ID, nil
+}
+
+// SchemaByID fetches the schema with the given global ID.
+func (c *Client) SchemaByID(ctx context.Context, id int) (Schema, error) {
+	c.mu.Lock()
+	schema, ok := c.schemas[id]
+	c.mu.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, &schema); err != nil {
+		return Schema{}, err
+	}
+
+	c.mu.Lock()
+	c.schemas[id] = schema
+	c.mu.Unlock()
+	return schema, nil
+}
+
+// do sends a request to the registry and decodes the JSON response into out.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to build schema registry request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRegistryUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var registryErr struct {
+			ErrorCode int    `json:"error_code"`
+			Message   string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&registryErr)
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("%w: %s %s returned %d: %s", ErrRegistryUnavailable, method, path, resp.StatusCode, registryErr.Message)
+		}
+		return fmt.Errorf("%w: %s %s returned %d: %s", ErrSchemaRejected, method, path, resp.StatusCode, registryErr.Message)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode schema registry response: %w", err)
+	}
+	return nil
+}
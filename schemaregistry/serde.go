@@ -0,0 +1,234 @@
+package schemaregistry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// codec converts between JSON documents and a schema's binary encoding.
+type codec interface {
+	// encode returns the binary payload that follows the schema ID in the wire format.
+	encode(messageType string, jsonValue []byte) ([]byte, error)
+	// decode converts a payload that followed the schema ID back to JSON.
+	decode(payload []byte) ([]byte, error)
+}
+
+// newCodec builds a codec for a registry schema.
+func newCodec(schema Schema) (codec, error) {
+	switch schema.Type() {
+	case TypeAvro:
+		// The standard JSON codec accepts plain JSON for unions instead of Avro's {"type": value} form
+		avroCodec, err := goavro.NewCodecForStandardJSONFull(schema.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Avro schema: %w", err)
+		}
+		return &avroSerde{codec: avroCodec}, nil
+	case TypeProtobuf:
+		file, err := compileProto(schema.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Protobuf schema: %w", err)
+		}
+		return &protobufSerde{file: file}, nil
+	default:
+		return nil, fmt.Errorf("unsupported schema type %q", schema.Type())
+	}
+}
+
+// CheckSchema verifies locally that schema compiles and, for Protobuf, that it
+// defines messageType (or at least one message when messageType is empty).
+func CheckSchema(schema Schema, messageType string) error {
+	c, err := newCodec(schema)
+	if err != nil {
+		return err
+	}
+	if pb, ok := c.(*protobufSerde); ok {
+		if _, _, err := pb.message(messageType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// codecFor returns the cached codec for a schema ID, fetching the schema if needed.
+func (c *Client) codecFor(ctx context.Context, schemaID int) (codec, error) {
+	c.mu.Lock()
+	cached, ok := c.codecs[schemaID]
+	c.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	schema, err := c.SchemaByID(ctx, schemaID)
+	if err != nil {
+		return nil, err
+	}
+	built, err := newCodec(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.codecs[schemaID] = built
+	c.mu.Unlock()
+	return built, nil
+}
+
+// Serialize encodes a JSON document with the schema registered as schemaID and frames
+// it in the Confluent wire format. messageType selects the Protobuf message and is
+// ignored for Avro.
+func (c *Client) Serialize(ctx context.Context, schemaID int, messageType string, jsonValue []byte) ([]byte, error) {
+	codec, err := c.codecFor(ctx, schemaID)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := codec.encode(messageType, jsonValue)
+	if err != nil {
+		return nil, err
+	}
+	return frame(schemaID, payload), nil
+}
+
+// Deserialize decodes a wire format value back to a JSON document.
+func (c *Client) Deserialize(ctx context.Context, value []byte) ([]byte, error) {
+	schemaID, payload, err := unframe(value)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := c.codecFor(ctx, schemaID)
+	if err != nil {
+		return nil, err
+	}
+	return codec.decode(payload)
+}
+
+// avroSerde encodes JSON documents with an Avro schema.
+type avroSerde struct {
+	codec *goavro.Codec
+}
+
+func (a *avroSerde) encode(_ string, jsonValue []byte) ([]byte, error) {
+	native, _, err := a.codec.NativeFromTextual(jsonValue)
+	if err != nil {
+		return nil, fmt.Errorf("payload does not match Avro schema: %w", err)
+	}
+	return a.codec.BinaryFromNative(nil, native)
+}
+
+func (a *avroSerde) decode(payload []byte) ([]byte, error) {
+	native, _, err := a.codec.NativeFromBinary(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Avro payload: %w", err)
+	}
+	return a.codec.TextualFromNative(nil, native)
+}
+
+// protobufSerde encodes JSON documents with a message from a .proto schema.
+type protobufSerde struct {
+	file protoreflect.FileDescriptor
+}
+
+// compileProto compiles a single .proto source. Only the well-known google/protobuf imports are available.
+func compileProto(source string) (protoreflect.FileDescriptor, error) {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{"schema.proto": source}),
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), "schema.proto")
+	if err != nil {
+		return nil, err
+	}
+	return files[0], nil
+}
+
+// message finds a message by name and returns it with its index path. Names may be
+// fully qualified or relative to the file's package; an empty name selects the first message.
+func (p *protobufSerde) message(name string) (protoreflect.MessageDescriptor, []int, error) {
+	if name == "" {
+		if p.file.Messages().Len() == 0 {
+			return nil, nil, fmt.Errorf("schema defines no messages")
+		}
+		return p.file.Messages().Get(0), []int{0}, nil
+	}
+
+	fullName := name
+	if pkg := string(p.file.Package()); pkg != "" && !strings.HasPrefix(name, pkg+".") {
+		fullName = pkg + "." + name
+	}
+	if md, indexes := findMessage(p.file.Messages(), protoreflect.FullName(fullName), nil); md != nil {
+		return md, indexes, nil
+	}
+	return nil, nil, fmt.Errorf("message %q not found in schema", name)
+}
+
+// findMessage searches messages and their nested messages depth-first for name.
+func findMessage(messages protoreflect.MessageDescriptors, name protoreflect.FullName, path []int) (protoreflect.MessageDescriptor, []int) {
+	for i := 0; i < messages.Len(); i++ {
+		md := messages.Get(i)
+		indexes := append(append([]int(nil), path...), i)
+		if md.FullName() == name {
+			return md, indexes
+		}
+		if found, foundIndexes := findMessage(md.Messages(), name, indexes); found != nil {
+			return found, foundIndexes
+		}
+	}
+	return nil, nil
+}
+
+// messageAt resolves a message index path to its descriptor.
+func (p *protobufSerde) messageAt(indexes []int) (protoreflect.MessageDescriptor, error) {
+	messages := p.file.Messages()
+	var md protoreflect.MessageDescriptor
+	for _, index := range indexes {
+		if index >= messages.Len() {
+			return nil, fmt.Errorf("message index %v out of range", indexes)
+		}
+		md = messages.Get(index)
+		messages = md.Messages()
+	}
+	if md == nil {
+		return nil, fmt.Errorf("empty message index path")
+	}
+	return md, nil
+}
+
+func (p *protobufSerde) encode(messageType string, jsonValue []byte) ([]byte, error) {
+	md, indexes, err := p.message(messageType)
+	if err != nil {
+		return nil, err
+	}
+	msg := dynamicpb.NewMessage(md)
+	if err := protojson.Unmarshal(jsonValue, msg); err != nil {
+		return nil, fmt.Errorf("payload does not match Protobuf message %s: %w", md.FullName(), err)
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode Protobuf message %s: %w", md.FullName(), err)
+	}
+	return append(appendMessageIndexes(nil, indexes), data...), nil
+}
+
+func (p *protobufSerde) decode(payload []byte) ([]byte, error) {
+	indexes, data, err := readMessageIndexes(payload)
+	if err != nil {
+		return nil, err
+	}
+	md, err := p.messageAt(indexes)
+	if err != nil {
+		return nil, err
+	}
+	msg := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("failed to decode Protobuf message %s: %w", md.FullName(), err)
+	}
+	return protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+}
@@ -0,0 +1,72 @@
+package schemaregistry
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// magicByte prefixes every message in the Confluent wire format.
+const magicByte = 0x00
+
+// ErrNotWireFormat is returned when decoding a value that lacks the Confluent framing.
+var ErrNotWireFormat = errors.New("value is not in schema registry wire format")
+
+// IsWireFormat reports whether value starts with the Confluent wire format header.
+// JSON documents never start with a zero byte, so this distinguishes encoded values
+// from plain JSON.
+func IsWireFormat(value []byte) bool {
+	return len(value) >= 5 && value[0] == magicByte
+}
+
+// frame prepends the magic byte and big-endian schema ID to payload.
+func frame(schemaID int, payload []byte) []byte {
+	out := make([]byte, 5, 5+len(payload))
+	out[0] = magicByte
+	binary.BigEndian.PutUint32(out[1:], uint32(schemaID))
+	return append(out, payload...)
+}
+
+// unframe splits a wire format value into its schema ID and payload.
+func unframe(value []byte) (int, []byte, error) {
+	if !IsWireFormat(value) {
+		return 0, nil, ErrNotWireFormat
+	}
+	return int(binary.BigEndian.Uint32(value[1:5])), value[5:], nil
+}
+
+// appendMessageIndexes encodes the Protobuf message index path that follows the schema ID.
+// The common case of the first top-level message is encoded as a single zero byte.
+func appendMessageIndexes(out []byte, indexes []int) []byte {
+	if len(indexes) == 1 && indexes[0] == 0 {
+		return append(out, 0)
+	}
+	out = binary.AppendVarint(out, int64(len(indexes)))
+	for _, index := range indexes {
+		out = binary.AppendVarint(out, int64(index))
+	}
+	return out
+}
+
+// readMessageIndexes decodes a Protobuf message index path and returns the remaining payload.
+func readMessageIndexes(payload []byte) ([]int, []byte, error) {
+	count, n := binary.Varint(payload)
+	if n <= 0 || count < 0 || count > int64(len(payload)) {
+		return nil, nil, fmt.Errorf("invalid message index count")
+	}
+	payload = payload[n:]
+	if count == 0 {
+		return []int{0}, payload, nil
+	}
+
+	indexes := make([]int, 0, count)
+	for i := int64(0); i < count; i++ {
+		index, n := binary.Varint(payload)
+		if n <= 0 || index < 0 {
+			return nil, nil, fmt.Errorf("invalid message index")
+		}
+		indexes = append(indexes, int(index))
+		payload = payload[n:]
+	}
+	return indexes, payload, nil
+}
@@ -0,0 +1,153 @@
+package schemas
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Compatibility modes checked when a new schema version is registered.
+const (
+	CompatibilityNone     = "none"     // Any new version is accepted
+	CompatibilityBackward = "backward" // Consumers on the new version can read data written with the previous one
+	CompatibilityForward  = "forward"  // Consumers on the previous version can read data written with the new one
+	CompatibilityFull     = "full"     // Both backward and forward
+)
+
+// ValidCompatibility reports whether mode is a supported compatibility mode.
+func ValidCompatibility(mode string) bool {
+	switch mode {
+	case CompatibilityNone, CompatibilityBackward, CompatibilityForward, CompatibilityFull:
+		return true
+	}
+	return false
+}
+
+// CheckCompatibility compares two schema versions under the given mode and returns a
+// description of each incompatibility found. The check is structural: it covers type,
+// required, properties, additionalProperties, items and enum.
+func CheckCompatibility(previous, next json.RawMessage, mode string) ([]string, error) {
+	var prevNode, nextNode map[string]interface{}
+	if err := json.Unmarshal(previous, &prevNode); err != nil {
+		return nil, fmt.Errorf("failed to parse previous schema: %w", err)
+	}
+	if err := json.Unmarshal(next, &nextNode); err != nil {
+		return nil, fmt.Errorf("failed to parse new schema: %w", err)
+	}
+
+	var problems []string
+	if mode == CompatibilityBackward || mode == CompatibilityFull {
+		problems = append(problems, readable(prevNode, nextNode, "", "backward")...)
+	}
+	if mode == CompatibilityForward || mode == CompatibilityFull {
+		problems = append(problems, readable(nextNode, prevNode, "", "forward")...)
+	}
+	return problems, nil
+}
+
+// readable reports the ways in which data valid under writer could be rejected by reader.
+func readable(writer, reader map[string]interface{}, path, direction string) []string {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		location := path
+		if location == "" {
+			location = "/"
+		}
+		problems = append(problems, fmt.Sprintf("%s: %s: %s", direction, location, fmt.Sprintf(format, args...)))
+	}
+
+	// Types accepted by the reader must cover everything the writer can produce
+	if readerTypes := stringSet(reader["type"]); len(readerTypes) > 0 {
+		writerTypes := stringSet(writer["type"])
+		if len(writerTypes) == 0 {
+			report("type constrained to %v", sortedKeys(readerTypes))
+		}
+		for t := range writerTypes {
+			if !readerTypes[t] && !(t == "integer" && readerTypes["number"]) {
+				report("type %q no longer accepted", t)
+			}
+		}
+	}
+
+	// Fields required by the reader must always be present in written data
+	writerRequired := stringSet(writer["required"])
+	for field := range stringSet(reader["required"]) {
+		if !writerRequired[field] {
+			report("field %q is required but may be missing", field)
+		}
+	}
+
+	// Enumerations may only grow in the reader's favour
+	if readerEnum, ok := reader["enum"].([]interface{}); ok {
+		writerEnum, ok := writer["enum"].([]interface{})
+		if !ok {
+			report("values restricted to an enum")
+		}
+		for _, value := range writerEnum {
+			if !containsValue(readerEnum, value) {
+				report("enum value %v no longer accepted", value)
+			}
+		}
+	}
+
+	// Properties present in both versions are compared recursively
+	writerProps, _ := writer["properties"].(map[string]interface{})
+	readerProps, _ := reader["properties"].(map[string]interface{})
+	for _, name := range sortedKeys(writerProps) {
+		readerProp, ok := readerProps[name].(map[string]interface{})
+		if !ok {
+			if allowed, isBool := reader["additionalProperties"].(bool); isBool && !allowed {
+				report("property %q is not allowed", name)
+			}
+			continue
+		}
+		if writerProp, ok := writerProps[name].(map[string]interface{}); ok {
+			problems = append(problems, readable(writerProp, readerProp, path+"/"+name, direction)...)
+		}
+	}
+
+	if writerItems, ok := writer["items"].(map[string]interface{}); ok {
+		if readerItems, ok := reader["items"].(map[string]interface{}); ok {
+			problems = append(problems, readable(writerItems, readerItems, path+"/items", direction)...)
+		}
+	}
+	return problems
+}
+
+// stringSet converts a JSON string or array of strings into a set.
+func stringSet(v interface{}) map[string]bool {
+	set := make(map[string]bool)
+	switch value := v.(type) {
+	case string:
+		set[value] = true
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				set[s] = true
+			}
+		}
+	}
+	return set
+}
+
+// sortedKeys returns the keys of a map in sorted order for deterministic output.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// containsValue reports whether values contains v, comparing JSON encodings.
+func containsValue(values []interface{}, v interface{}) bool {
+	want, _ := json.Marshal(v)
+	for _, candidate := range values {
+		got, _ := json.Marshal(candidate)
+		if string(got) == string(want) {
+			return true
+		}
+	}
+	return false
+}
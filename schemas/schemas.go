@@ -0,0 +1,206 @@
+package schemas
+
+import (
+	"blockhouse/models"
+	"blockhouse/store"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+var (
+	// ErrNoSchema is returned when a stream has no schema registered.
+	ErrNoSchema = errors.New("no schema registered for stream")
+	// ErrIncompatible is returned when a new schema version breaks the stream's compatibility mode.
+	ErrIncompatible = errors.New("schema is incompatible with the previous version")
+	// ErrInvalidSchema is returned when a schema document cannot be compiled.
+	ErrInvalidSchema = errors.New("invalid JSON schema")
+	// ErrInvalidCompatibility is returned for an unsupported compatibility mode.
+	ErrInvalidCompatibility = errors.New("unsupported compatibility mode")
+)
+
+// Violation describes one way a payload fails its stream's schema.
+type Violation struct {
+	Path    string `json:"path"`    // JSON pointer to the offending value
+	Message string `json:"message"` // Why the value was rejected
+}
+
+// Registry stores versioned JSON Schemas per stream and validates payloads against the latest one.
+type Registry struct {
+	mu       sync.Mutex
+	store    store.Store
+	compiled map[string]*jsonschema.Schema // Latest compiled schema by stream ID; nil when the stream has none
+}
+
+// New creates a schema registry backed by the given store.
+func New(s store.Store) *Registry {
+	return &Registry{store: s, compiled: make(map[string]*jsonschema.Schema)}
+}
+
+var (
+	defaultOnce     sync.Once
+	defaultRegistry *Registry
+)
+
+// Default returns the process-wide schema registry shared by the API handlers.
+func Default() *Registry {
+	defaultOnce.Do(func() {
+		defaultRegistry = New(store.Default())
+	})
+	return defaultRegistry
+}
+
+// Compile parses and compiles a JSON Schema document. Remote references are not resolved.
+func Compile(raw json.RawMessage) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external schema reference %s is not allowed", url)
+	}
+	if err := compiler.AddResource("stream.json", bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	schema, err := compiler.Compile("stream.json")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	return schema, nil
+}
+
+// Get returns every schema version registered for a stream.
+func (r *Registry) Get(streamID string) (models.SchemaSet, error) {
+	var set models.SchemaSet
+	if err := store.GetJSON(r.store, store.SchemasBucket, streamID, &set); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return set, ErrNoSchema
+		}
+		return set, err
+	}
+	return set, nil
+}
+
+// Register adds a new schema version for a stream. An empty compatibility keeps the
+// stream's current mode, defaulting to backward. When the new version is incompatible
+// with the previous one the returned error wraps ErrIncompatible and the problems are listed.
+func (r *Registry) Register(streamID string, raw json.RawMessage, compatibility string) (models.SchemaVersion, []string, error) {
+	if compatibility != "" && !ValidCompatibility(compatibility) {
+		return models.SchemaVersion{}, nil, fmt.Errorf("%w %q", ErrInvalidCompatibility, compatibility)
+	}
+	compiled, err := Compile(raw)
+	if err != nil {
+		return models.SchemaVersion{}, nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	set, err := r.Get(streamID)
+	if err != nil && !errors.Is(err, ErrNoSchema) {
+		return models.SchemaVersion{}, nil, err
+	}
+	set.StreamID = streamID
+	if compatibility != "" {
+		set.Compatibility = compatibility
+	} else if set.Compatibility == "" {
+		set.Compatibility = CompatibilityBackward
+	}
+
+	if len(set.Versions) > 0 && set.Compatibility != CompatibilityNone {
+		problems, err := CheckCompatibility(set.Latest().Schema, raw, set.Compatibility)
+		if err != nil {
+			return models.SchemaVersion{}, nil, err
+		}
+		if len(problems) > 0 {
+			return models.SchemaVersion{}, problems, ErrIncompatible
+		}
+	}
+
+	version := models.SchemaVersion{
+		Version:   len(set.Versions) + 1,
+		Schema:    raw,
+		CreatedAt: time.Now().UTC(),
+	}
+	set.Versions = append(set.Versions, version)
+	if err := store.PutJSON(r.store, store.SchemasBucket, streamID, set); err != nil {
+		return models.SchemaVersion{}, nil, err
+	}
+	r.compiled[streamID] = compiled
+	return version, nil, nil
+}
+
+// Delete removes every schema version registered for a stream.
+func (r *Registry) Delete(streamID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.compiled, streamID)
+	return r.store.Delete(store.SchemasBucket, streamID)
+}
+
+// latest returns the compiled latest schema for a stream, or nil if it has none.
+func (r *Registry) latest(streamID string) (*jsonschema.Schema, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if compiled, ok := r.compiled[streamID]; ok {
+		return compiled, nil
+	}
+	set, err := r.Get(streamID)
+	if errors.Is(err, ErrNoSchema) {
+		r.compiled[streamID] = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := Compile(set.Latest().Schema)
+	if err != nil {
+		return nil, err
+	}
+	r.compiled[streamID] = compiled
+	return compiled, nil
+}
+
+// Validate checks a decoded JSON payload against the stream's latest schema and returns
+// any violations. Streams without a schema accept every payload.
+func (r *Registry) Validate(streamID string, payload interface{}) ([]Violation, error) {
+	compiled, err := r.latest(streamID)
+	if err != nil || compiled == nil {
+		return nil, err
+	}
+
+	err = compiled.Validate(payload)
+	if err == nil {
+		return nil, nil
+	}
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return nil, err
+	}
+	violations := collectViolations(validationErr, nil)
+	sort.Slice(violations, func(i, j int) bool {
+		return violations[i].Path < violations[j].Path
+	})
+	return violations, nil
+}
+
+// collectViolations flattens a validation error tree into its leaf causes.
+func collectViolations(err *jsonschema.ValidationError, violations []Violation) []Violation {
+	if len(err.Causes) == 0 {
+		path := err.InstanceLocation
+		if path == "" {
+			path = "/"
+		}
+		return append(violations, Violation{Path: path, Message: err.Message})
+	}
+	for _, cause := range err.Causes {
+		violations = collectViolations(cause, violations)
+	}
+	return violations
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStore persists records in an embedded bbolt database file.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (or creates) the bbolt database at path.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database %s: %w", path, err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Put writes value under key in bucket, creating the bucket if needed.
+// Concurrent writes are coalesced into shared transactions.
+func (b *BoltStore) Put(bucket, key string, value []byte) error {
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+		}
+		return bkt.Put([]byte(key), value)
+	})
+}
+
+// Get returns a copy of the value stored under key in bucket.
+func (b *BoltStore) Get(bucket, key string) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return ErrNotFound
+		}
+		data := bkt.Get([]byte(key))
+		if data == nil {
+			return ErrNotFound
+		}
+		// Values are only valid for the life of the transaction
+		value = append([]byte(nil), data...)
+		return nil
+	})
+	return value, err
+}
+
+// Delete removes key from bucket.
+func (b *BoltStore) Delete(bucket, key string) error {
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return nil
+		}
+		return bkt.Delete([]byte(key))
+	})
+}
+
+// ForEach calls fn for every key in bucket inside a read transaction.
+func (b *BoltStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			return fn(string(k), append([]byte(nil), v...))
+		})
+	})
+}
+
+// Close closes the underlying database file.
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
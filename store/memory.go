@@ -0,0 +1,67 @@
+package store
+
+import "sync"
+
+// MemoryStore keeps records in process memory. Its contents are lost on restart.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+// Put writes a copy of value under key in bucket.
+func (m *MemoryStore) Put(bucket, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, exists := m.buckets[bucket]
+	if !exists {
+		b = make(map[string][]byte)
+		m.buckets[bucket] = b
+	}
+	b[key] = append([]byte(nil), value...)
+	return nil
+}
+
+// Get returns a copy of the value stored under key in bucket.
+func (m *MemoryStore) Get(bucket, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, exists := m.buckets[bucket][key]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+// Delete removes key from bucket.
+func (m *MemoryStore) Delete(bucket, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.buckets[bucket], key)
+	return nil
+}
+
+// ForEach calls fn for every key in bucket. The store is read-locked for the duration.
+func (m *MemoryStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for key, value := range m.buckets[bucket] {
+		if err := fn(key, append([]byte(nil), value...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close is a no-op for the in-memory store.
+func (m *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"blockhouse/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Buckets used to group records in the metadata store.
+const (
+	StreamsBucket = "streams"  // Stream registry records keyed by stream ID
+	APIKeysBucket = "api_keys" // API key records keyed by key fingerprint
+	CursorsBucket = "cursors"  // Consumer cursors keyed by consumer group ID
+	SchemasBucket = "schemas"  // Stream JSON Schema versions keyed by stream ID
+	SecretsBucket = "secrets"  // Server-generated signing secrets keyed by purpose
+	TenantsBucket = "tenants"  // Tenant records keyed by tenant ID
+	LimitsBucket  = "limits"   // Rate limit policies set at runtime
+)
+
+// ErrNotFound is returned when a key does not exist in a bucket.
+var ErrNotFound = errors.New("record not found")
+
+// Store is a minimal bucketed key/value store for server metadata.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Put writes value under key in bucket, replacing any existing value.
+	Put(bucket, key string, value []byte) error
+	// Get returns the value stored under key in bucket, or ErrNotFound.
+	Get(bucket, key string) ([]byte, error)
+	// Delete removes key from bucket. Deleting a missing key is not an error.
+	Delete(bucket, key string) error
+	// ForEach calls fn for every key in bucket; returning an error from fn stops iteration.
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// Open creates a store for the given backend ("memory" or "bolt").
+func Open(backend, path string) (Store, error) {
+	switch backend {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "bolt":
+		return NewBoltStore(path)
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", backend)
+	}
+}
+
+var (
+	defaultOnce  sync.Once
+	defaultStore Store
+)
+
+// Default returns the process-wide store configured through STORE_BACKEND and STORE_PATH.
+func Default() Store {
+	defaultOnce.Do(func() {
+		cfg := config.GetStoreConfig()
+		s, err := Open(cfg.Backend, cfg.Path)
+		if err != nil {
+			log.Fatalf("Failed to open %s metadata store: %v", cfg.Backend, err)
+		}
+		log.Printf("Using %s metadata store", cfg.Backend)
+		defaultStore = s
+	})
+	return defaultStore
+}
+
+// PutJSON marshals v and writes it under key in bucket.
+func PutJSON(s Store, bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s record %s: %w", bucket, key, err)
+	}
+	return s.Put(bucket, key, data)
+}
+
+// GetJSON reads the record under key in bucket and unmarshals it into v.
+func GetJSON(s Store, bucket, key string, v interface{}) error {
+	data, err := s.Get(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to unmarshal %s record %s: %w", bucket, key, err)
+	}
+	return nil
+}
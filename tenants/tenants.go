@@ -0,0 +1,364 @@
+package tenants
+
+import (
+	"blockhouse/models"
+	"blockhouse/registry"
+	"blockhouse/store"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+var (
+	// ErrTenantNotFound is returned when no tenant has the requested ID.
+	ErrTenantNotFound = errors.New("tenant not found")
+	// ErrTenantExists is returned when creating a tenant whose ID is already taken.
+	ErrTenantExists = errors.New("tenant already exists")
+	// ErrInvalidTenant is returned for a malformed tenant ID or negative limits.
+	ErrInvalidTenant = errors.New("invalid tenant")
+	// ErrStreamLimit is returned when a tenant already holds as many streams as it may.
+	ErrStreamLimit = errors.New("tenant stream limit reached")
+	// ErrThroughputLimit is returned when a tenant is producing faster than its rate allows.
+	ErrThroughputLimit = errors.New("tenant throughput limit exceeded")
+	// ErrWriteVolumeLimit is returned when a write would take a tenant past its write volume limit.
+	ErrWriteVolumeLimit = errors.New("tenant write volume limit reached")
+)
+
+// Counts writes and stream creations refused because a tenant reached one of its limits
+var quotaRejections = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "tenant_quota_rejections_total",
+		Help: "Total number of requests refused because a tenant reached a limit",
+	},
+	[]string{"tenant", "limit"},
+)
+
+func init() {
+	prometheus.MustRegister(quotaRejections)
+}
+
+// validID matches tenant IDs that are safe to use as a Kafka topic prefix. Dots are excluded
+// because they separate the tenant from the stream ID in topic names.
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// ValidID reports whether id can be used as a tenant ID.
+func ValidID(id string) bool {
+	return validID.MatchString(id)
+}
+
+// TopicName returns the Kafka topic for a tenant's stream.
+func TopicName(tenant, streamID string) string {
+	return models.TenantOf(tenant) + "." + streamID
+}
+
+// Manager keeps tenant records in the metadata store and enforces their limits. Throughput and
+// write volume are tracked in memory, so each instance enforces the limits on its own traffic.
+// Write volume counts the bytes accepted for streams that still exist, not what Kafka retains:
+// it never goes down as retention drops old messages, only when a stream is deleted.
+type Manager struct {
+	store   store.Store
+	streams *registry.Registry
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter // Produce rate limiters by tenant ID
+	written  map[string]int64         // Bytes written by tenant ID, loaded from the registry on first use
+	creating map[string]int           // Streams being created by tenant ID, not registered yet
+}
+
+// New creates a tenant manager backed by the given store, counting streams in streams.
+func New(s store.Store, streams *registry.Registry) *Manager {
+	return &Manager{store: s, streams: streams, limiters: make(map[string]*rate.Limiter), written: make(map[string]int64), creating: make(map[string]int)}
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultManager *Manager
+)
+
+// Default returns the process-wide tenant manager shared by the API handlers.
+func Default() *Manager {
+	defaultOnce.Do(func() {
+		defaultManager = New(store.Default(), registry.Default())
+	})
+	return defaultManager
+}
+
+// checkLimits rejects negative limits
+func checkLimits(limits models.TenantLimits) error {
+	if limits.MaxStreams < 0 || limits.MessagesPerSecond < 0 || limits.Burst < 0 || limits.MaxWrittenBytes < 0 {
+		return fmt.Errorf("%w: limits must not be negative", ErrInvalidTenant)
+	}
+	return nil
+}
+
+// checkTier rejects tier names that are not valid IDs; an empty tier is allowed
+func checkTier(tier string) error {
+	if tier != "" && !ValidID(tier) {
+		return fmt.Errorf("%w: tier %q must be lowercase letters, digits, '-' or '_'", ErrInvalidTenant, tier)
+	}
+	return nil
+}
+
+// Create registers a new tenant.
+func (m *Manager) Create(tenant models.Tenant) (models.Tenant, error) {
+	if !ValidID(tenant.ID) {
+		return models.Tenant{}, fmt.Errorf("%w: ID %q must be lowercase letters, digits, '-' or '_'", ErrInvalidTenant, tenant.ID)
+	}
+	if err := checkLimits(tenant.Limits); err != nil {
+		return models.Tenant{}, err
+	}
+	if err := checkTier(tenant.Tier); err != nil {
+		return models.Tenant{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.load(tenant.ID); err == nil || tenant.ID == models.DefaultTenant {
+		return models.Tenant{}, ErrTenantExists
+	} else if !errors.Is(err, ErrTenantNotFound) {
+		return models.Tenant{}, err
+	}
+	tenant.CreatedAt = time.Now().UTC()
+	if err := store.PutJSON(m.store, store.TenantsBucket, tenant.ID, tenant); err != nil {
+		return models.Tenant{}, err
+	}
+	return tenant, nil
+}
+
+// load reads a stored tenant record
+func (m *Manager) load(id string) (models.Tenant, error) {
+	var tenant models.Tenant
+	if err := store.GetJSON(m.store, store.TenantsBucket, id, &tenant); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return models.Tenant{}, ErrTenantNotFound
+		}
+		return models.Tenant{}, err
+	}
+	return tenant, nil
+}
+
+// Get returns the tenant with the given ID. The default tenant always exists; until its limits
+// are set it has none.
+func (m *Manager) Get(id string) (models.Tenant, error) {
+	id = models.TenantOf(id)
+	tenant, err := m.load(id)
+	if errors.Is(err, ErrTenantNotFound) && id == models.DefaultTenant {
+		return models.Tenant{ID: models.DefaultTenant, Name: models.DefaultTenant}, nil
+	}
+	return tenant, err
+}
+
+// List returns every tenant, the default tenant first and the rest oldest first.
+func (m *Manager) List() ([]models.Tenant, error) {
+	tenants := []models.Tenant{}
+	err := m.store.ForEach(store.TenantsBucket, func(id string, value []byte) error {
+		var tenant models.Tenant
+		if err := json.Unmarshal(value, &tenant); err != nil {
+			return fmt.Errorf("failed to unmarshal tenant record %s: %w", id, err)
+		}
+		if id != models.DefaultTenant {
+			tenants = append(tenants, tenant)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].CreatedAt.Before(tenants[j].CreatedAt) })
+
+	defaultTenant, err := m.Get(models.DefaultTenant)
+	if err != nil {
+		return nil, err
+	}
+	return append([]models.Tenant{defaultTenant}, tenants...), nil
+}
+
+// SetLimits replaces a tenant's limits. Lowering a limit below current usage does not remove
+// anything; it only refuses new streams or writes until usage drops.
+func (m *Manager) SetLimits(id string, limits models.TenantLimits) (models.Tenant, error) {
+	if err := checkLimits(limits); err != nil {
+		return models.Tenant{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tenant, err := m.Get(id)
+	if err != nil {
+		return models.Tenant{}, err
+	}
+	if tenant.CreatedAt.IsZero() {
+		tenant.CreatedAt = time.Now().UTC()
+	}
+	tenant.Limits = limits
+	if err := store.PutJSON(m.store, store.TenantsBucket, tenant.ID, tenant); err != nil {
+		return models.Tenant{}, err
+	}
+	return tenant, nil
+}
+
+// SetTier moves a tenant to another tier, or out of any tier when tier is empty.
+func (m *Manager) SetTier(id, tier string) (models.Tenant, error) {
+	if err := checkTier(tier); err != nil {
+		return models.Tenant{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tenant, err := m.Get(id)
+	if err != nil {
+		return models.Tenant{}, err
+	}
+	if tenant.CreatedAt.IsZero() {
+		tenant.CreatedAt = time.Now().UTC()
+	}
+	tenant.Tier = tier
+	if err := store.PutJSON(m.store, store.TenantsBucket, tenant.ID, tenant); err != nil {
+		return models.Tenant{}, err
+	}
+	return tenant, nil
+}
+
+// Usage returns how many streams a tenant holds and how many bytes have been written to them.
+func (m *Manager) Usage(id string) (registry.TenantUsage, error) {
+	return m.streams.TenantUsage(id)
+}
+
+// ReserveStream holds one of the tenant's stream slots while a stream is created, so that
+// concurrent creations cannot take it past its stream limit. The returned function gives the
+// slot back and must be called once the stream is registered or its creation has failed. It
+// returns ErrStreamLimit if the tenant may not create another stream, or ErrTenantNotFound if
+// it does not exist.
+func (m *Manager) ReserveStream(id string) (func(), error) {
+	tenant, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if limit := tenant.Limits.MaxStreams; limit > 0 {
+		usage, err := m.streams.TenantUsage(tenant.ID)
+		if err != nil {
+			return nil, err
+		}
+		if held := usage.Streams + m.creating[tenant.ID]; held >= limit {
+			quotaRejections.WithLabelValues(tenant.ID, "streams").Inc()
+			return nil, fmt.Errorf("%w: %s holds %d of %d streams", ErrStreamLimit, tenant.ID, held, limit)
+		}
+	}
+	m.creating[tenant.ID]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.creating[tenant.ID]--; m.creating[tenant.ID] == 0 {
+				delete(m.creating, tenant.ID)
+			}
+		})
+	}, nil
+}
+
+// AllowWrite checks a write of n bytes against the tenant's throughput and write volume limits
+// and, if it is allowed, counts it. It returns ErrThroughputLimit or ErrWriteVolumeLimit otherwise.
+func (m *Manager) AllowWrite(id string, n int64) error {
+	return m.AllowWriteN(id, 1, n)
+}
+
+// AllowWriteN checks a batch of messages totalling n bytes like AllowWrite, counting every
+// message against the throughput limit. A batch larger than the tenant's burst is always refused.
+func (m *Manager) AllowWriteN(id string, messages int, n int64) error {
+	tenant, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	limits := tenant.Limits
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if limits.MaxWrittenBytes > 0 {
+		written, err := m.writtenBytes(tenant.ID)
+		if err != nil {
+			return err
+		}
+		if written+n > limits.MaxWrittenBytes {
+			quotaRejections.WithLabelValues(tenant.ID, "write_volume").Inc()
+			return fmt.Errorf("%w: %s has written %d of %d bytes", ErrWriteVolumeLimit, tenant.ID, written, limits.MaxWrittenBytes)
+		}
+	}
+	if limits.MessagesPerSecond > 0 && !m.limiter(tenant.ID, limits).AllowN(time.Now(), messages) {
+		quotaRejections.WithLabelValues(tenant.ID, "throughput").Inc()
+		return fmt.Errorf("%w: %s may send %g messages per second", ErrThroughputLimit, tenant.ID, limits.MessagesPerSecond)
+	}
+	if _, ok := m.written[tenant.ID]; ok {
+		m.written[tenant.ID] += n
+	}
+	return nil
+}
+
+// Release stops counting n bytes against a tenant's write volume, once the stream they were
+// written to has been deleted.
+func (m *Manager) Release(id string, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id = models.TenantOf(id)
+	if _, ok := m.written[id]; ok {
+		m.written[id] -= n
+	}
+}
+
+// Refund gives back n bytes counted by AllowWrite or AllowWriteN for a write that failed.
+// Throughput already used is not given back.
+func (m *Manager) Refund(id string, n int64) {
+	m.Release(id, n)
+}
+
+// writtenBytes returns the bytes written to a tenant's streams, loading the total from the
+// registry the first time. The caller must hold m.mu.
+func (m *Manager) writtenBytes(id string) (int64, error) {
+	if written, ok := m.written[id]; ok {
+		return written, nil
+	}
+	usage, err := m.streams.TenantUsage(id)
+	if err != nil {
+		return 0, err
+	}
+	m.written[id] = usage.WrittenBytes
+	return usage.WrittenBytes, nil
+}
+
+// limiter returns the tenant's produce rate limiter, adjusted to its current limits. The
+// caller must hold m.mu.
+func (m *Manager) limiter(id string, limits models.TenantLimits) *rate.Limiter {
+	burst := limits.Burst
+	if burst == 0 {
+		burst = int(math.Max(1, math.Ceil(limits.MessagesPerSecond)))
+	}
+	limiter, ok := m.limiters[id]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(limits.MessagesPerSecond), burst)
+		m.limiters[id] = limiter
+	}
+	if limiter.Limit() != rate.Limit(limits.MessagesPerSecond) {
+		limiter.SetLimit(rate.Limit(limits.MessagesPerSecond))
+	}
+	if limiter.Burst() != burst {
+		limiter.SetBurst(burst)
+	}
+	return limiter
+}
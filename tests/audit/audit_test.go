@@ -0,0 +1,73 @@
+package audit_test
+
+import (
+	"blockhouse/audit"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFileSink verifies events are appended as JSON lines and can be queried by time range,
+// principal, type and limit
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := audit.NewFileSink(path)
+	assert.NoError(t, err, "Expected the audit file to open")
+	defer sink.Close()
+	logger := audit.New(sink)
+
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	logger.Log(audit.Event{Time: start, Type: audit.EventAuthFailure, Outcome: audit.OutcomeFailure, Reason: "invalid or missing API key"})
+	logger.Log(audit.Event{Time: start.Add(time.Minute), Type: audit.EventKeyCreate, Outcome: audit.OutcomeSuccess, Principal: "admin"})
+	logger.Log(audit.Event{Time: start.Add(2 * time.Minute), Type: audit.EventStreamCreate, Outcome: audit.OutcomeSuccess, Principal: "team-a"})
+	logger.Log(audit.Event{Time: start.Add(3 * time.Minute), Type: audit.EventStreamDelete, Outcome: audit.OutcomeSuccess, Principal: "team-a"})
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, strings.Count(string(data), "\n"), "Expected one line per event")
+
+	events, err := logger.Query(audit.Filter{Principal: "team-a"})
+	assert.NoError(t, err)
+	assert.Len(t, events, 2, "Expected only team-a's events")
+
+	events, err = logger.Query(audit.Filter{From: start.Add(time.Minute), To: start.Add(2 * time.Minute)})
+	assert.NoError(t, err)
+	assert.Len(t, events, 2, "Expected the time range to be inclusive")
+	assert.Equal(t, audit.EventKeyCreate, events[0].Type, "Expected events oldest first")
+
+	events, err = logger.Query(audit.Filter{Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, audit.EventStreamDelete, events[0].Type, "Expected the limit to keep the most recent events")
+
+	events, err = logger.Query(audit.Filter{Type: audit.EventAuthFailure})
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+
+	// Reopening appends rather than truncating
+	reopened, err := audit.NewFileSink(path)
+	assert.NoError(t, err)
+	defer reopened.Close()
+	audit.New(reopened).Log(audit.Event{Type: audit.EventKeyRevoke, Outcome: audit.OutcomeSuccess})
+	events, err = reopened.Query(audit.Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, events, 5, "Expected earlier events to be kept")
+	assert.False(t, events[4].Time.IsZero(), "Expected the event time to be stamped")
+}
+
+// TestRecordFromRequest verifies request details are captured and that querying needs a file
+func TestRecordFromRequest(t *testing.T) {
+	req := httptest.NewRequest("DELETE", "/stream/abc", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+	req = req.WithContext(audit.WithRequestID(req.Context(), "req-1"))
+	assert.Equal(t, "203.0.113.7", audit.SourceIP(req))
+	assert.Equal(t, "req-1", audit.RequestID(req))
+
+	_, err := audit.New().Query(audit.Filter{})
+	assert.ErrorIs(t, err, audit.ErrQueryUnavailable)
+}
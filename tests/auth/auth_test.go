@@ -0,0 +1,92 @@
+package auth_test
+
+import (
+	"blockhouse/auth"
+	"blockhouse/models"
+	"blockhouse/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestKeyLifecycle verifies keys can be created, authenticated with their secret and revoked
+func TestKeyLifecycle(t *testing.T) {
+	keys := auth.NewKeyStore(store.NewMemoryStore(), nil)
+
+	key, secret, err := keys.Create("ingest", models.DefaultTenant, []string{models.ScopeStreamWrite}, nil)
+	assert.NoError(t, err, "Expected no error creating a key")
+	assert.NotEmpty(t, secret, "Expected the secret to be returned")
+	assert.NotContains(t, key.Hash, secret, "Expected only a hash of the secret to be stored")
+
+	authenticated, err := keys.Authenticate(secret)
+	assert.NoError(t, err, "Expected the new secret to authenticate")
+	assert.Equal(t, key.ID, authenticated.ID)
+	assert.True(t, authenticated.HasScope(models.ScopeStreamWrite), "Expected the granted scope")
+	assert.False(t, authenticated.HasScope(models.ScopeStreamCreate), "Expected scopes not granted to be refused")
+
+	_, err = keys.Authenticate(secret + "x")
+	assert.ErrorIs(t, err, auth.ErrInvalidKey, "Expected an unknown secret to be rejected")
+
+	revoked, err := keys.Revoke(key.ID)
+	assert.NoError(t, err, "Expected no error revoking the key")
+	assert.False(t, revoked.Enabled, "Expected the revoked key to be disabled")
+	_, err = keys.Authenticate(secret)
+	assert.ErrorIs(t, err, auth.ErrInvalidKey, "Expected a revoked key to be rejected")
+
+	listed, err := keys.List()
+	assert.NoError(t, err, "Expected no error listing keys")
+	assert.Len(t, listed, 1, "Expected revoked keys to remain listed")
+
+	_, err = keys.Revoke("missing")
+	assert.ErrorIs(t, err, auth.ErrKeyNotFound)
+}
+
+// TestKeyExpiryAndScopes verifies expired keys are rejected and scopes are validated
+func TestKeyExpiryAndScopes(t *testing.T) {
+	keys := auth.NewKeyStore(store.NewMemoryStore(), nil)
+
+	expired := time.Now().Add(-time.Minute)
+	_, secret, err := keys.Create("stale", models.DefaultTenant, []string{models.ScopeStreamRead}, &expired)
+	assert.NoError(t, err, "Expected no error creating a key")
+	_, err = keys.Authenticate(secret)
+	assert.ErrorIs(t, err, auth.ErrInvalidKey, "Expected an expired key to be rejected")
+
+	_, _, err = keys.Create("nothing", models.DefaultTenant, nil, nil)
+	assert.ErrorIs(t, err, auth.ErrInvalidScope, "Expected keys without scopes to be refused")
+	_, _, err = keys.Create("typo", models.DefaultTenant, []string{"stream:delete"}, nil)
+	assert.ErrorIs(t, err, auth.ErrInvalidScope, "Expected unknown scopes to be refused")
+
+	admin, _, err := keys.Create("operator", models.DefaultTenant, []string{models.ScopeAdmin}, nil)
+	assert.NoError(t, err, "Expected no error creating an admin key")
+	assert.True(t, admin.HasScope(models.ScopeStreamCreate), "Expected admin to imply every scope")
+}
+
+// TestStreamTokens verifies stream tokens are bound to their stream, use, generation and expiry
+func TestStreamTokens(t *testing.T) {
+	signer := auth.NewTokenSigner([]byte("test-secret"))
+
+	token, err := signer.Issue("stream-1", auth.TokenProduce, 0, 0)
+	assert.NoError(t, err, "Expected no error issuing a token")
+
+	claims, err := signer.Verify(token, "stream-1", auth.TokenProduce, 0)
+	assert.NoError(t, err, "Expected the token to verify")
+	assert.Equal(t, "stream-1", claims.StreamID)
+
+	_, err = signer.Verify(token, "stream-2", auth.TokenProduce, 0)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken, "Expected tokens for another stream to be rejected")
+	_, err = signer.Verify(token, "stream-1", auth.TokenConsume, 0)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken, "Expected producer tokens to be refused for consuming")
+	_, err = signer.Verify(token, "stream-1", auth.TokenProduce, 1)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken, "Expected tokens from an earlier generation to be rejected")
+	_, err = auth.NewTokenSigner([]byte("other-secret")).Verify(token, "stream-1", auth.TokenProduce, 0)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken, "Expected tokens signed with another secret to be rejected")
+
+	expiring, err := signer.Issue("stream-1", auth.TokenConsume, 0, time.Second)
+	assert.NoError(t, err, "Expected no error issuing an expiring token")
+	_, err = signer.Verify(expiring, "stream-1", auth.TokenConsume, 0)
+	assert.NoError(t, err, "Expected the token to verify before it expires")
+	time.Sleep(1100 * time.Millisecond)
+	_, err = signer.Verify(expiring, "stream-1", auth.TokenConsume, 0)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken, "Expected the token to be rejected once expired")
+}
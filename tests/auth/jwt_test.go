@@ -0,0 +1,151 @@
+package auth_test
+
+import (
+	"blockhouse/auth"
+	"blockhouse/config"
+	"blockhouse/models"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// testKeys holds signing keys along with the JWKS document publishing them
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	hmac []byte
+	jwks []byte
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err, "Failed to generate RSA key")
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "Failed to generate EC key")
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-1", "alg": "RS256", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "oct", "kid": "hmac-1", "k": b64(secret)},
+	}})
+	assert.NoError(t, err, "Failed to marshal JWKS")
+	return testKeys{rsa: rsaKey, ec: ecKey, hmac: secret, jwks: jwks}
+}
+
+// sign creates a token with the given method, key ID and claims
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err, "Failed to sign token")
+	return signed
+}
+
+func validClaims(scope interface{}) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "alice",
+		"iss":   "https://sso.example.com",
+		"aud":   "blockhouse",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": scope,
+	}
+}
+
+// TestJWTVerifier verifies RS256, ES256 and HS256 tokens from a JWKS file and the claim checks
+func TestJWTVerifier(t *testing.T) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(path, keys.jwks, 0o600), "Failed to write JWKS file")
+
+	verifier, err := auth.NewJWTVerifier(config.AuthConfig{
+		JWKSFile:     path,
+		JWKSRefresh:  time.Hour,
+		Issuer:       "https://sso.example.com",
+		Audience:     "blockhouse",
+		ScopeClaim:   "scope",
+		SubjectClaim: "sub",
+	})
+	assert.NoError(t, err, "Expected no error creating the verifier")
+	ctx := context.Background()
+
+	principal, err := verifier.Verify(ctx, sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, validClaims("stream:read stream:write openid")))
+	assert.NoError(t, err, "Expected an RS256 token to verify")
+	assert.Equal(t, "jwt:alice", principal.ID)
+	assert.Equal(t, []string{models.ScopeStreamRead, models.ScopeStreamWrite}, principal.Scopes, "Expected unknown scopes to be dropped")
+	assert.False(t, principal.HasScope(models.ScopeStreamCreate))
+
+	principal, err = verifier.Verify(ctx, sign(t, jwt.SigningMethodES256, "ec-1", keys.ec, validClaims([]string{"admin"})))
+	assert.NoError(t, err, "Expected an ES256 token to verify")
+	assert.True(t, principal.HasScope(models.ScopeStreamCreate), "Expected array scope claims to be read")
+
+	_, err = verifier.Verify(ctx, sign(t, jwt.SigningMethodHS256, "hmac-1", keys.hmac, validClaims("stream:read")))
+	assert.NoError(t, err, "Expected an HS256 token to verify")
+
+	// Tokens failing any check are rejected
+	wrongIssuer := validClaims("stream:read")
+	wrongIssuer["iss"] = "https://evil.example.com"
+	wrongAudience := validClaims("stream:read")
+	wrongAudience["aud"] = "someone-else"
+	expired := validClaims("stream:read")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	noExpiry := validClaims("stream:read")
+	delete(noExpiry, "exp")
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	for name, token := range map[string]string{
+		"wrong issuer":   sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, wrongIssuer),
+		"wrong audience": sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, wrongAudience),
+		"expired":        sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, expired),
+		"no expiry":      sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, noExpiry),
+		"unknown key":    sign(t, jwt.SigningMethodRS256, "rsa-1", otherKey, validClaims("stream:read")),
+		"unknown kid":    sign(t, jwt.SigningMethodRS256, "rsa-2", keys.rsa, validClaims("stream:read")),
+		"alg confusion":  sign(t, jwt.SigningMethodHS256, "rsa-1", keys.hmac, validClaims("stream:read")),
+		"unsigned":       sign(t, jwt.SigningMethodNone, "rsa-1", jwt.UnsafeAllowNoneSignatureType, validClaims("stream:read")),
+	} {
+		_, err := verifier.Verify(ctx, token)
+		assert.ErrorIs(t, err, auth.ErrInvalidBearer, "Expected the %s token to be rejected", name)
+	}
+}
+
+// TestJWKSFromURL verifies keys can be fetched from a JWKS endpoint
+func TestJWKSFromURL(t *testing.T) {
+	keys := newTestKeys(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(keys.jwks)
+	}))
+	defer server.Close()
+
+	verifier, err := auth.NewJWTVerifier(config.AuthConfig{
+		JWKSURL:      server.URL,
+		JWKSRefresh:  time.Hour,
+		ScopeClaim:   "scope",
+		SubjectClaim: "sub",
+	})
+	assert.NoError(t, err, "Expected no error creating the verifier")
+
+	principal, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, validClaims("stream:create")))
+	assert.NoError(t, err, "Expected a token signed with a published key to verify")
+	assert.True(t, principal.HasScope(models.ScopeStreamCreate))
+
+	_, err = auth.NewJWTVerifier(config.AuthConfig{})
+	assert.Error(t, err, "Expected a verifier without a JWKS source to be refused")
+}
@@ -0,0 +1,136 @@
+package auth_test
+
+import (
+	"blockhouse/auth"
+	"blockhouse/models"
+	"blockhouse/store"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeKeys writes a key file and moves its modification time forward so a reload notices it
+func writeKeys(t *testing.T, path, contents string, modified time.Time) {
+	t.Helper()
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0o600), "Failed to write key file")
+	assert.NoError(t, os.Chtimes(path, modified, modified))
+}
+
+// TestKeyRingRotation verifies keys from a file are reloaded when it changes, that a removed
+// key keeps working for the rotation window, and that the key version is reported
+func TestKeyRingRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeKeys(t, path, `{"keys": [{"name": "ingest", "version": "v1", "secret": "old-secret", "scopes": ["stream:write"]}]}`, time.Now())
+
+	ring, err := auth.NewKeyRing(200*time.Millisecond, auth.FileSource{Path: path})
+	assert.NoError(t, err, "Expected the key file to load")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ring.Watch(ctx, 20*time.Millisecond)
+
+	principal, err := ring.Authenticate("old-secret")
+	assert.NoError(t, err, "Expected the file key to authenticate")
+	assert.Equal(t, "ingest", principal.Name)
+	assert.Equal(t, "v1", principal.KeyVersion)
+	assert.True(t, principal.HasScope(models.ScopeStreamWrite))
+
+	// Rotate: the new version is listed by hash and the old one is dropped from the file
+	sum := sha256.Sum256([]byte("new-secret"))
+	writeKeys(t, path, `{"keys": [{"name": "ingest", "version": "v2", "sha256": "`+hex.EncodeToString(sum[:])+`", "scopes": ["stream:write"]}]}`, time.Now().Add(time.Minute))
+	assert.Eventually(t, func() bool {
+		principal, err := ring.Authenticate("new-secret")
+		return err == nil && principal.KeyVersion == "v2"
+	}, 2*time.Second, 20*time.Millisecond, "Expected the new key version to be picked up")
+
+	_, err = ring.Authenticate("old-secret")
+	assert.NoError(t, err, "Expected the old version to keep working during the rotation window")
+	assert.Eventually(t, func() bool {
+		_, err := ring.Authenticate("old-secret")
+		return err != nil
+	}, 2*time.Second, 20*time.Millisecond, "Expected the old version to stop working after the rotation window")
+	_, err = ring.Authenticate("new-secret")
+	assert.NoError(t, err, "Expected the new version to keep working")
+}
+
+// TestKeyRingSources verifies environment keys, invalid entries and the lookup order between
+// the key ring and the key store
+func TestKeyRingSources(t *testing.T) {
+	t.Setenv("API_KEY", "current-secret")
+	t.Setenv("API_KEY_VERSION", "2024-06")
+	t.Setenv("API_KEY_PREVIOUS", "previous-secret")
+	t.Setenv("API_KEY_PREVIOUS_VERSION", "")
+
+	ring, err := auth.NewKeyRing(0, auth.EnvSource{})
+	assert.NoError(t, err, "Expected the environment keys to load")
+	current, err := ring.Authenticate("current-secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-06", current.KeyVersion)
+	assert.True(t, current.HasScope(models.ScopeAdmin), "Expected API_KEY to be an admin key")
+	previous, err := ring.Authenticate("previous-secret")
+	assert.NoError(t, err, "Expected API_KEY_PREVIOUS to stay valid while it is set")
+	assert.Equal(t, auth.Fingerprint("previous-secret"), previous.KeyVersion, "Expected an unlabelled version to default to the key ID")
+
+	// With no rotation window, unsetting the previous key revokes it on the next reload
+	os.Unsetenv("API_KEY_PREVIOUS")
+	assert.NoError(t, ring.Reload())
+	_, err = ring.Authenticate("previous-secret")
+	assert.ErrorIs(t, err, auth.ErrInvalidKey)
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeKeys(t, path, `{"keys": [{"name": "broken", "scopes": ["stream:write"]}, {"name": "bad-scope", "secret": "s", "scopes": ["everything"]}]}`, time.Now())
+	_, err = auth.NewKeyRing(0, auth.FileSource{Path: path})
+	assert.Error(t, err, "Expected keys without a secret or with unknown scopes to be reported")
+
+	// Keys created through the API are still found behind the ring
+	keys := auth.NewKeyStore(store.NewMemoryStore(), nil)
+	created, secret, err := keys.Create("reader", models.DefaultTenant, []string{models.ScopeStreamRead}, nil)
+	assert.NoError(t, err)
+	apiKeys := auth.APIKeys{Ring: ring, Store: keys}
+	principal, err := apiKeys.Authenticate(secret)
+	assert.NoError(t, err, "Expected a stored key to authenticate")
+	assert.Equal(t, created.ID, principal.KeyVersion)
+	_, err = apiKeys.Authenticate("current-secret")
+	assert.NoError(t, err, "Expected a ring key to authenticate")
+
+	// Ring keys can sign requests too
+	verifier := auth.NewSignatureVerifier(apiKeys, time.Minute, 0)
+	principal, err = verifier.Verify(signedRequest(t, "current-secret", `{}`))
+	assert.NoError(t, err, "Expected a request signed with a ring key to verify")
+	assert.Equal(t, "default", principal.Name)
+
+	// Keys listed by hash only can authenticate but not sign
+	sum := sha256.Sum256([]byte("hashed-secret"))
+	writeKeys(t, path, `{"keys": [{"name": "hashed", "sha256": "`+hex.EncodeToString(sum[:])+`", "scopes": ["stream:write"]}]}`, time.Now())
+	hashed, err := auth.NewKeyRing(0, auth.FileSource{Path: path})
+	assert.NoError(t, err, "Expected the hashed key to load")
+	_, err = hashed.Authenticate("hashed-secret")
+	assert.NoError(t, err, "Expected a hashed key to authenticate")
+	_, err = auth.NewSignatureVerifier(hashed, time.Minute, 0).Verify(signedRequest(t, "hashed-secret", `{}`))
+	assert.ErrorIs(t, err, auth.ErrInvalidKey, "Expected a hashed key not to sign requests")
+}
+
+// TestKeyRingFailedSource verifies a source that fails to reload keeps only its own keys, so a
+// key removed from a source that reloaded is revoked even while another one fails
+func TestKeyRingFailedSource(t *testing.T) {
+	t.Setenv("API_KEY", "env-secret")
+	t.Setenv("API_KEY_PREVIOUS", "")
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeKeys(t, path, `{"keys": [{"name": "ingest", "secret": "file-secret", "scopes": ["stream:write"]}]}`, time.Now())
+
+	ring, err := auth.NewKeyRing(0, auth.EnvSource{}, auth.FileSource{Path: path})
+	assert.NoError(t, err)
+
+	os.Unsetenv("API_KEY")
+	writeKeys(t, path, `{"keys": [`, time.Now().Add(time.Minute))
+	assert.Error(t, ring.Reload(), "Expected the broken key file to be reported")
+	_, err = ring.Authenticate("file-secret")
+	assert.NoError(t, err, "Expected the failed source's keys to be kept")
+	_, err = ring.Authenticate("env-secret")
+	assert.ErrorIs(t, err, auth.ErrInvalidKey, "Expected a key removed from a source that reloaded to be revoked")
+}
@@ -0,0 +1,70 @@
+package auth_test
+
+import (
+	"blockhouse/auth"
+	"blockhouse/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStreamAccess verifies the actions each role allows and that denials explain which roles are needed
+func TestStreamAccess(t *testing.T) {
+	stream := models.Stream{
+		ID:    "stream-1",
+		Owner: "owner-key",
+		ACL: []models.Grant{
+			{Principal: "jwt:producer", Role: models.RoleProducer},
+			{Principal: "cert:analytics", Role: models.RoleConsumer},
+		},
+	}
+	owner := auth.Principal{ID: "owner-key", Scopes: []string{models.ScopeStreamCreate}}
+	producer := auth.Principal{ID: "jwt:producer", Scopes: []string{models.ScopeStreamWrite}}
+	consumer := auth.Principal{ID: "cert:analytics", Scopes: []string{models.ScopeStreamRead}}
+	admin := auth.Principal{ID: "admin-key", Scopes: []string{models.ScopeAdmin}}
+	stranger := auth.Principal{ID: "other-key", Scopes: []string{models.ScopeStreamRead}}
+
+	assert.Equal(t, []string{models.RoleOwner}, auth.StreamRoles(owner, stream))
+	assert.Equal(t, []string{models.RoleAdmin}, auth.StreamRoles(admin, stream))
+
+	for _, action := range []string{auth.ActionView, auth.ActionProduce, auth.ActionConsume, auth.ActionManage} {
+		assert.NoError(t, auth.CheckStreamAccess(owner, stream, action), "Expected the owner to be allowed to %s", action)
+		assert.NoError(t, auth.CheckStreamAccess(admin, stream, action), "Expected an admin to be allowed to %s", action)
+	}
+
+	assert.NoError(t, auth.CheckStreamAccess(producer, stream, auth.ActionProduce))
+	assert.ErrorIs(t, auth.CheckStreamAccess(producer, stream, auth.ActionConsume), auth.ErrAccessDenied)
+	assert.NoError(t, auth.CheckStreamAccess(consumer, stream, auth.ActionConsume))
+	assert.NoError(t, auth.CheckStreamAccess(consumer, stream, auth.ActionView))
+
+	err := auth.CheckStreamAccess(consumer, stream, auth.ActionManage)
+	assert.ErrorIs(t, err, auth.ErrAccessDenied)
+	assert.Contains(t, err.Error(), "holds consumer", "Expected the denial to name the roles held")
+	assert.Contains(t, err.Error(), "owner, admin", "Expected the denial to name the roles needed")
+
+	err = auth.CheckStreamAccess(stranger, stream, auth.ActionView)
+	assert.ErrorIs(t, err, auth.ErrAccessDenied)
+	assert.Contains(t, err.Error(), "has no role on stream stream-1")
+
+	assert.False(t, auth.GrantableRole(models.RoleAdmin), "Expected the admin role to come only from the admin scope")
+	assert.True(t, auth.GrantableRole(models.RoleConsumer))
+}
+
+// TestStreamAccessTenants verifies no principal, not even an admin, reaches another tenant's streams
+func TestStreamAccessTenants(t *testing.T) {
+	stream := models.Stream{ID: "stream-1", Tenant: "team-a", Owner: "owner-key",
+		ACL: []models.Grant{{Principal: "reader-key", Role: models.RoleConsumer}}}
+	owner := auth.Principal{ID: "owner-key", Tenant: "team-a", Scopes: []string{models.ScopeStreamCreate}}
+	admin := auth.Principal{ID: "admin-key", Tenant: "team-b", Scopes: []string{models.ScopeAdmin}}
+	reader := auth.Principal{ID: "reader-key", Tenant: "team-b", Scopes: []string{models.ScopeStreamRead}}
+
+	assert.NoError(t, auth.CheckStreamAccess(owner, stream, auth.ActionManage))
+	assert.ErrorIs(t, auth.CheckStreamAccess(admin, stream, auth.ActionView), auth.ErrOtherTenant)
+	assert.ErrorIs(t, auth.CheckStreamAccess(reader, stream, auth.ActionConsume), auth.ErrOtherTenant, "Expected grants to be ignored across tenants")
+	assert.ErrorIs(t, auth.CheckStreamAccess(reader, stream, auth.ActionConsume), auth.ErrAccessDenied)
+	assert.Empty(t, auth.StreamRoles(admin, stream))
+
+	legacy := models.Stream{ID: "stream-0", Owner: "owner-key"}
+	defaultOwner := auth.Principal{ID: "owner-key", Tenant: models.DefaultTenant, Scopes: []string{models.ScopeStreamCreate}}
+	assert.NoError(t, auth.CheckStreamAccess(defaultOwner, legacy, auth.ActionManage), "Expected streams without a tenant to belong to the default tenant")
+}
@@ -0,0 +1,95 @@
+package auth_test
+
+import (
+	"blockhouse/auth"
+	"blockhouse/client"
+	"blockhouse/models"
+	"blockhouse/signing"
+	"blockhouse/store"
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// signedRequest builds a send request signed by the client helper
+func signedRequest(t *testing.T, apiKey, body string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "http://localhost:8080/stream/stream-1/send", bytes.NewBufferString(body))
+	assert.NoError(t, err, "Failed to create request")
+	assert.NoError(t, client.SignRequest(req, apiKey), "Failed to sign request")
+	return req
+}
+
+// TestRequestSignatures verifies signed requests authenticate as their key and that tampered,
+// stale and replayed requests are rejected
+func TestRequestSignatures(t *testing.T) {
+	keys := auth.NewKeyStore(store.NewMemoryStore(), nil)
+	key, secret, err := keys.Create("producer", models.DefaultTenant, []string{models.ScopeStreamWrite}, nil)
+	assert.NoError(t, err, "Expected no error creating a key")
+	verifier := auth.NewSignatureVerifier(keys, time.Minute, 64)
+
+	req := signedRequest(t, secret, `{"price": 1}`)
+	principal, err := verifier.Verify(req)
+	assert.NoError(t, err, "Expected a signed request to verify")
+	assert.Equal(t, key.ID, principal.ID, "Expected the signing key to be the principal")
+	body, _ := io.ReadAll(req.Body)
+	assert.Equal(t, `{"price": 1}`, string(body), "Expected the body to remain readable")
+
+	// Replaying the exact same request is rejected
+	replay := signedRequest(t, secret, `{"price": 1}`)
+	replay.Header = req.Header.Clone()
+	_, err = verifier.Verify(replay)
+	assert.ErrorIs(t, err, auth.ErrReplayedRequest)
+
+	// Changing the body after signing breaks the signature
+	tampered := signedRequest(t, secret, `{"price": 1}`)
+	tampered.Body = io.NopCloser(bytes.NewBufferString(`{"price": 1000}`))
+	_, err = verifier.Verify(tampered)
+	assert.ErrorIs(t, err, auth.ErrInvalidSignature)
+
+	stale := signedRequest(t, secret, `{}`)
+	stale.Header.Set(signing.HeaderTimestamp, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	_, err = verifier.Verify(stale)
+	assert.ErrorIs(t, err, auth.ErrStaleRequest)
+
+	_, err = verifier.Verify(signedRequest(t, secret, `{"pad": "`+strings.Repeat("x", 64)+`"}`))
+	assert.ErrorIs(t, err, auth.ErrBodyTooLarge, "Expected bodies over the limit to be refused unread")
+
+	_, err = verifier.Verify(signedRequest(t, "not-a-key", `{}`))
+	assert.ErrorIs(t, err, auth.ErrInvalidKey, "Expected requests signed with an unknown key to be rejected")
+
+	_, err = keys.Revoke(key.ID)
+	assert.NoError(t, err, "Expected no error revoking the key")
+	_, err = verifier.Verify(signedRequest(t, secret, `{}`))
+	assert.ErrorIs(t, err, auth.ErrInvalidKey, "Expected requests signed with a revoked key to be rejected")
+}
+
+// TestStoredHashCannotSign verifies that what the key store persists about a key is not enough
+// to sign requests as it
+func TestStoredHashCannotSign(t *testing.T) {
+	keys := auth.NewKeyStore(store.NewMemoryStore(), nil)
+	key, secret, err := keys.Create("producer", models.DefaultTenant, []string{models.ScopeStreamWrite}, nil)
+	assert.NoError(t, err, "Expected no error creating a key")
+	assert.NotEqual(t, key.Hash[:16], key.ID, "Expected the key ID not to reveal the stored hash")
+	assert.NotContains(t, key.Sealed, hex.EncodeToString(signing.SigningKey(secret)), "Expected the signing key to be stored sealed")
+	verifier := auth.NewSignatureVerifier(keys, time.Minute, 0)
+
+	hash, err := hex.DecodeString(key.Hash)
+	assert.NoError(t, err)
+	req := signedRequest(t, secret, `{"price": 1}`)
+	timestamp, _ := strconv.ParseInt(req.Header.Get(signing.HeaderTimestamp), 10, 64)
+	canonical := signing.CanonicalRequest(req.Method, req.URL.RequestURI(), timestamp, req.Header.Get(signing.HeaderNonce), []byte(`{"price": 1}`))
+	req.Header.Set(signing.HeaderSignature, signing.Sign(hash, canonical))
+	_, err = verifier.Verify(req)
+	assert.ErrorIs(t, err, auth.ErrInvalidSignature, "Expected a request signed with the stored hash to be rejected")
+
+	_, err = verifier.Verify(signedRequest(t, secret, `{"price": 1}`))
+	assert.NoError(t, err, "Expected a request signed with the secret to verify")
+}
@@ -0,0 +1,72 @@
+package auth_test
+
+import (
+	"blockhouse/auth"
+	"blockhouse/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTicketSingleUse verifies a ticket redeems once, carries the issuer's identity and is
+// restricted to its stream
+func TestTicketSingleUse(t *testing.T) {
+	store := auth.NewTicketStore(time.Minute)
+	issuer := auth.Principal{ID: "reader-key", Method: auth.MethodAPIKey, Scopes: []string{models.ScopeStreamRead}}
+
+	ticket, expiresAt, err := store.Issue(issuer, "stream-1")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ticket)
+	assert.True(t, expiresAt.After(time.Now()), "Expected the ticket to expire in the future")
+
+	principal, err := store.Redeem(ticket)
+	assert.NoError(t, err, "Expected the ticket to redeem")
+	assert.Equal(t, "reader-key", principal.ID)
+	assert.Equal(t, auth.MethodTicket, principal.Method)
+	assert.Equal(t, "stream-1", principal.Stream)
+
+	_, err = store.Redeem(ticket)
+	assert.ErrorIs(t, err, auth.ErrInvalidTicket, "Expected a ticket to be single use")
+	_, err = store.Redeem("unknown")
+	assert.ErrorIs(t, err, auth.ErrInvalidTicket)
+
+	other := models.Stream{ID: "stream-2", ACL: []models.Grant{{Principal: "reader-key", Role: models.RoleConsumer}}}
+	assert.ErrorIs(t, auth.CheckStreamAccess(principal, other, auth.ActionConsume), auth.ErrAccessDenied,
+		"Expected a ticket principal to be limited to its stream")
+	own := models.Stream{ID: "stream-1", ACL: []models.Grant{{Principal: "reader-key", Role: models.RoleConsumer}}}
+	assert.NoError(t, auth.CheckStreamAccess(principal, own, auth.ActionConsume))
+}
+
+// TestTicketExpiry verifies an expired ticket is rejected
+func TestTicketExpiry(t *testing.T) {
+	store := auth.NewTicketStore(10 * time.Millisecond)
+	ticket, _, err := store.Issue(auth.Principal{ID: "reader-key"}, "stream-1")
+	assert.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+	_, err = store.Redeem(ticket)
+	assert.ErrorIs(t, err, auth.ErrInvalidTicket, "Expected an expired ticket to be rejected")
+}
+
+// setUpgrade marks a request as a WebSocket upgrade
+func setUpgrade(req *http.Request) {
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+}
+
+// TestRequestTicket verifies tickets are read from the query string or subprotocol of an upgrade only
+func TestRequestTicket(t *testing.T) {
+	req := httptest.NewRequest("GET", "/ws/stream-1?ticket=abc", nil)
+	assert.Empty(t, auth.RequestTicket(req), "Expected tickets to be ignored outside a WebSocket upgrade")
+
+	setUpgrade(req)
+	assert.Equal(t, "abc", auth.RequestTicket(req))
+
+	req = httptest.NewRequest("GET", "/ws/stream-1", nil)
+	setUpgrade(req)
+	req.Header.Set("Sec-WebSocket-Protocol", "blockhouse, ticket.xyz")
+	assert.Equal(t, "xyz", auth.RequestTicket(req))
+}
@@ -0,0 +1,52 @@
+package clientip_test
+
+import (
+	"blockhouse/clientip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// request builds a request arriving from remoteAddr with the given headers
+func request(remoteAddr string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remoteAddr
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	return r
+}
+
+// TestClientIP verifies ports are stripped and forwarding headers are only believed from
+// trusted proxies
+func TestClientIP(t *testing.T) {
+	res, err := clientip.NewResolver([]string{"10.0.0.0/8", "2001:db8::1"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "192.0.2.1", res.ClientIP(request("192.0.2.1:50001", nil)), "Expected the port to be stripped")
+	assert.Equal(t, "2001:db8::2", res.ClientIP(request("[2001:db8::2]:50001", nil)))
+	assert.Equal(t, "192.0.2.1", res.ClientIP(request("[::ffff:192.0.2.1]:50001", nil)), "Expected IPv4-mapped addresses to be unmapped")
+	assert.Equal(t, "pipe", res.ClientIP(request("pipe", nil)), "Expected unparseable addresses to be kept")
+
+	spoofed := map[string]string{"X-Forwarded-For": "198.51.100.7"}
+	assert.Equal(t, "192.0.2.1", res.ClientIP(request("192.0.2.1:50001", spoofed)), "Expected headers from untrusted clients to be ignored")
+	assert.Equal(t, "198.51.100.7", res.ClientIP(request("10.1.2.3:443", spoofed)))
+
+	chain := map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.7:3000, 10.2.0.1"}
+	assert.Equal(t, "198.51.100.7", res.ClientIP(request("10.1.2.3:443", chain)), "Expected the nearest untrusted hop to win over addresses the client sent")
+	assert.Equal(t, "10.2.0.1", res.ClientIP(request("10.1.2.3:443", map[string]string{"X-Forwarded-For": "unknown, 10.2.0.1"})), "Expected a malformed hop to end the chain")
+
+	forwarded := map[string]string{
+		"Forwarded":       `for=203.0.113.9;proto=https, for="[2001:db8:cafe::17]:4711";by=10.2.0.1`,
+		"X-Forwarded-For": "198.51.100.7",
+	}
+	assert.Equal(t, "2001:db8:cafe::17", res.ClientIP(request("[2001:db8::1]:443", forwarded)), "Expected Forwarded to take precedence over X-Forwarded-For")
+	assert.Equal(t, "10.1.2.3", res.ClientIP(request("10.1.2.3:443", map[string]string{"Forwarded": "for=_hidden"})))
+
+	_, err = clientip.NewResolver([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = clientip.NewResolver([]string{"proxy.internal"})
+	assert.Error(t, err)
+}
@@ -3,15 +3,31 @@ package handlers_test
 import (
 	"blockhouse/api"
 	"blockhouse/api/handlers"
+	"blockhouse/api/middleware"
+	"blockhouse/audit"
+	"blockhouse/auth"
+	"blockhouse/client"
+	"blockhouse/config"
+	"blockhouse/idempotency"
+	"blockhouse/loadshed"
+	"blockhouse/models"
+	"blockhouse/quotas"
+	"blockhouse/ratelimit"
+	"blockhouse/registry"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
 )
@@ -49,22 +65,86 @@ func TestStartStreamHandler(t *testing.T) {
 	assert.Contains(t, rr.Body.String(), "stream_id", "Response should contain stream_id field")
 }
 
+// TestStartStreamWithConfig validates that topic settings are applied and echoed back
+func TestStartStreamWithConfig(t *testing.T) {
+	loadEnv(t)
+
+	body := bytes.NewBufferString(`{"partitions": 3, "retention_ms": 3600000, "cleanup_policy": "delete"}`)
+	req, err := http.NewRequest(http.MethodPost, "/stream/start", body)
+	assert.NoError(t, err, "Failed to create POST request for /stream/start")
+	req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(handlers.StartStream).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 status code for stream creation")
+	var response handlers.StreamResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response), "Failed to decode StartStream response")
+	assert.Equal(t, 3, response.Config.Partitions, "Expected requested partition count to be echoed")
+	assert.Equal(t, 1, response.Config.ReplicationFactor, "Expected default replication factor")
+	assert.Equal(t, int64(3600000), response.Config.RetentionMs, "Expected requested retention to be echoed")
+}
+
+// TestStartStreamInvalidConfig validates that bad topic settings are rejected before reaching Kafka
+func TestStartStreamInvalidConfig(t *testing.T) {
+	loadEnv(t)
+
+	for _, body := range []string{
+		`{"partitions": -1}`,
+		`{"cleanup_policy": "archive"}`,
+		`{"unknown_setting": true}`,
+		`{"ttl": "forever"}`,
+		`{"schema": {"type": 12}}`,
+		`{"schema_compatibility": "sideways"}`,
+		`{"encoding": "xml"}`,
+		`{"token_ttl": "soon"}`,
+		`not json`,
+	} {
+		req, err := http.NewRequest(http.MethodPost, "/stream/start", bytes.NewBufferString(body))
+		assert.NoError(t, err, "Failed to create POST request for /stream/start")
+		req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(handlers.StartStream).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected 400 status code for body %s", body)
+	}
+}
+
+// startStream creates a stream through the router and returns the StartStream response
+func startStream(t *testing.T, router http.Handler) handlers.StreamResponse {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, "/stream/start", nil)
+	assert.NoError(t, err, "Failed to create POST request for /stream/start")
+	req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 status code for stream creation")
+
+	var response handlers.StreamResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response), "Failed to decode StartStream response")
+	return response
+}
+
 // TestSendDataHandler validates sending data to an existing stream
 func TestSendDataHandler(t *testing.T) {
 	loadEnv(t)
 	router := api.SetupRoutes()
+	stream := startStream(t, router)
+	streamID := stream.StreamID
 
 	// Prepare JSON payload
 	payload := map[string]interface{}{"key": "value"}
 	payloadBytes, err := json.Marshal(payload)
 	assert.NoError(t, err, "Failed to marshal payload for /stream/{stream_id}/send")
 
-	req, err := http.NewRequest(http.MethodPost, "/stream/test-stream-id/send", bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequest(http.MethodPost, "/stream/"+streamID+"/send", bytes.NewBuffer(payloadBytes))
 	assert.NoError(t, err, "Failed to create POST request for /stream/{stream_id}/send")
 
 	// Set necessary headers
 	req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
-	req.Header.Set("X-Stream-ID", "test-stream-id")
+	req.Header.Set("X-Stream-Token", stream.Tokens.Producer)
 	req.Header.Set("Content-Type", "application/json")
 
 	rr := httptest.NewRecorder()
@@ -73,3 +153,817 @@ func TestSendDataHandler(t *testing.T) {
 	assert.Equal(t, http.StatusAccepted, rr.Code, "Expected 202 status code for data sending")
 	assert.Contains(t, rr.Body.String(), "data accepted", "Response should confirm data acceptance")
 }
+
+// TestSendDataUnknownStream validates that data sent to an unregistered stream is rejected
+func TestSendDataUnknownStream(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+
+	req, err := http.NewRequest(http.MethodPost, "/stream/unknown-stream-id/send", bytes.NewBufferString(`{"key":"value"}`))
+	assert.NoError(t, err, "Failed to create POST request for /stream/{stream_id}/send")
+	req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Expected 404 status code for unknown stream")
+}
+
+// TestStreamLifecycle validates listing, fetching and deleting a registered stream
+func TestStreamLifecycle(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+	streamID := startStream(t, router).StreamID
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, nil)
+		assert.NoError(t, err, "Failed to create %s request for %s", method, path)
+		req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve(http.MethodGet, "/stream")
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 status code for stream listing")
+	assert.Contains(t, rr.Body.String(), streamID, "Stream listing should include the new stream")
+
+	rr = serve(http.MethodGet, "/stream/"+streamID)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 status code for stream lookup")
+	assert.Contains(t, rr.Body.String(), `"status":"active"`, "New stream should be active")
+
+	rr = serve(http.MethodDelete, "/stream/"+streamID)
+	assert.Equal(t, http.StatusNoContent, rr.Code, "Expected 204 status code for stream deletion")
+
+	rr = serve(http.MethodGet, "/stream/"+streamID)
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Deleted stream should no longer be found")
+}
+
+// TestSoftDeleteAndRestore validates that a soft-deleted stream rejects data until it is restored
+func TestSoftDeleteAndRestore(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+	stream := startStream(t, router)
+	streamID := stream.StreamID
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+		assert.NoError(t, err, "Failed to create %s request for %s", method, path)
+		req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+		req.Header.Set("X-Stream-Token", stream.Tokens.Producer)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve(http.MethodDelete, "/stream/"+streamID+"?grace=1h", "")
+	assert.Equal(t, http.StatusAccepted, rr.Code, "Expected 202 status code for soft deletion")
+	assert.Contains(t, rr.Body.String(), `"status":"pending_deletion"`, "Soft-deleted stream should be pending deletion")
+
+	rr = serve(http.MethodPost, "/stream/"+streamID+"/send", `{"key":"value"}`)
+	assert.Equal(t, http.StatusGone, rr.Code, "Expected 410 status code when sending to a soft-deleted stream")
+
+	rr = serve(http.MethodPost, "/stream/"+streamID+"/restore", "")
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 status code for stream restore")
+
+	rr = serve(http.MethodPost, "/stream/"+streamID+"/send", `{"key":"value"}`)
+	assert.Equal(t, http.StatusAccepted, rr.Code, "Expected 202 status code after restore")
+}
+
+// TestSendDataSchemaViolation validates that payloads failing the stream schema are rejected with 422
+func TestSendDataSchemaViolation(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+
+	body := bytes.NewBufferString(`{"schema": {"type": "object", "required": ["price"], "properties": {"price": {"type": "number"}}}}`)
+	req, err := http.NewRequest(http.MethodPost, "/stream/start", body)
+	assert.NoError(t, err, "Failed to create POST request for /stream/start")
+	req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 status code for stream creation")
+
+	var response handlers.StreamResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response), "Failed to decode StartStream response")
+	assert.Equal(t, 1, response.SchemaVersion, "Expected the schema to be registered as version 1")
+
+	req, err = http.NewRequest(http.MethodPost, "/stream/"+response.StreamID+"/send", bytes.NewBufferString(`{"price": "high"}`))
+	assert.NoError(t, err, "Failed to create POST request for /stream/{stream_id}/send")
+	req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+	req.Header.Set("X-Stream-Token", response.Tokens.Producer)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code, "Expected 422 status code for a non-conforming payload")
+	assert.Contains(t, rr.Body.String(), "/price", "Expected the violation to point at the offending field")
+}
+
+// TestAPIKeyScopes validates that admin-issued keys are limited to their scopes and rejected once revoked
+func TestAPIKeyScopes(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+
+	serve := func(method, path, apiKey, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+		assert.NoError(t, err, "Failed to create %s request for %s", method, path)
+		req.Header.Set("X-API-Key", apiKey)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve(http.MethodPost, "/admin/keys", os.Getenv("API_KEY"), `{"name": "reader", "scopes": ["stream:read"]}`)
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 status code for key creation")
+	var created handlers.CreateKeyResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&created), "Failed to decode CreateKey response")
+	assert.Empty(t, created.Key.Hash, "Key hashes should not be returned")
+
+	rr = serve(http.MethodGet, "/stream", created.Secret, "")
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected a stream:read key to list streams")
+
+	rr = serve(http.MethodPost, "/stream/start", created.Secret, "")
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Expected a stream:read key to be refused stream creation")
+
+	rr = serve(http.MethodGet, "/admin/keys", created.Secret, "")
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Expected a non-admin key to be refused key management")
+
+	rr = serve(http.MethodPost, "/admin/keys", os.Getenv("API_KEY"), `{"name": "bad", "scopes": ["everything"]}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected unknown scopes to be rejected")
+
+	rr = serve(http.MethodDelete, "/admin/keys/"+created.Key.ID, os.Getenv("API_KEY"), "")
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 status code for key revocation")
+
+	rr = serve(http.MethodGet, "/stream", created.Secret, "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected a revoked key to be rejected")
+}
+
+// TestStreamTokens validates that data endpoints require a token for the right stream and use,
+// and that revoking a stream's tokens rejects the ones issued before
+func TestStreamTokens(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+	stream := startStream(t, router)
+	other := startStream(t, router)
+
+	serve := func(method, path, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(`{"key":"value"}`))
+		assert.NoError(t, err, "Failed to create %s request for %s", method, path)
+		req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+		if token != "" {
+			req.Header.Set("X-Stream-Token", token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	sendPath := "/stream/" + stream.StreamID + "/send"
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, sendPath, "").Code, "Expected a missing token to be rejected")
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, sendPath, stream.Tokens.Consumer).Code, "Expected a consumer token to be refused for sending")
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, sendPath, other.Tokens.Producer).Code, "Expected another stream's token to be rejected")
+	assert.Equal(t, http.StatusAccepted, serve(http.MethodPost, sendPath, stream.Tokens.Producer).Code, "Expected the producer token to be accepted")
+
+	rr := serve(http.MethodDelete, "/stream/"+stream.StreamID+"/tokens", "")
+	assert.Equal(t, http.StatusNoContent, rr.Code, "Expected 204 status code for token revocation")
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, sendPath, stream.Tokens.Producer).Code, "Expected a revoked token to be rejected")
+
+	rr = serve(http.MethodPost, "/stream/"+stream.StreamID+"/tokens", "")
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 status code for token issue")
+	var tokens handlers.StreamTokens
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&tokens), "Failed to decode IssueStreamTokens response")
+	assert.Equal(t, http.StatusAccepted, serve(http.MethodPost, sendPath, tokens.Producer).Code, "Expected a newly issued token to be accepted")
+}
+
+// TestSignedRequests validates that a request signed with an API key is accepted without the key itself
+func TestSignedRequests(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+
+	req, err := http.NewRequest(http.MethodGet, "/stream", nil)
+	assert.NoError(t, err, "Failed to create GET request for /stream")
+	assert.NoError(t, client.SignRequest(req, os.Getenv("API_KEY")), "Failed to sign request")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected a signed request to be accepted")
+
+	// The same signature cannot be replayed
+	replay, err := http.NewRequest(http.MethodGet, "/stream", nil)
+	assert.NoError(t, err, "Failed to create GET request for /stream")
+	replay.Header = req.Header.Clone()
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, replay)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected a replayed request to be rejected")
+}
+
+// TestStreamACL validates that principals only reach streams they hold a role on, and that
+// owners can grant and revoke roles for other principals
+func TestStreamACL(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+
+	serve := func(method, path, apiKey, token, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+		assert.NoError(t, err, "Failed to create %s request for %s", method, path)
+		req.Header.Set("X-API-Key", apiKey)
+		if token != "" {
+			req.Header.Set("X-Stream-Token", token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	createKey := func(name string, scopes ...string) handlers.CreateKeyResponse {
+		body, _ := json.Marshal(handlers.CreateKeyRequest{Name: name, Scopes: scopes})
+		rr := serve(http.MethodPost, "/admin/keys", os.Getenv("API_KEY"), "", string(body))
+		assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 status code for key creation")
+		var created handlers.CreateKeyResponse
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&created), "Failed to decode CreateKey response")
+		return created
+	}
+	owner := createKey("team-a", models.ScopeStreamCreate, models.ScopeStreamWrite, models.ScopeStreamRead)
+	reader := createKey("team-b", models.ScopeStreamRead)
+	writer := createKey("team-c", models.ScopeStreamWrite)
+
+	// Register the stream directly so the test does not need a Kafka topic
+	streamID := uuid.New().String()
+	assert.NoError(t, registry.Default().Register(models.Stream{ID: streamID, Owner: owner.Key.ID, CreatedAt: time.Now()}))
+	streamPath := "/stream/" + streamID
+
+	rr := serve(http.MethodGet, streamPath, reader.Secret, "", "")
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Expected a principal without a role to be refused")
+	assert.Contains(t, rr.Body.String(), "has no role on stream", "Expected the denial to explain why")
+	rr = serve(http.MethodGet, "/stream", reader.Secret, "", "")
+	assert.NotContains(t, rr.Body.String(), streamID, "Expected streams without a role to be hidden from the listing")
+
+	rr = serve(http.MethodPost, streamPath+"/tokens", owner.Secret, "", "")
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected the owner to be issued tokens")
+	var ownerTokens handlers.StreamTokens
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&ownerTokens), "Failed to decode IssueStreamTokens response")
+	rr = serve(http.MethodPost, streamPath+"/send", writer.Secret, ownerTokens.Producer, `{"key":"value"}`)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Expected a valid token alone not to grant access")
+	assert.Contains(t, rr.Body.String(), "producer, owner, admin", "Expected the denial to name the roles that may produce")
+
+	rr = serve(http.MethodPost, streamPath+"/acl", reader.Secret, "", `{"principal": "`+reader.Key.ID+`", "role": "owner"}`)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Expected a reader to be refused ACL changes")
+	rr = serve(http.MethodPost, streamPath+"/acl", owner.Secret, "", `{"principal": "`+reader.Key.ID+`", "role": "admin"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected the admin role not to be grantable")
+	rr = serve(http.MethodPost, streamPath+"/acl", owner.Secret, "", `{"principal": "`+reader.Key.ID+`", "role": "consumer"}`)
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected the owner to grant the consumer role")
+	var acl handlers.ACLResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&acl), "Failed to decode ACL response")
+	assert.Len(t, acl.Grants, 1)
+	assert.Equal(t, owner.Key.ID, acl.Grants[0].GrantedBy)
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, streamPath, reader.Secret, "", "").Code, "Expected a consumer to see the stream")
+	rr = serve(http.MethodPost, streamPath+"/tokens", reader.Secret, "", "")
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected a consumer to be issued its own token")
+	var readerTokens handlers.StreamTokens
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&readerTokens), "Failed to decode IssueStreamTokens response")
+	assert.NotEmpty(t, readerTokens.Consumer, "Expected a consumer token")
+	assert.Empty(t, readerTokens.Producer, "Expected no producer token for a consumer")
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodDelete, streamPath, reader.Secret, "", "").Code, "Expected a consumer to be refused deletion")
+
+	rr = serve(http.MethodDelete, streamPath+"/acl?principal="+reader.Key.ID, owner.Secret, "", "")
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected the owner to revoke the grant")
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, streamPath, reader.Secret, "", "").Code, "Expected a revoked principal to be refused")
+	rr = serve(http.MethodDelete, streamPath+"/acl?principal="+reader.Key.ID, owner.Secret, "", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Expected revoking a missing grant to return 404")
+}
+
+// TestAuditLog validates that authentication failures and key management are audited with
+// the caller and request ID, and can be queried by admins
+func TestAuditLog(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+	since := time.Now().UTC().Add(-time.Second).Format(time.RFC3339)
+
+	serve := func(method, path, apiKey, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+		assert.NoError(t, err, "Failed to create %s request for %s", method, path)
+		req.Header.Set("X-API-Key", apiKey)
+		req.Header.Set("X-Request-ID", "audit-test-"+t.Name())
+		req.RemoteAddr = "192.0.2.1:41000"
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve(http.MethodGet, "/stream", "wrong-key", "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "audit-test-"+t.Name(), rr.Header().Get("X-Request-ID"), "Expected the request ID to be echoed")
+
+	rr = serve(http.MethodPost, "/admin/keys", os.Getenv("API_KEY"), `{"name": "audited", "scopes": ["stream:read"]}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var created handlers.CreateKeyResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&created), "Failed to decode CreateKey response")
+
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/admin/audit", created.Secret, "").Code, "Expected non-admins to be refused the audit log")
+
+	query := func(params string) []audit.Event {
+		rr := serve(http.MethodGet, "/admin/audit?from="+since+params, os.Getenv("API_KEY"), "")
+		assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 status code for the audit query")
+		var response handlers.AuditLogResponse
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response), "Failed to decode GetAuditLog response")
+		return response.Events
+	}
+
+	var failure, creation *audit.Event
+	for _, event := range query("&limit=1000") {
+		event := event
+		switch {
+		case event.Type == audit.EventAuthFailure && event.RequestID == "audit-test-"+t.Name():
+			failure = &event
+		case event.Type == audit.EventKeyCreate && event.Resource == created.Key.ID:
+			creation = &event
+		}
+	}
+	if assert.NotNil(t, failure, "Expected the failed authentication to be audited") {
+		assert.Equal(t, audit.OutcomeFailure, failure.Outcome)
+		assert.Equal(t, "GET /stream", failure.Request)
+		assert.Equal(t, "192.0.2.1", failure.SourceIP)
+	}
+	if assert.NotNil(t, creation, "Expected the key creation to be audited") {
+		assert.NotEmpty(t, creation.Principal, "Expected the creating admin to be recorded")
+	}
+
+	for _, event := range query("&principal=" + created.Key.ID) {
+		assert.Equal(t, created.Key.ID, event.Principal, "Expected the principal filter to apply")
+	}
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/admin/audit?from=yesterday", os.Getenv("API_KEY"), "").Code)
+}
+
+// TestWebSocketTicket validates that tickets are only issued with a consumer token and a role
+// on the stream, and that API keys are refused in the WebSocket query string
+func TestWebSocketTicket(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+
+	serve := func(method, path, apiKey, token, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+		assert.NoError(t, err, "Failed to create %s request for %s", method, path)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		if token != "" {
+			req.Header.Set("X-Stream-Token", token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	body, _ := json.Marshal(handlers.CreateKeyRequest{Name: "ws-owner", Scopes: []string{models.ScopeStreamCreate, models.ScopeStreamRead}})
+	rr := serve(http.MethodPost, "/admin/keys", os.Getenv("API_KEY"), "", string(body))
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 status code for key creation")
+	var owner handlers.CreateKeyResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&owner), "Failed to decode CreateKey response")
+
+	// Register the stream directly so the test does not need a Kafka topic
+	streamID := uuid.New().String()
+	assert.NoError(t, registry.Default().Register(models.Stream{ID: streamID, Owner: owner.Key.ID, CreatedAt: time.Now()}))
+	rr = serve(http.MethodPost, "/stream/"+streamID+"/tokens", owner.Secret, "", "")
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected the owner to be issued tokens")
+	var tokens handlers.StreamTokens
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&tokens), "Failed to decode IssueStreamTokens response")
+
+	ticketBody := `{"stream_id": "` + streamID + `"}`
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, "/ws/ticket", "", tokens.Consumer, ticketBody).Code, "Expected a ticket to require credentials")
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/ws/ticket", owner.Secret, "", ticketBody).Code, "Expected a ticket to require a consumer token")
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/ws/ticket", owner.Secret, tokens.Producer, ticketBody).Code, "Expected a producer token to be refused")
+
+	rr = serve(http.MethodPost, "/ws/ticket", owner.Secret, tokens.Consumer, ticketBody)
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected a ticket to be issued")
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+	var ticket handlers.TicketResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&ticket), "Failed to decode IssueTicket response")
+	assert.NotEmpty(t, ticket.Ticket)
+	assert.Equal(t, streamID, ticket.StreamID)
+	assert.True(t, ticket.ExpiresAt.After(time.Now()), "Expected the ticket to expire in the future")
+
+	// A client offering only the ticket subprotocol gets it selected, as browsers require
+	server := httptest.NewServer(router)
+	defer server.Close()
+	dialer := websocket.Dialer{Subprotocols: []string{auth.TicketSubprotocolPrefix + ticket.Ticket}}
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/"+streamID, nil)
+	if assert.NoError(t, err, "Expected a ticket-only handshake to succeed") {
+		assert.Equal(t, auth.TicketSubprotocolPrefix+ticket.Ticket, resp.Header.Get("Sec-WebSocket-Protocol"))
+		conn.Close()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/ws/"+streamID+"?X-API-Key="+owner.Secret+"&token="+tokens.Consumer, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected an API key in the query string to be refused")
+}
+
+// TestTenantIsolation validates that streams are invisible to other tenants and that tenant
+// stream and throughput limits are enforced
+func TestTenantIsolation(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+
+	serve := func(method, path, apiKey, token, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+		assert.NoError(t, err, "Failed to create %s request for %s", method, path)
+		req.Header.Set("X-API-Key", apiKey)
+		if token != "" {
+			req.Header.Set("X-Stream-Token", token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	tenantA, tenantB := "a-"+uuid.New().String()[:8], "b-"+uuid.New().String()[:8]
+	rr := serve(http.MethodPost, "/admin/tenants", os.Getenv("API_KEY"), "", `{"id": "`+tenantA+`", "limits": {"max_streams": 1, "messages_per_second": 1, "burst": 1}}`)
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 status code for tenant creation")
+	rr = serve(http.MethodPost, "/admin/tenants", os.Getenv("API_KEY"), "", `{"id": "`+tenantB+`"}`)
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 status code for tenant creation")
+	assert.Equal(t, http.StatusConflict, serve(http.MethodPost, "/admin/tenants", os.Getenv("API_KEY"), "", `{"id": "`+tenantB+`"}`).Code)
+
+	createKey := func(tenant string) handlers.CreateKeyResponse {
+		body, _ := json.Marshal(handlers.CreateKeyRequest{Name: tenant, Tenant: tenant, Scopes: []string{models.ScopeStreamCreate, models.ScopeStreamWrite, models.ScopeStreamRead}})
+		rr := serve(http.MethodPost, "/admin/keys", os.Getenv("API_KEY"), "", string(body))
+		assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 status code for key creation")
+		var created handlers.CreateKeyResponse
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&created), "Failed to decode CreateKey response")
+		assert.Equal(t, tenant, created.Key.Tenant)
+		return created
+	}
+	keyA, keyB := createKey(tenantA), createKey(tenantB)
+	body, _ := json.Marshal(handlers.CreateKeyRequest{Name: "orphan", Tenant: "missing", Scopes: []string{models.ScopeStreamRead}})
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/admin/keys", os.Getenv("API_KEY"), "", string(body)).Code, "Expected keys for unknown tenants to be refused")
+
+	// Register the stream directly so the test does not need a Kafka topic
+	streamID := uuid.New().String()
+	assert.NoError(t, registry.Default().Register(models.Stream{ID: streamID, Tenant: tenantA, Owner: keyA.Key.ID, CreatedAt: time.Now()}))
+	streamPath := "/stream/" + streamID
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, streamPath, keyA.Secret, "", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, streamPath, keyB.Secret, "", "").Code, "Expected another tenant's stream to look missing")
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, streamPath, os.Getenv("API_KEY"), "", "").Code, "Expected admins of another tenant to be refused too")
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, streamPath+"/tokens", keyB.Secret, "", "").Code)
+	assert.NotContains(t, serve(http.MethodGet, "/stream", keyB.Secret, "", "").Body.String(), streamID, "Expected another tenant's streams to be hidden from the listing")
+
+	rr = serve(http.MethodPost, "/stream/start", keyA.Secret, "", "")
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Expected the tenant's stream limit to be enforced")
+	assert.Contains(t, rr.Body.String(), "stream limit")
+
+	rr = serve(http.MethodPost, streamPath+"/tokens", keyA.Secret, "", "")
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var tokens handlers.StreamTokens
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&tokens), "Failed to decode IssueStreamTokens response")
+	// Without a reachable broker the write fails and its bytes must not be counted
+	rr = serve(http.MethodPost, streamPath+"/send?ack=all", keyA.Secret, tokens.Producer, `{"key":"value"}`)
+	var written int64
+	if rr.Code == http.StatusOK {
+		written = int64(len(`{"key":"value"}`))
+	} else {
+		assert.Contains(t, []int{http.StatusBadGateway, http.StatusGatewayTimeout}, rr.Code, "Expected the write to be acknowledged or to fail")
+	}
+	rr = serve(http.MethodPost, streamPath+"/send", keyA.Secret, tokens.Producer, `{"key":"value"}`)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "Expected the tenant's throughput limit to be enforced")
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+
+	rr = serve(http.MethodGet, "/admin/tenants/"+tenantA, os.Getenv("API_KEY"), "", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var tenant handlers.TenantResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&tenant), "Failed to decode GetTenant response")
+	assert.Equal(t, 1, tenant.Usage.Streams)
+	assert.Equal(t, written, tenant.Usage.WrittenBytes, "Expected only acknowledged bytes to be counted")
+
+	rr = serve(http.MethodPut, "/admin/tenants/"+tenantA+"/limits", os.Getenv("API_KEY"), "", `{"max_streams": 2}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPut, "/admin/tenants/missing/limits", os.Getenv("API_KEY"), "", `{}`).Code)
+}
+
+// TestRateLimitPolicies validates that admins can replace and reset the rate limit policies at
+// runtime and that tier policies apply to the callers of tenants in that tier
+func TestRateLimitPolicies(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+	router.Use(middleware.RateLimitMiddleware(middleware.NewPolicyRateLimiter(ratelimit.NewMemoryBackend(), ratelimit.DefaultEngine(), config.ClientKeyIP, true)))
+
+	serve := func(method, path, apiKey, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+		assert.NoError(t, err, "Failed to create %s request for %s", method, path)
+		req.Header.Set("X-API-Key", apiKey)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder) handlers.RateLimitsResponse {
+		var response handlers.RateLimitsResponse
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response), "Failed to decode rate limit policies")
+		return response
+	}
+
+	rr := serve(http.MethodGet, "/admin/ratelimits", os.Getenv("API_KEY"), "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "config", decode(rr).Source)
+
+	tenantID := "tier-" + uuid.New().String()[:8]
+	rr = serve(http.MethodPost, "/admin/tenants", os.Getenv("API_KEY"), `{"id": "`+tenantID+`", "tier": "Gold"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected malformed tiers to be refused")
+	rr = serve(http.MethodPost, "/admin/tenants", os.Getenv("API_KEY"), `{"id": "`+tenantID+`"}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	rr = serve(http.MethodPut, "/admin/tenants/"+tenantID+"/tier", os.Getenv("API_KEY"), `{"tier": "free"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var tenant handlers.TenantResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&tenant), "Failed to decode SetTenantTier response")
+	assert.Equal(t, "free", tenant.Tier)
+
+	body, _ := json.Marshal(handlers.CreateKeyRequest{Name: tenantID, Tenant: tenantID, Scopes: []string{models.ScopeStreamRead}})
+	rr = serve(http.MethodPost, "/admin/keys", os.Getenv("API_KEY"), string(body))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var key handlers.CreateKeyResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&key), "Failed to decode CreateKey response")
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPut, "/admin/ratelimits", os.Getenv("API_KEY"), `{"default": {"rate": 0, "burst": 0}}`).Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPut, "/admin/ratelimits", key.Secret, `{"default": {"rate": 100, "burst": 100}}`).Code, "Expected non-admins to be refused")
+	rr = serve(http.MethodPut, "/admin/ratelimits", os.Getenv("API_KEY"), `{"default": {"rate": 100, "burst": 100}, "policies": [{"name": "free", "tier": "free", "route": "/stream", "per": "tenant", "rate": 1, "burst": 1}]}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "runtime", decode(rr).Source)
+
+	rr = serve(http.MethodGet, "/stream", key.Secret, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("X-RateLimit-Limit"), "Expected the tier policy to apply")
+	rr = serve(http.MethodGet, "/stream", key.Secret, "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	rr = serve(http.MethodGet, "/stream", os.Getenv("API_KEY"), "")
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected callers outside the tier to use the default limit")
+	assert.Equal(t, "100", rr.Header().Get("X-RateLimit-Limit"))
+
+	rr = serve(http.MethodDelete, "/admin/ratelimits", os.Getenv("API_KEY"), "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "config", decode(rr).Source)
+}
+
+// TestSendDataLoadShedding validates that writes are refused with 503 and Retry-After while the
+// producer has as many writes in flight as it allows
+func TestSendDataLoadShedding(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+
+	streamID := uuid.New().String()
+	assert.NoError(t, registry.Default().Register(models.Stream{ID: streamID, CreatedAt: time.Now()}))
+	req, _ := http.NewRequest(http.MethodPost, "/stream/"+streamID+"/tokens", bytes.NewBufferString(""))
+	req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var tokens handlers.StreamTokens
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&tokens), "Failed to decode IssueStreamTokens response")
+
+	// Occupy every slot the producer allows
+	var held []loadshed.Ticket
+	for {
+		ticket, ok := loadshed.Default().Acquire()
+		if !ok {
+			break
+		}
+		held = append(held, ticket)
+	}
+	send := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/stream/"+streamID+"/send", bytes.NewBufferString(`{"key":"value"}`))
+		req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+		req.Header.Set("X-Stream-Token", tokens.Producer)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	rr = send()
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code, "Expected writes to be shed while the producer is saturated")
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	for _, ticket := range held {
+		ticket.Cancel()
+	}
+	assert.Equal(t, http.StatusAccepted, send().Code, "Expected writes to be accepted once slots are free")
+}
+
+// TestSendDataBodyLimit validates that oversized payloads are refused with 413 and that admins
+// can read a principal's byte quota usage
+func TestSendDataBodyLimit(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+	serve := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+		if token != "" {
+			req.Header.Set("X-Stream-Token", token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	streamID := uuid.New().String()
+	assert.NoError(t, registry.Default().Register(models.Stream{ID: streamID, CreatedAt: time.Now()}))
+	rr := serve(http.MethodPost, "/stream/"+streamID+"/tokens", "", "")
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var tokens handlers.StreamTokens
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&tokens), "Failed to decode IssueStreamTokens response")
+
+	large := `{"key":"` + strings.Repeat("x", int(quotas.Default().MaxBodyBytes())) + `"}`
+	rr = serve(http.MethodPost, "/stream/"+streamID+"/send", tokens.Producer, large)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, "Expected bodies over MAX_BODY_BYTES to be refused")
+
+	// Writes refused before they reach Kafka are not charged to any quota
+	principalID := auth.Fingerprint(os.Getenv("API_KEY"))
+	before := quotas.Default().PrincipalUsage(principalID).BytesTotal
+	unknown := uuid.New().String()
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/stream/"+unknown+"/send", tokens.Producer, `{"key":"value"}`).Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/stream/"+streamID+"/send", "not-a-token", `{"key":"value"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/stream/"+streamID+"/send", tokens.Producer, `not json`).Code)
+	assert.Equal(t, before, quotas.Default().PrincipalUsage(principalID).BytesTotal, "Expected refused writes not to be charged")
+	_, streams := quotas.Default().List()
+	for _, usage := range streams {
+		assert.NotEqual(t, unknown, usage.ID, "Expected unknown streams not to be tracked")
+	}
+
+	_, err := quotas.Default().Charge("quota-test", streamID, 42)
+	assert.NoError(t, err)
+	rr = serve(http.MethodGet, "/admin/quotas/quota-test", "", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var usage quotas.Usage
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&usage), "Failed to decode GetPrincipalQuota response")
+	assert.Equal(t, int64(42), usage.BytesToday)
+
+	rr = serve(http.MethodGet, "/admin/quotas", "", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var list handlers.QuotaListResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&list), "Failed to decode ListQuotas response")
+	assert.NotEmpty(t, list.Principals)
+	assert.NotEmpty(t, list.Streams)
+}
+
+// TestSendBatch validates batch ingestion of JSON arrays and NDJSON in both partial failure modes
+func TestSendBatch(t *testing.T) {
+	loadEnv(t)
+	t.Setenv("BATCH_MAX_RECORDS", "3")
+	router := api.SetupRoutes()
+
+	streamID := uuid.New().String()
+	assert.NoError(t, registry.Default().Register(models.Stream{ID: streamID, CreatedAt: time.Now()}))
+	req, _ := http.NewRequest(http.MethodPost, "/stream/"+streamID+"/tokens", bytes.NewBufferString(""))
+	req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var tokens handlers.StreamTokens
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&tokens), "Failed to decode IssueStreamTokens response")
+
+	send := func(query, contentType, body string) (*httptest.ResponseRecorder, handlers.SendBatchResponse) {
+		req, _ := http.NewRequest(http.MethodPost, "/stream/"+streamID+"/send/batch"+query, bytes.NewBufferString(body))
+		req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+		req.Header.Set("X-Stream-Token", tokens.Producer)
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var response handlers.SendBatchResponse
+		if rr.Header().Get("Content-Type") == "application/json" {
+			assert.NoError(t, json.NewDecoder(bytes.NewReader(rr.Body.Bytes())).Decode(&response), "Failed to decode SendBatch response")
+		}
+		return rr, response
+	}
+
+	rr, response := send("", "application/json", `[{"price": 1}, {"price": 2}]`)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Equal(t, config.BatchModeAllOrNothing, response.Mode)
+	assert.Equal(t, 2, response.Accepted)
+
+	rr, response = send("", "application/json", `[{"price": 1}, "tick", {"price": 3}]`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code, "Expected one invalid record to reject the whole batch")
+	assert.Equal(t, 0, response.Accepted)
+	assert.Equal(t, 1, response.Rejected)
+	if assert.Len(t, response.Results, 3) {
+		assert.Equal(t, handlers.RecordSkipped, response.Results[0].Status)
+		assert.Equal(t, handlers.RecordRejected, response.Results[1].Status)
+		assert.NotEmpty(t, response.Results[1].Reason)
+	}
+
+	rr, response = send("?mode=best_effort", "application/x-ndjson", "{\"price\": 1}\nnot json\n\n{\"price\": 3}\n")
+	assert.Equal(t, http.StatusAccepted, rr.Code, "Expected valid records to be sent in best effort mode")
+	assert.Equal(t, "partially accepted", response.Status)
+	assert.Equal(t, 2, response.Accepted)
+	if assert.Len(t, response.Results, 3) {
+		assert.Equal(t, handlers.RecordAccepted, response.Results[0].Status)
+		assert.Equal(t, handlers.RecordRejected, response.Results[1].Status)
+		assert.Equal(t, handlers.RecordAccepted, response.Results[2].Status)
+	}
+
+	rr, _ = send("?mode=best_effort", "application/json", `[{}, "tick"]`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code, "Expected a batch without valid records to be rejected")
+
+	rr, _ = send("", "application/json", `[{"a": 1}, {"a": 2}, {"a": 3}, {"a": 4}]`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, "Expected batches over BATCH_MAX_RECORDS to be refused")
+
+	rr, _ = send("?mode=some", "application/json", `[{"a": 1}]`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr, _ = send("", "application/json", `{"a": 1}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected a body that is not an array to be refused")
+	rr, _ = send("", "application/json", `[]`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected an empty batch to be refused")
+}
+
+// TestSendDataSynchronous validates that ?ack=all reports the real produce error instead of
+// accepting the write blindly
+func TestSendDataSynchronous(t *testing.T) {
+	loadEnv(t)
+	t.Setenv("KAFKA_BROKER", "127.0.0.1:1")
+	t.Setenv("PRODUCE_ACK_TIMEOUT", "2s")
+	router := api.SetupRoutes()
+
+	streamID := uuid.New().String()
+	assert.NoError(t, registry.Default().Register(models.Stream{ID: streamID, CreatedAt: time.Now()}))
+	req, _ := http.NewRequest(http.MethodPost, "/stream/"+streamID+"/tokens", bytes.NewBufferString(""))
+	req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var tokens handlers.StreamTokens
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&tokens), "Failed to decode IssueStreamTokens response")
+
+	send := func(path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/stream/"+streamID+path, bytes.NewBufferString(body))
+		req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+		req.Header.Set("X-Stream-Token", tokens.Producer)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusBadRequest, send("/send?ack=leader", `{"key":"value"}`).Code, "Expected unknown ack modes to be refused")
+
+	// Asynchronous writes left by other tests hold slots in the shared limiter until they end
+	assert.Eventually(t, func() bool { return loadshed.Default().InFlight() == 0 }, 30*time.Second, 50*time.Millisecond, "Expected earlier writes to finish")
+	limit := loadshed.Default().Limit()
+	rr = send("/send?ack=all", `{"key":"value"}`)
+	assert.Contains(t, []int{http.StatusBadGateway, http.StatusGatewayTimeout}, rr.Code, "Expected an unreachable broker to fail a synchronous write")
+	assert.NotContains(t, rr.Body.String(), "data accepted")
+	rr = send("/send/batch?ack=all", `[{"key":"value"}]`)
+	assert.Contains(t, []int{http.StatusBadGateway, http.StatusGatewayTimeout}, rr.Code, "Expected an unreachable broker to fail a synchronous batch")
+
+	assert.Zero(t, loadshed.Default().InFlight(), "Expected every slot taken by the failed writes to be released")
+	assert.LessOrEqual(t, loadshed.Default().Limit(), limit)
+}
+
+// TestSendDataIdempotencyKey validates that a write retried with the same Idempotency-Key gets
+// the original response
+func TestSendDataIdempotencyKey(t *testing.T) {
+	loadEnv(t)
+	router := api.SetupRoutes()
+	router.Use(middleware.IdempotencyMiddleware(idempotency.New(time.Hour, 0), quotas.Default().MaxBodyBytes()))
+
+	streamID := uuid.New().String()
+	assert.NoError(t, registry.Default().Register(models.Stream{ID: streamID, CreatedAt: time.Now()}))
+	req, _ := http.NewRequest(http.MethodPost, "/stream/"+streamID+"/tokens", bytes.NewBufferString(""))
+	req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var tokens handlers.StreamTokens
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&tokens), "Failed to decode IssueStreamTokens response")
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/stream/"+streamID+"/send", bytes.NewBufferString(body))
+		req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+		req.Header.Set("X-Stream-Token", tokens.Producer)
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr = send("tick-1", `{"price": 1}`)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Empty(t, rr.Header().Get("Idempotent-Replayed"))
+	// The key is held until the write reaches Kafka, then replayed, or released if the write fails
+	rr = send("tick-1", `{"price": 1}`)
+	assert.Contains(t, []int{http.StatusAccepted, http.StatusConflict}, rr.Code, "Expected a retry never to be refused outright")
+	assert.Equal(t, http.StatusUnprocessableEntity, send("tick-1", `{"price": 2}`).Code, "Expected a key reused for another payload to be refused")
+
+	rr = send("tick-2", `not json`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = send("tick-2", `not json`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Empty(t, rr.Header().Get("Idempotent-Replayed"), "Expected failed writes not to be remembered")
+
+	assert.Equal(t, http.StatusBadRequest, send(strings.Repeat("k", 256), `{"price": 1}`).Code, "Expected overlong keys to be refused")
+}
+
+// TestJanitorInterval verifies a non-positive janitor interval falls back instead of panicking
+func TestJanitorInterval(t *testing.T) {
+	t.Setenv("STREAM_JANITOR_INTERVAL", "0s")
+	assert.Equal(t, time.Minute, config.GetEnvInterval("STREAM_JANITOR_INTERVAL", time.Minute), "Expected a zero interval to fall back")
+	t.Setenv("STREAM_JANITOR_INTERVAL", "-5s")
+	assert.Equal(t, time.Minute, config.GetEnvInterval("STREAM_JANITOR_INTERVAL", time.Minute), "Expected a negative interval to fall back")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NotPanics(t, func() { handlers.StartJanitor(ctx, 0) }, "Expected a zero interval to disable the janitor")
+}
@@ -0,0 +1,143 @@
+package idempotency_test
+
+import (
+	"blockhouse/api/middleware"
+	"blockhouse/idempotency"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIdempotencyCache verifies completed keys are replayed, running and reused keys are
+// refused, and released keys can be claimed again
+func TestIdempotencyCache(t *testing.T) {
+	cache := idempotency.New(time.Hour, 0)
+
+	replay, err := cache.Begin("key-1", "body-a")
+	assert.NoError(t, err)
+	assert.Nil(t, replay, "Expected the first request to own the key")
+
+	_, err = cache.Begin("key-1", "body-a")
+	assert.ErrorIs(t, err, idempotency.ErrInProgress, "Expected a retry to wait for the first request")
+	_, err = cache.Begin("key-1", "body-b")
+	assert.ErrorIs(t, err, idempotency.ErrKeyReused, "Expected a different request with the same key to be refused")
+
+	cache.Complete("key-1", idempotency.Response{Status: 202, ContentType: "application/json", Body: []byte(`{"status":"data accepted"}`)})
+	replay, err = cache.Begin("key-1", "body-a")
+	assert.NoError(t, err)
+	if assert.NotNil(t, replay, "Expected a completed key to be replayed") {
+		assert.Equal(t, 202, replay.Status)
+		assert.Equal(t, `{"status":"data accepted"}`, string(replay.Body))
+	}
+
+	_, err = cache.Begin("key-2", "body-a")
+	assert.NoError(t, err)
+	cache.Release("key-2")
+	replay, err = cache.Begin("key-2", "body-c")
+	assert.NoError(t, err, "Expected a released key to be claimed again")
+	assert.Nil(t, replay)
+	assert.Equal(t, 2, cache.Len())
+}
+
+// TestIdempotencyExpiry verifies keys are forgotten after the window, and the oldest ones early
+// when the cache is full
+func TestIdempotencyExpiry(t *testing.T) {
+	cache := idempotency.New(50*time.Millisecond, 0)
+	_, err := cache.Begin("key-1", "body-a")
+	assert.NoError(t, err)
+	cache.Complete("key-1", idempotency.Response{Status: 200})
+	time.Sleep(60 * time.Millisecond)
+	replay, err := cache.Begin("key-1", "body-b")
+	assert.NoError(t, err, "Expected an expired key to be reusable")
+	assert.Nil(t, replay)
+
+	bounded := idempotency.New(time.Hour, 2)
+	for _, key := range []string{"key-1", "key-2", "key-3"} {
+		_, err := bounded.Begin(key, "body")
+		assert.NoError(t, err)
+		bounded.Complete(key, idempotency.Response{Status: 200})
+	}
+	assert.Equal(t, 2, bounded.Len())
+	replay, err = bounded.Begin("key-1", "body")
+	assert.NoError(t, err)
+	assert.Nil(t, replay, "Expected the oldest key to be forgotten to make room")
+	replay, err = bounded.Begin("key-3", "body")
+	assert.NoError(t, err)
+	assert.NotNil(t, replay, "Expected the newest key to be kept")
+}
+
+// TestDeferredCompletion verifies a key whose handler deferred its work completes only once the
+// work succeeds, and is released when it fails
+func TestDeferredCompletion(t *testing.T) {
+	cache := idempotency.New(time.Hour, 0)
+	accepted := idempotency.Response{Status: http.StatusAccepted, Body: []byte(`{"status":"data accepted"}`)}
+
+	_, err := cache.Begin("key-1", "body")
+	assert.NoError(t, err)
+	ctx, respond := cache.Track(context.Background(), "key-1")
+	done := idempotency.Defer(ctx)
+	respond(accepted)
+	_, err = cache.Begin("key-1", "body")
+	assert.ErrorIs(t, err, idempotency.ErrInProgress, "Expected the key to stay in progress until the work ends")
+	done(errors.New("broker unavailable"))
+	replay, err := cache.Begin("key-1", "body")
+	assert.NoError(t, err, "Expected a key whose work failed to be claimed again")
+	assert.Nil(t, replay)
+
+	_, err = cache.Begin("key-2", "body")
+	assert.NoError(t, err)
+	ctx, respond = cache.Track(context.Background(), "key-2")
+	done = idempotency.Defer(ctx)
+	done(nil)
+	respond(accepted)
+	replay, err = cache.Begin("key-2", "body")
+	assert.NoError(t, err)
+	assert.NotNil(t, replay, "Expected a key whose work succeeded to be replayed")
+
+	idempotency.Defer(context.Background())(nil)
+}
+
+// TestIdempotencyMiddleware verifies retries are replayed by the handler only after its own
+// access checks pass
+func TestIdempotencyMiddleware(t *testing.T) {
+	writes := 0
+	router := mux.NewRouter()
+	router.HandleFunc("/stream/{stream_id}/send", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Stream-Token") != "producer" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if idempotency.Replay(w, r) {
+			return
+		}
+		writes++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status":"data accepted"}`))
+	}).Name(middleware.RouteSendData)
+	router.Use(middleware.IdempotencyMiddleware(idempotency.New(time.Hour, 0), 1024))
+
+	send := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/stream/s1/send", strings.NewReader(`{"price": 1}`))
+		r.Header.Set("Idempotency-Key", "tick-1")
+		r.Header.Set("X-Stream-Token", token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusAccepted, send("producer").Code)
+	rr := send("producer")
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Equal(t, "true", rr.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, `{"status":"data accepted"}`, rr.Body.String())
+	assert.Equal(t, 1, writes, "Expected the retry not to write again")
+	assert.Equal(t, http.StatusForbidden, send("revoked").Code, "Expected replays to check access again")
+}
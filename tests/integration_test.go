@@ -25,8 +25,8 @@ func loadEnvForIntegrationTests(t *testing.T) {
 	}
 }
 
-// Helper function to create a new stream and return its ID
-func createStream(t *testing.T) string {
+// Helper function to create a new stream and return its ID and access tokens
+func createStream(t *testing.T) (string, handlers.StreamTokens) {
 	loadEnvForIntegrationTests(t)
 
 	// Initialize the router
@@ -46,14 +46,14 @@ func createStream(t *testing.T) string {
 	if rr.Code != http.StatusCreated {
 		t.Fatalf("Failed to start stream, got status code: %v", rr.Code)
 	}
-	var response map[string]string
+	var response handlers.StreamResponse
 	json.NewDecoder(rr.Body).Decode(&response)
-	return response["stream_id"]
+	return response.StreamID, response.Tokens
 }
 
 func TestIntegrationStreamingData(t *testing.T) {
 	// Use createStream to set up a valid stream ID
-	streamID := createStream(t)
+	streamID, tokens := createStream(t)
 
 	// Initialize the router
 	router := api.SetupRoutes()
@@ -66,7 +66,7 @@ func TestIntegrationStreamingData(t *testing.T) {
 		t.Fatalf("Error creating send data request: %v", err)
 	}
 	req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
-	req.Header.Set("X-Stream-ID", streamID)
+	req.Header.Set("X-Stream-Token", tokens.Producer)
 	req.Header.Set("Content-Type", "application/json")
 
 	rr := httptest.NewRecorder()
@@ -85,7 +85,7 @@ func TestWebSocketStreaming(t *testing.T) {
 	loadEnvForIntegrationTests(t)
 
 	// Use createStream to get a valid stream ID
-	streamID := createStream(t)
+	streamID, tokens := createStream(t)
 
 	// Set up WebSocket server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -94,11 +94,27 @@ func TestWebSocketStreaming(t *testing.T) {
 	}))
 	defer server.Close()
 
-	// Construct WebSocket URL with API Key and Stream ID as query parameters
-	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + streamID + "?X-API-Key=" + os.Getenv("API_KEY") + "&X-Stream-ID=" + streamID
+	// Exchange the API key and consumer token for a single-use ticket
+	req, err := http.NewRequest("POST", "/ws/ticket", strings.NewReader(`{"stream_id": "`+streamID+`"}`))
+	if err != nil {
+		t.Fatalf("Error creating ticket request: %v", err)
+	}
+	req.Header.Set("X-API-Key", os.Getenv("API_KEY"))
+	req.Header.Set("X-Stream-Token", tokens.Consumer)
+	rr := httptest.NewRecorder()
+	api.SetupRoutes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Failed to issue WebSocket ticket, got status code: %v", rr.Code)
+	}
+	var ticket handlers.TicketResponse
+	json.NewDecoder(rr.Body).Decode(&ticket)
+
+	// Construct WebSocket URL with the Stream ID as a query parameter; the ticket travels as a subprotocol
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + streamID + "?X-Stream-ID=" + streamID
+	dialer := websocket.Dialer{Subprotocols: []string{"blockhouse", "ticket." + ticket.Ticket}}
 
-	// Establish WebSocket connection using URL with query params
-	wsConn, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	// Establish WebSocket connection using the ticket
+	wsConn, resp, err := dialer.Dial(wsURL, nil)
 	if err != nil {
 		t.Fatalf("Failed to establish WebSocket connection: %v, HTTP status: %d", err, resp.StatusCode)
 	}
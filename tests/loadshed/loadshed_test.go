@@ -0,0 +1,81 @@
+package loadshed_test
+
+import (
+	"blockhouse/config"
+	"blockhouse/loadshed"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestShedding verifies writes beyond the limit are shed until a slot is released
+func TestShedding(t *testing.T) {
+	limiter := loadshed.New(config.LoadShedConfig{Enabled: true, TargetLatency: time.Hour, MinConcurrency: 1, MaxConcurrency: 2})
+
+	first, ok := limiter.Acquire()
+	assert.True(t, ok)
+	_, ok = limiter.Acquire()
+	assert.True(t, ok)
+	_, ok = limiter.Acquire()
+	assert.False(t, ok, "Expected writes beyond the limit to be shed")
+	assert.Equal(t, 2, limiter.InFlight(), "Expected shed writes not to hold a slot")
+
+	first.Cancel()
+	_, ok = limiter.Acquire()
+	assert.True(t, ok, "Expected a released slot to be reused")
+	assert.Equal(t, time.Second, limiter.RetryAfter(), "Expected clients to wait at least a second")
+
+	disabled := loadshed.New(config.LoadShedConfig{Enabled: false, TargetLatency: time.Hour, MinConcurrency: 1, MaxConcurrency: 1})
+	for i := 0; i < 5; i++ {
+		_, ok = disabled.Acquire()
+		assert.True(t, ok, "Expected a disabled limiter never to shed")
+	}
+}
+
+// TestAdaptiveLimit verifies the limit is cut once per congestion episode and grows back only
+// while in use
+func TestAdaptiveLimit(t *testing.T) {
+	limiter := loadshed.New(config.LoadShedConfig{Enabled: true, TargetLatency: 10 * time.Millisecond, MinConcurrency: 2, MaxConcurrency: 10})
+	assert.Equal(t, 10, limiter.Limit(), "Expected the limit to start at the maximum")
+
+	slow, _ := limiter.Acquire()
+	alsoSlow, _ := limiter.Acquire()
+	time.Sleep(20 * time.Millisecond)
+	slow.Done(nil)
+	assert.Equal(t, 8, limiter.Limit(), "Expected a slow write to cut the limit")
+	alsoSlow.Done(nil)
+	assert.Equal(t, 8, limiter.Limit(), "Expected writes from the same episode not to cut it again")
+
+	failed, _ := limiter.Acquire()
+	failed.Done(errors.New("broker timeout"))
+	assert.Equal(t, 6, limiter.Limit(), "Expected a failed write to cut the limit")
+
+	for i := 0; i < 50; i++ {
+		fast, _ := limiter.Acquire()
+		fast.Done(nil)
+	}
+	assert.Equal(t, 6, limiter.Limit(), "Expected the limit not to grow while mostly idle")
+
+	for i := 0; i < 20; i++ {
+		tickets := make([]loadshed.Ticket, 0, 5)
+		for j := 0; j < 5; j++ {
+			ticket, ok := limiter.Acquire()
+			if ok {
+				tickets = append(tickets, ticket)
+			}
+		}
+		for _, ticket := range tickets {
+			ticket.Done(nil)
+		}
+	}
+	assert.Greater(t, limiter.Limit(), 6, "Expected fast writes to raise the limit while it is in use")
+	assert.LessOrEqual(t, limiter.Limit(), 10)
+
+	for i := 0; i < 20; i++ {
+		ticket, _ := limiter.Acquire()
+		ticket.Done(errors.New("broker timeout"))
+	}
+	assert.Equal(t, 2, limiter.Limit(), "Expected the limit never to fall below the minimum")
+}
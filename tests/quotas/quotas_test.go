@@ -0,0 +1,110 @@
+package quotas_test
+
+import (
+	"blockhouse/api/middleware"
+	"blockhouse/config"
+	"blockhouse/quotas"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestByteQuotas verifies bytes per second and per day are enforced per principal and per stream
+func TestByteQuotas(t *testing.T) {
+	manager := quotas.New(config.QuotaConfig{MaxBodyBytes: 10, PrincipalBytesPerSecond: 100, PrincipalBytesPerDay: 150, StreamBytesPerDay: 50})
+
+	_, err := manager.Charge("key-1", "", 60)
+	assert.NoError(t, err)
+	retryAfter, err := manager.Charge("key-1", "", 60)
+	assert.ErrorIs(t, err, quotas.ErrRateExceeded, "Expected the bytes per second quota to be enforced")
+	assert.Greater(t, retryAfter, time.Duration(0))
+	_, err = manager.Charge("key-2", "", 60)
+	assert.NoError(t, err, "Expected principals to have separate quotas")
+
+	_, err = manager.Charge("key-3", "stream-1", 40)
+	assert.NoError(t, err)
+	retryAfter, err = manager.Charge("key-3", "stream-1", 20)
+	assert.ErrorIs(t, err, quotas.ErrDailyExceeded, "Expected the stream's daily quota to be enforced")
+	assert.Greater(t, retryAfter, time.Duration(0))
+	assert.LessOrEqual(t, retryAfter, 24*time.Hour, "Expected daily quotas to reset at midnight UTC")
+	_, err = manager.Charge("key-3", "", 60)
+	assert.NoError(t, err, "Expected a refused write not to use up the principal's rate")
+
+	usage := manager.PrincipalUsage("key-3")
+	assert.Equal(t, int64(100), usage.BytesToday)
+	assert.Equal(t, int64(100), usage.BytesTotal)
+	assert.Equal(t, int64(2), usage.Requests)
+	assert.Equal(t, int64(1), usage.Rejected)
+	assert.Equal(t, quotas.Limits{BytesPerSecond: 100, BytesPerDay: 150}, usage.Limits)
+	assert.Equal(t, time.Now().UTC().Format(time.DateOnly), usage.Day)
+
+	time.Sleep(500 * time.Millisecond)
+	_, err = manager.Charge("key-3", "", 60)
+	assert.ErrorIs(t, err, quotas.ErrDailyExceeded, "Expected the principal's daily quota to be enforced")
+
+	principals, streams := manager.List()
+	assert.Len(t, principals, 3)
+	assert.Len(t, streams, 1)
+	manager.ForgetStream("stream-1")
+	_, streams = manager.List()
+	assert.Empty(t, streams)
+	assert.Equal(t, int64(0), manager.PrincipalUsage("key-4").BytesTotal, "Expected unknown principals to have no usage")
+}
+
+// TestQuotaRefund verifies bytes given back by a failed write count against no daily quota
+func TestQuotaRefund(t *testing.T) {
+	manager := quotas.New(config.QuotaConfig{PrincipalBytesPerDay: 100, StreamBytesPerDay: 100})
+
+	_, err := manager.Charge("key-1", "stream-1", 80)
+	assert.NoError(t, err)
+	_, err = manager.Charge("key-1", "stream-1", 40)
+	assert.ErrorIs(t, err, quotas.ErrDailyExceeded)
+
+	manager.Refund("key-1", "stream-1", 80)
+	_, err = manager.Charge("key-1", "stream-1", 40)
+	assert.NoError(t, err, "Expected refunded bytes to be available again")
+	usage := manager.PrincipalUsage("key-1")
+	assert.Equal(t, int64(40), usage.BytesToday)
+	assert.Equal(t, int64(40), usage.BytesTotal)
+	assert.Equal(t, int64(1), usage.Requests)
+	_, streams := manager.List()
+	assert.Equal(t, int64(40), streams[0].BytesToday, "Expected the stream to be refunded too")
+}
+
+// TestBodyLimitMiddleware verifies produce bodies are measured and refused when too large
+func TestBodyLimitMiddleware(t *testing.T) {
+	var received string
+	router := mux.NewRouter()
+	router.HandleFunc("/stream/{stream_id}/send", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		w.WriteHeader(http.StatusAccepted)
+	}).Name(middleware.RouteSendData)
+	router.HandleFunc("/stream/{stream_id}/schema", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	router.Use(middleware.BodyLimitMiddleware(16))
+
+	serve := func(path, body string, chunked bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if chunked {
+			r.ContentLength = -1
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve("/stream/s1/send", `{"key":"a long value"}`, false).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve("/stream/s1/send", `{"key":"a long value"}`, true).Code, "Expected bodies without a length to be measured")
+	assert.Equal(t, http.StatusOK, serve("/stream/s1/schema", `{"key":"a long value"}`, false).Code, "Expected other routes to be unaffected")
+
+	assert.Equal(t, http.StatusAccepted, serve("/stream/s1/send", `{"key":"value"}`, true).Code)
+	assert.Equal(t, `{"key":"value"}`, received, "Expected the measured body to reach the handler intact")
+}
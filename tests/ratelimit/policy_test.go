@@ -0,0 +1,102 @@
+package ratelimit_test
+
+import (
+	"blockhouse/ratelimit"
+	"blockhouse/store"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPolicyMatch verifies the first matching policy applies and counts requests by its per value
+func TestPolicyMatch(t *testing.T) {
+	set := ratelimit.PolicySet{
+		Default: ratelimit.Limit{Rate: 10, Burst: 20},
+		Policies: []ratelimit.Policy{
+			{Name: "ingest", Principal: "ingest", Route: "/stream/{stream_id}/send", Per: ratelimit.PerPrincipal, Limit: ratelimit.Limit{Rate: 1000, Burst: 2000}},
+			{Name: "gold", Tier: "gold", Per: ratelimit.PerTenant, Limit: ratelimit.Limit{Rate: 500, Burst: 500}},
+			{Name: "hot", Stream: "hot", Per: ratelimit.PerStream, Limit: ratelimit.Limit{Rate: 50, Burst: 50}},
+			{Name: "send", Route: "/stream/{stream_id}/send", Method: "post", Per: ratelimit.PerPrincipal, Limit: ratelimit.Limit{Rate: 100, Burst: 200}},
+			{Name: "start", Route: "/stream/start", Per: ratelimit.PerGlobal, Limit: ratelimit.Limit{Rate: 1, Burst: 5}},
+		},
+	}
+	assert.NoError(t, set.Validate())
+	assert.True(t, set.UsesTier())
+
+	send := ratelimit.Request{Client: "10.0.0.1:5000", Principal: "key-1", Name: "ingest", Tenant: "acme", Route: "/stream/{stream_id}/send", Method: "POST", Stream: "hot"}
+	policy := set.Match(send)
+	assert.Equal(t, "ingest", policy.Name, "Expected a principal to be matched by key name")
+	assert.Equal(t, "policy:ingest:principal:key-1", policy.Key(send))
+
+	send.Name = "other"
+	policy = set.Match(send)
+	assert.Equal(t, "hot", policy.Name, "Expected earlier policies to win")
+	assert.Equal(t, "policy:hot:stream:hot", policy.Key(send))
+
+	send.Stream = "cold"
+	assert.Equal(t, "send", set.Match(send).Name, "Expected methods to match regardless of case")
+	send.Tier = "gold"
+	policy = set.Match(send)
+	assert.Equal(t, "gold", policy.Name)
+	assert.Equal(t, "policy:gold:tenant:acme", policy.Key(send))
+
+	start := ratelimit.Request{Client: "10.0.0.2:5000", Route: "/stream/start", Method: "POST"}
+	assert.Equal(t, "policy:start:global", set.Match(start).Key(start))
+
+	anonymous := ratelimit.Request{Client: "10.0.0.3:5000", Route: "/stream/{stream_id}/send", Method: "POST"}
+	policy = set.Match(anonymous)
+	assert.Equal(t, "send", policy.Name)
+	assert.Equal(t, "policy:send:client:10.0.0.3:5000", policy.Key(anonymous), "Expected anonymous callers to be counted per client")
+
+	other := ratelimit.Request{Client: "10.0.0.4:5000", Route: "/stream", Method: "GET"}
+	policy = set.Match(other)
+	assert.Equal(t, ratelimit.DefaultPolicy, policy.Name)
+	assert.Equal(t, ratelimit.Limit{Rate: 10, Burst: 20}, policy.Limit)
+	assert.Equal(t, "policy:default:client:10.0.0.4:5000", policy.Key(other))
+
+	for _, invalid := range []ratelimit.PolicySet{
+		{},
+		{Default: set.Default, Policies: []ratelimit.Policy{{Name: "a", Limit: ratelimit.Limit{Rate: 1}}}},
+		{Default: set.Default, Policies: []ratelimit.Policy{{Name: "", Limit: set.Default}}},
+		{Default: set.Default, Policies: []ratelimit.Policy{{Name: "default", Limit: set.Default}}},
+		{Default: set.Default, Policies: []ratelimit.Policy{{Name: "a", Limit: set.Default}, {Name: "a", Limit: set.Default}}},
+		{Default: set.Default, Policies: []ratelimit.Policy{{Name: "a", Per: "region", Limit: set.Default}}},
+	} {
+		assert.ErrorIs(t, invalid.Validate(), ratelimit.ErrInvalidPolicy, "Expected %+v to be refused", invalid)
+	}
+}
+
+// TestPolicyEngine verifies policies set at runtime replace the configured ones, survive a
+// restart and can be reset
+func TestPolicyEngine(t *testing.T) {
+	s := store.NewMemoryStore()
+	base := ratelimit.PolicySet{Default: ratelimit.Limit{Rate: 10, Burst: 20}}
+	engine, err := ratelimit.NewEngine(s, base)
+	assert.NoError(t, err)
+	policies, override := engine.Policies()
+	assert.False(t, override)
+	assert.Empty(t, policies.Policies)
+
+	runtime := ratelimit.PolicySet{
+		Default:  ratelimit.Limit{Rate: 5, Burst: 5},
+		Policies: []ratelimit.Policy{{Name: "start", Route: "/stream/start", Limit: ratelimit.Limit{Rate: 1, Burst: 1}}},
+	}
+	assert.ErrorIs(t, engine.Set(ratelimit.PolicySet{}), ratelimit.ErrInvalidPolicy)
+	assert.NoError(t, engine.Set(runtime))
+	assert.Equal(t, "start", engine.Match(ratelimit.Request{Route: "/stream/start"}).Name)
+
+	restarted, err := ratelimit.NewEngine(s, base)
+	assert.NoError(t, err)
+	policies, override = restarted.Policies()
+	assert.True(t, override, "Expected runtime policies to survive a restart")
+	assert.Equal(t, runtime, policies)
+
+	assert.NoError(t, restarted.Reset())
+	policies, override = restarted.Policies()
+	assert.False(t, override)
+	assert.Equal(t, base.Default, policies.Default)
+	restarted, err = ratelimit.NewEngine(s, base)
+	assert.NoError(t, err)
+	_, override = restarted.Policies()
+	assert.False(t, override, "Expected a reset to be persisted")
+}
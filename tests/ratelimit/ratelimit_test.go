@@ -0,0 +1,212 @@
+package ratelimit_test
+
+import (
+	"blockhouse/api/middleware"
+	"blockhouse/auth"
+	"blockhouse/config"
+	"blockhouse/ratelimit"
+	"blockhouse/store"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMemoryBackend verifies the in-memory token bucket allows a burst, then refuses with a retry delay
+func TestMemoryBackend(t *testing.T) {
+	backend := ratelimit.NewMemoryBackend()
+	limit := ratelimit.Limit{Rate: 1, Burst: 3}
+	ctx := context.Background()
+
+	for i := 2; i >= 0; i-- {
+		result, err := backend.Allow(ctx, "client-a", limit)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed, "Expected requests within the burst to be allowed")
+		assert.Equal(t, i, result.Remaining)
+	}
+	result, err := backend.Allow(ctx, "client-a", limit)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed, "Expected the request beyond the burst to be refused")
+	assert.InDelta(t, time.Second, result.RetryAfter, float64(50*time.Millisecond))
+
+	result, err = backend.Allow(ctx, "client-b", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed, "Expected clients to have separate buckets")
+}
+
+// TestMemoryBackendEviction verifies idle buckets are dropped and the number of buckets is bounded
+func TestMemoryBackendEviction(t *testing.T) {
+	backend := ratelimit.NewBoundedMemoryBackend(2, 50*time.Millisecond)
+	limit := ratelimit.Limit{Rate: 1, Burst: 1}
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "a", "c"} {
+		_, err := backend.Allow(ctx, key, limit)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, backend.Len(), "Expected the backend to stay within its size")
+	result, err := backend.Allow(ctx, "a", limit)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed, "Expected the most recently used bucket to be kept")
+	result, err = backend.Allow(ctx, "b", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed, "Expected the least recently used bucket to have been evicted")
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = backend.Allow(ctx, "d", limit)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, backend.Len(), "Expected idle buckets to be evicted")
+	assert.NoError(t, backend.Close())
+	assert.Equal(t, 0, backend.Len())
+}
+
+// TestRedisBackend verifies the Redis backend enforces one limit across instances and refills over time
+func TestRedisBackend(t *testing.T) {
+	server := miniredis.RunT(t)
+	now := time.Now()
+	server.SetTime(now)
+
+	first := ratelimit.NewRedisBackend(redis.NewClient(&redis.Options{Addr: server.Addr()}))
+	defer first.Close()
+	second, err := ratelimit.Open(config.RateLimitConfig{Backend: "redis", RedisURL: "redis://" + server.Addr() + "/0"})
+	assert.NoError(t, err)
+	defer second.Close()
+
+	limit := ratelimit.Limit{Rate: 2, Burst: 2}
+	ctx := context.Background()
+	result, err := first.Allow(ctx, "client-a", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+	result, err = second.Allow(ctx, "client-a", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	result, err = first.Allow(ctx, "client-a", limit)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed, "Expected the burst to be shared by both instances")
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+	assert.Equal(t, time.Second, result.ResetAfter)
+
+	server.SetTime(now.Add(500 * time.Millisecond))
+	result, err = second.Allow(ctx, "client-a", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed, "Expected the bucket to refill at the configured rate")
+	assert.Equal(t, 0, result.Remaining)
+
+	result, err = first.Allow(ctx, "client-b", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed, "Expected clients to have separate buckets")
+
+	server.Close()
+	_, err = first.Allow(ctx, "client-a", limit)
+	assert.Error(t, err, "Expected an unreachable server to be reported")
+
+	_, err = ratelimit.Open(config.RateLimitConfig{Backend: "memcached"})
+	assert.Error(t, err)
+}
+
+// TestRateLimitFailMode verifies requests are let through or refused while the backend is down
+func TestRateLimitFailMode(t *testing.T) {
+	server := miniredis.RunT(t)
+	backend := ratelimit.NewRedisBackend(redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1}))
+	defer backend.Close()
+	server.Close()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	for _, tc := range []struct {
+		failOpen bool
+		status   int
+	}{{true, http.StatusOK}, {false, http.StatusTooManyRequests}} {
+		handler := middleware.RateLimitMiddleware(middleware.NewBackendRateLimiter(backend, 10, 20, tc.failOpen))(ok)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(t, tc.status, rr.Code, "Unexpected status with fail open %t", tc.failOpen)
+	}
+}
+
+// TestRateLimitPolicies verifies the middleware applies per-route policies, reports them in
+// headers and picks up policies changed at runtime
+func TestRateLimitPolicies(t *testing.T) {
+	policies, err := ratelimit.NewEngine(store.NewMemoryStore(), ratelimit.PolicySet{
+		Default:  ratelimit.Limit{Rate: 1, Burst: 1},
+		Policies: []ratelimit.Policy{{Name: "send", Route: "/stream/{stream_id}/send", Limit: ratelimit.Limit{Rate: 100, Burst: 3}}},
+	})
+	assert.NoError(t, err)
+	router := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	router.HandleFunc("/stream/start", ok)
+	router.HandleFunc("/stream/{stream_id}/send", ok)
+	router.Use(middleware.RateLimitMiddleware(middleware.NewPolicyRateLimiter(ratelimit.NewMemoryBackend(), policies, config.ClientKeyIP, true)))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, nil))
+		return rr
+	}
+
+	for i := 2; i >= 0; i-- {
+		rr := serve("/stream/s1/send")
+		assert.Equal(t, http.StatusOK, rr.Code, "Expected the send policy to allow a burst of 3")
+		assert.Equal(t, "3", rr.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, fmt.Sprint(i), rr.Header().Get("X-RateLimit-Remaining"))
+		assert.Equal(t, "1", rr.Header().Get("X-RateLimit-Reset"))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, serve("/stream/s1/send").Code)
+
+	assert.Equal(t, http.StatusOK, serve("/stream/start").Code, "Expected other routes to use the default limit")
+	rr := serve("/stream/start")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", rr.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+
+	assert.NoError(t, policies.Set(ratelimit.PolicySet{
+		Default:  ratelimit.Limit{Rate: 0.1, Burst: 1},
+		Policies: []ratelimit.Policy{{Name: "start", Route: "/stream/start", Limit: ratelimit.Limit{Rate: 100, Burst: 100}}},
+	}))
+	rr = serve("/stream/start")
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected policies changed at runtime to apply to the next request")
+	assert.Equal(t, "100", rr.Header().Get("X-RateLimit-Limit"))
+	rr = serve("/stream/s2/send")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "Expected the default bucket to keep its state across updates")
+	assert.Equal(t, "10", rr.Header().Get("Retry-After"), "Expected Retry-After to follow the policy's rate")
+}
+
+// TestRateLimitClientKey verifies clients are counted by address without the port, or by the key
+// they authenticated with
+func TestRateLimitClientKey(t *testing.T) {
+	policies, err := ratelimit.NewEngine(store.NewMemoryStore(), ratelimit.PolicySet{Default: ratelimit.Limit{Rate: 1, Burst: 1}})
+	assert.NoError(t, err)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	serve := func(handler http.Handler, remoteAddr string, principal *auth.Principal) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		if principal != nil {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), *principal))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	byIP := middleware.RateLimitMiddleware(middleware.NewPolicyRateLimiter(ratelimit.NewMemoryBackend(), policies, config.ClientKeyIP, true))(ok)
+	assert.Equal(t, http.StatusOK, serve(byIP, "192.0.2.1:50001", nil))
+	assert.Equal(t, http.StatusTooManyRequests, serve(byIP, "192.0.2.1:50002", nil), "Expected new connections from a client to share its bucket")
+	assert.Equal(t, http.StatusOK, serve(byIP, "192.0.2.2:50001", nil))
+
+	byKey := middleware.RateLimitMiddleware(middleware.NewPolicyRateLimiter(ratelimit.NewMemoryBackend(), policies, config.ClientKeyPrincipal, true))(ok)
+	first, second := &auth.Principal{ID: "key-1"}, &auth.Principal{ID: "key-2"}
+	assert.Equal(t, http.StatusOK, serve(byKey, "192.0.2.1:50001", first))
+	assert.Equal(t, http.StatusOK, serve(byKey, "192.0.2.1:50002", second), "Expected keys behind one address to be counted apart")
+	assert.Equal(t, http.StatusTooManyRequests, serve(byKey, "192.0.2.3:50001", first), "Expected a key to be counted wherever it comes from")
+	assert.Equal(t, http.StatusOK, serve(byKey, "192.0.2.1:50003", nil), "Expected anonymous requests to be counted by address")
+}
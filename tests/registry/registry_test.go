@@ -0,0 +1,189 @@
+package registry_test
+
+import (
+	"blockhouse/models"
+	"blockhouse/registry"
+	"blockhouse/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRegistryLifecycle verifies streams can be registered, listed, fetched and deleted
+func TestRegistryLifecycle(t *testing.T) {
+	reg := registry.New(store.NewMemoryStore())
+	stream := models.Stream{ID: "stream-1", Owner: "owner", CreatedAt: time.Now()}
+
+	assert.NoError(t, reg.Register(stream), "Expected no error registering a new stream")
+	assert.ErrorIs(t, reg.Register(stream), registry.ErrStreamExists, "Expected duplicate registration to fail")
+
+	got, err := reg.Get("stream-1")
+	assert.NoError(t, err, "Expected registered stream to be found")
+	assert.Equal(t, models.StreamStatusActive, got.Status, "Expected new stream to default to active")
+	streams, err := reg.List()
+	assert.NoError(t, err, "Expected no error listing streams")
+	assert.Len(t, streams, 1, "Expected one stream in the listing")
+
+	assert.NoError(t, reg.Delete("stream-1"), "Expected no error deleting the stream")
+	_, err = reg.Get("stream-1")
+	assert.ErrorIs(t, err, registry.ErrStreamNotFound, "Expected deleted stream to be hidden")
+	streams, err = reg.List()
+	assert.NoError(t, err, "Expected no error listing streams")
+	assert.Empty(t, streams, "Expected deleted stream to be excluded from the listing")
+	assert.ErrorIs(t, reg.Delete("stream-1"), registry.ErrStreamNotFound, "Expected second delete to fail")
+}
+
+// TestRegistryUnregister verifies a stream whose creation failed leaves no record behind
+func TestRegistryUnregister(t *testing.T) {
+	s := store.NewMemoryStore()
+	reg := registry.New(s)
+	assert.NoError(t, reg.Register(models.Stream{ID: "stream-1", Tenant: "team-a", CreatedAt: time.Now()}))
+	reg.AddBytes("stream-1", 10)
+
+	assert.NoError(t, reg.Unregister("stream-1"))
+	_, err := s.Get(store.StreamsBucket, "stream-1")
+	assert.ErrorIs(t, err, store.ErrNotFound, "Expected no tombstone to be kept")
+	usage, err := reg.TenantUsage("team-a")
+	assert.NoError(t, err)
+	assert.Equal(t, registry.TenantUsage{}, usage)
+	assert.NoError(t, reg.Register(models.Stream{ID: "stream-1", CreatedAt: time.Now()}), "Expected the ID to be free again")
+}
+
+// TestRegistryPurgeTombstones verifies deleted streams are forgotten once their retention ends
+func TestRegistryPurgeTombstones(t *testing.T) {
+	s := store.NewMemoryStore()
+	reg := registry.New(s)
+	assert.NoError(t, reg.Register(models.Stream{ID: "stream-1", CreatedAt: time.Now()}))
+	assert.NoError(t, reg.Register(models.Stream{ID: "stream-2", CreatedAt: time.Now()}))
+	assert.NoError(t, reg.Delete("stream-1"))
+
+	purged, err := reg.PurgeTombstones(time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Zero(t, purged, "Expected a recent tombstone to be kept")
+	assert.ErrorIs(t, reg.Register(models.Stream{ID: "stream-1", CreatedAt: time.Now()}), registry.ErrStreamExists, "Expected a kept tombstone to reserve its ID")
+
+	purged, err = reg.PurgeTombstones(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged, "Expected only the deleted stream to be purged")
+	_, err = s.Get(store.StreamsBucket, "stream-1")
+	assert.ErrorIs(t, err, store.ErrNotFound, "Expected the tombstone to be removed from the store")
+	_, err = reg.Get("stream-2")
+	assert.NoError(t, err, "Expected live streams to be kept")
+}
+
+// TestRegistrySoftDelete verifies scheduling, restoring and collecting streams pending deletion
+func TestRegistrySoftDelete(t *testing.T) {
+	reg := registry.New(store.NewMemoryStore())
+	assert.NoError(t, reg.Register(models.Stream{ID: "stream-1", CreatedAt: time.Now()}), "Expected no error registering stream-1")
+	assert.NoError(t, reg.Register(models.Stream{ID: "stream-2", CreatedAt: time.Now()}), "Expected no error registering stream-2")
+
+	_, err := reg.Restore("stream-1")
+	assert.ErrorIs(t, err, registry.ErrStreamNotPending, "Expected restoring an active stream to fail")
+
+	now := time.Now()
+	pending, err := reg.ScheduleDeletion("stream-1", now.Add(time.Hour))
+	assert.NoError(t, err, "Expected no error soft-deleting stream-1")
+	assert.Equal(t, models.StreamStatusPendingDeletion, pending.Status, "Expected stream-1 to be pending deletion")
+	_, err = reg.ScheduleDeletion("stream-2", now.Add(-time.Second))
+	assert.NoError(t, err, "Expected no error soft-deleting stream-2")
+
+	due, err := reg.DueForDeletion(now)
+	assert.NoError(t, err, "Expected no error listing due streams")
+	assert.Len(t, due, 1, "Expected only stream-2 to be due")
+	assert.Equal(t, "stream-2", due[0].ID, "Expected stream-2 to be due")
+
+	restored, err := reg.Restore("stream-1")
+	assert.NoError(t, err, "Expected no error restoring stream-1")
+	assert.Equal(t, models.StreamStatusActive, restored.Status, "Expected stream-1 to be active again")
+	assert.Nil(t, restored.DeleteAfter, "Expected restored stream to have no deletion deadline")
+}
+
+// TestRegistryExpired verifies TTL and idle expiry, including subscriber and activity handling
+func TestRegistryExpired(t *testing.T) {
+	reg := registry.New(store.NewMemoryStore())
+	now := time.Now()
+	past := now.Add(-time.Minute)
+
+	assert.NoError(t, reg.Register(models.Stream{ID: "ttl", CreatedAt: now.Add(-time.Hour), ExpiresAt: &past}), "Expected no error registering ttl stream")
+	assert.NoError(t, reg.Register(models.Stream{ID: "idle", CreatedAt: now.Add(-time.Hour), Config: models.StreamConfig{IdleTimeout: "10m"}}), "Expected no error registering idle stream")
+	assert.NoError(t, reg.Register(models.Stream{ID: "busy", CreatedAt: now.Add(-time.Hour), Config: models.StreamConfig{IdleTimeout: "10m"}}), "Expected no error registering busy stream")
+	assert.NoError(t, reg.Register(models.Stream{ID: "active", CreatedAt: now.Add(-time.Hour), Config: models.StreamConfig{IdleTimeout: "10m"}}), "Expected no error registering active stream")
+	assert.NoError(t, reg.Register(models.Stream{ID: "forever", CreatedAt: now.Add(-time.Hour)}), "Expected no error registering forever stream")
+
+	reg.Touch("active")
+	assert.NoError(t, reg.FlushActivity(), "Expected no error flushing activity")
+
+	expired, err := reg.Expired(now, func(streamID string) bool { return streamID == "busy" })
+	assert.NoError(t, err, "Expected no error listing expired streams")
+
+	reasons := map[string]string{}
+	for _, expiry := range expired {
+		reasons[expiry.Stream.ID] = expiry.Reason
+	}
+	assert.Equal(t, map[string]string{"ttl": "ttl", "idle": "idle"}, reasons, "Expected only the ttl and idle streams to expire")
+
+	stream, err := reg.Get("active")
+	assert.NoError(t, err, "Expected active stream to be found")
+	assert.NotNil(t, stream.LastActive, "Expected flushed activity to be persisted")
+}
+
+// TestRegistryGrants verifies grants are added once and removed by principal and role
+func TestRegistryGrants(t *testing.T) {
+	reg := registry.New(store.NewMemoryStore())
+	assert.NoError(t, reg.Register(models.Stream{ID: "stream-1", Owner: "owner", CreatedAt: time.Now()}))
+
+	grant := models.Grant{Principal: "jwt:alice", Role: models.RoleConsumer, GrantedAt: time.Now()}
+	_, err := reg.AddGrant("stream-1", grant)
+	assert.NoError(t, err, "Expected no error adding a grant")
+	stream, err := reg.AddGrant("stream-1", grant)
+	assert.NoError(t, err, "Expected granting the same role twice to succeed")
+	assert.Len(t, stream.ACL, 1, "Expected a repeated grant not to be duplicated")
+	stream, err = reg.AddGrant("stream-1", models.Grant{Principal: "jwt:alice", Role: models.RoleProducer})
+	assert.NoError(t, err)
+	assert.Len(t, stream.ACL, 2)
+
+	stream, err = reg.RemoveGrants("stream-1", "jwt:alice", models.RoleProducer)
+	assert.NoError(t, err, "Expected no error removing one role")
+	assert.Len(t, stream.ACL, 1)
+	assert.Equal(t, models.RoleConsumer, stream.ACL[0].Role, "Expected only the consumer grant to remain")
+	_, err = reg.RemoveGrants("stream-1", "jwt:bob", "")
+	assert.ErrorIs(t, err, registry.ErrGrantNotFound)
+	stream, err = reg.RemoveGrants("stream-1", "jwt:alice", "")
+	assert.NoError(t, err, "Expected no error removing every role")
+	assert.Empty(t, stream.ACL)
+
+	_, err = reg.AddGrant("missing", grant)
+	assert.ErrorIs(t, err, registry.ErrStreamNotFound)
+}
+
+// TestRegistryTenantUsage verifies streams and bytes written are counted per tenant, once
+// before and once after activity is flushed
+func TestRegistryTenantUsage(t *testing.T) {
+	reg := registry.New(store.NewMemoryStore())
+	assert.NoError(t, reg.Register(models.Stream{ID: "legacy", CreatedAt: time.Now()}))
+	assert.NoError(t, reg.Register(models.Stream{ID: "a-1", Tenant: "team-a", CreatedAt: time.Now()}))
+	assert.NoError(t, reg.Register(models.Stream{ID: "a-2", Tenant: "team-a", CreatedAt: time.Now()}))
+
+	reg.AddBytes("a-1", 100)
+	reg.AddBytes("a-2", 50)
+	usage, err := reg.TenantUsage("team-a")
+	assert.NoError(t, err)
+	assert.Equal(t, registry.TenantUsage{Streams: 2, WrittenBytes: 150}, usage)
+
+	assert.NoError(t, reg.FlushActivity())
+	_, err = reg.RevokeTokens("a-1")
+	assert.NoError(t, err)
+	usage, err = reg.TenantUsage("team-a")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(150), usage.WrittenBytes, "Expected flushed bytes to be counted once")
+
+	usage, err = reg.TenantUsage(models.DefaultTenant)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, usage.Streams, "Expected streams without a tenant to belong to the default tenant")
+
+	assert.NoError(t, reg.Delete("a-2"))
+	usage, err = reg.TenantUsage("team-a")
+	assert.NoError(t, err)
+	assert.Equal(t, registry.TenantUsage{Streams: 1, WrittenBytes: 100}, usage, "Expected deleted streams to stop counting")
+}
@@ -0,0 +1,142 @@
+package schemaregistry_test
+
+import (
+	"blockhouse/schemaregistry"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const tickAvro = `{
+	"type": "record",
+	"name": "Tick",
+	"fields": [
+		{"name": "symbol", "type": "string"},
+		{"name": "price", "type": "double"}
+	]
+}`
+
+const tickProto = `syntax = "proto3";
+package market;
+
+message Quote {
+	string venue = 1;
+}
+
+message Tick {
+	string symbol = 1;
+	double price = 2;
+}
+`
+
+// fakeRegistry is a minimal in-memory stand-in for the Confluent schema registry API
+func fakeRegistry(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	schemas := map[int]schemaregistry.Schema{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/subjects/"):
+			var schema schemaregistry.Schema
+			if err := json.NewDecoder(r.Body).Decode(&schema); err != nil || schema.Schema == "" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(map[string]interface{}{"error_code": 42201, "message": "Invalid schema"})
+				return
+			}
+			id := len(schemas) + 1
+			schemas[id] = schema
+			json.NewEncoder(w).Encode(map[string]int{"id": id})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/schemas/ids/"):
+			id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/schemas/ids/"))
+			schema, ok := schemas[id]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(schema)
+		default:
+			t.Errorf("unexpected schema registry request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+// TestAvroRoundTrip verifies Avro values are framed with the schema ID and decode back to JSON
+func TestAvroRoundTrip(t *testing.T) {
+	server := fakeRegistry(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	client := schemaregistry.NewClient(server.URL)
+	id, err := client.Register(ctx, "stream-1-value", schemaregistry.Schema{Schema: tickAvro})
+	assert.NoError(t, err, "Expected no error registering the Avro schema")
+
+	value, err := client.Serialize(ctx, id, "", []byte(`{"symbol": "AAPL", "price": 187.5}`))
+	assert.NoError(t, err, "Expected no error serializing a valid payload")
+	assert.True(t, schemaregistry.IsWireFormat(value), "Expected serialized value to use the wire format")
+	assert.Equal(t, byte(0), value[0], "Expected the magic byte to lead the value")
+	assert.Equal(t, uint32(id), binary.BigEndian.Uint32(value[1:5]), "Expected the schema ID in big-endian order")
+
+	// A fresh client has to resolve the schema ID through the registry
+	decoded, err := schemaregistry.NewClient(server.URL).Deserialize(ctx, value)
+	assert.NoError(t, err, "Expected no error deserializing the value")
+	assert.JSONEq(t, `{"symbol": "AAPL", "price": 187.5}`, string(decoded))
+
+	_, err = client.Serialize(ctx, id, "", []byte(`{"symbol": "AAPL"}`))
+	assert.Error(t, err, "Expected payloads missing required fields to be rejected")
+}
+
+// TestProtobufRoundTrip verifies Protobuf values carry message indexes and decode back to JSON
+func TestProtobufRoundTrip(t *testing.T) {
+	server := fakeRegistry(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	schema := schemaregistry.Schema{Schema: tickProto, SchemaType: schemaregistry.TypeProtobuf}
+	assert.NoError(t, schemaregistry.CheckSchema(schema, "market.Tick"), "Expected the Tick message to be found")
+	assert.Error(t, schemaregistry.CheckSchema(schema, "market.Missing"), "Expected unknown message types to be rejected")
+
+	client := schemaregistry.NewClient(server.URL)
+	id, err := client.Register(ctx, "stream-2-value", schema)
+	assert.NoError(t, err, "Expected no error registering the Protobuf schema")
+
+	value, err := client.Serialize(ctx, id, "market.Tick", []byte(`{"symbol": "MSFT", "price": 410.25}`))
+	assert.NoError(t, err, "Expected no error serializing a valid payload")
+	assert.Equal(t, uint32(id), binary.BigEndian.Uint32(value[1:5]), "Expected the schema ID in big-endian order")
+	assert.Equal(t, []byte{0x02, 0x02}, value[5:7], "Expected message indexes [1] for the second message")
+
+	decoded, err := schemaregistry.NewClient(server.URL).Deserialize(ctx, value)
+	assert.NoError(t, err, "Expected no error deserializing the value")
+	assert.JSONEq(t, `{"symbol": "MSFT", "price": 410.25}`, string(decoded))
+
+	_, err = client.Serialize(ctx, id, "market.Tick", []byte(`{"ticker": "MSFT"}`))
+	assert.Error(t, err, "Expected payloads with unknown fields to be rejected")
+}
+
+// TestRegistryErrors verifies rejected schemas and unreachable registries map to distinct errors
+func TestRegistryErrors(t *testing.T) {
+	server := fakeRegistry(t)
+	ctx := context.Background()
+
+	client := schemaregistry.NewClient(server.URL)
+	_, err := client.Register(ctx, "stream-3-value", schemaregistry.Schema{})
+	assert.ErrorIs(t, err, schemaregistry.ErrSchemaRejected)
+
+	server.Close()
+	_, err = client.Register(ctx, "stream-3-value", schemaregistry.Schema{Schema: tickAvro})
+	assert.ErrorIs(t, err, schemaregistry.ErrRegistryUnavailable)
+
+	_, err = client.Deserialize(ctx, []byte(`{"plain": "json"}`))
+	assert.ErrorIs(t, err, schemaregistry.ErrNotWireFormat)
+}
@@ -0,0 +1,80 @@
+package schemas_test
+
+import (
+	"blockhouse/schemas"
+	"blockhouse/store"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const tickSchema = `{
+	"type": "object",
+	"properties": {
+		"symbol": {"type": "string"},
+		"price": {"type": "number"}
+	},
+	"required": ["symbol", "price"]
+}`
+
+// TestValidatePayloads verifies payloads are checked against the latest schema with violations reported
+func TestValidatePayloads(t *testing.T) {
+	reg := schemas.New(store.NewMemoryStore())
+
+	violations, err := reg.Validate("stream-1", map[string]interface{}{"anything": true})
+	assert.NoError(t, err, "Expected no error validating a stream without a schema")
+	assert.Empty(t, violations, "Expected streams without a schema to accept any payload")
+
+	version, _, err := reg.Register("stream-1", json.RawMessage(tickSchema), "")
+	assert.NoError(t, err, "Expected no error registering the first schema version")
+	assert.Equal(t, 1, version.Version, "Expected the first schema to be version 1")
+
+	violations, err = reg.Validate("stream-1", map[string]interface{}{"symbol": "AAPL", "price": 187.5})
+	assert.NoError(t, err, "Expected no error validating a conforming payload")
+	assert.Empty(t, violations, "Expected a conforming payload to pass")
+
+	violations, err = reg.Validate("stream-1", map[string]interface{}{"symbol": 42})
+	assert.NoError(t, err, "Expected no error validating a non-conforming payload")
+	assert.Len(t, violations, 2, "Expected a missing field and a type violation")
+}
+
+// TestSchemaEvolution verifies versions are kept and compatibility modes are enforced
+func TestSchemaEvolution(t *testing.T) {
+	reg := schemas.New(store.NewMemoryStore())
+	_, _, err := reg.Register("stream-1", json.RawMessage(tickSchema), schemas.CompatibilityBackward)
+	assert.NoError(t, err, "Expected no error registering the first schema version")
+
+	// Adding a new required field breaks backward compatibility
+	breaking := `{"type": "object", "properties": {"symbol": {"type": "string"}, "price": {"type": "number"}, "venue": {"type": "string"}}, "required": ["symbol", "price", "venue"]}`
+	_, problems, err := reg.Register("stream-1", json.RawMessage(breaking), "")
+	assert.ErrorIs(t, err, schemas.ErrIncompatible, "Expected a new required field to be rejected")
+	assert.NotEmpty(t, problems, "Expected the incompatibility to be described")
+
+	// Adding an optional field is backward compatible
+	optional := `{"type": "object", "properties": {"symbol": {"type": "string"}, "price": {"type": "number"}, "venue": {"type": "string"}}, "required": ["symbol", "price"]}`
+	version, _, err := reg.Register("stream-1", json.RawMessage(optional), "")
+	assert.NoError(t, err, "Expected an optional field to be accepted")
+	assert.Equal(t, 2, version.Version, "Expected the evolved schema to be version 2")
+
+	// Dropping a required field is backward compatible but not forward compatible
+	problems, err = schemas.CheckCompatibility(json.RawMessage(optional), json.RawMessage(`{"type": "object", "required": ["symbol"]}`), schemas.CompatibilityForward)
+	assert.NoError(t, err, "Expected no error checking compatibility")
+	assert.NotEmpty(t, problems, "Expected dropping a required field to break forward compatibility")
+
+	set, err := reg.Get("stream-1")
+	assert.NoError(t, err, "Expected the schema set to be found")
+	assert.Len(t, set.Versions, 2, "Expected both accepted versions to be kept")
+	assert.Equal(t, schemas.CompatibilityBackward, set.Compatibility, "Expected the compatibility mode to be kept")
+}
+
+// TestRegisterInvalidSchema verifies malformed schemas and modes are rejected
+func TestRegisterInvalidSchema(t *testing.T) {
+	reg := schemas.New(store.NewMemoryStore())
+
+	_, _, err := reg.Register("stream-1", json.RawMessage(`{"type": 12}`), "")
+	assert.ErrorIs(t, err, schemas.ErrInvalidSchema, "Expected an invalid schema to be rejected")
+
+	_, _, err = reg.Register("stream-1", json.RawMessage(tickSchema), "sideways")
+	assert.ErrorIs(t, err, schemas.ErrInvalidCompatibility, "Expected an unknown compatibility mode to be rejected")
+}
@@ -0,0 +1,66 @@
+package store_test
+
+import (
+	"blockhouse/store"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// exerciseStore runs the basic put/get/iterate/delete contract against a store implementation
+func exerciseStore(t *testing.T, s store.Store) {
+	t.Helper()
+
+	_, err := s.Get(store.StreamsBucket, "missing")
+	assert.ErrorIs(t, err, store.ErrNotFound, "Expected ErrNotFound for a missing key")
+
+	assert.NoError(t, s.Put(store.StreamsBucket, "a", []byte("1")), "Expected no error writing key a")
+	assert.NoError(t, s.Put(store.StreamsBucket, "b", []byte("2")), "Expected no error writing key b")
+
+	value, err := s.Get(store.StreamsBucket, "a")
+	assert.NoError(t, err, "Expected key a to be readable")
+	assert.Equal(t, []byte("1"), value, "Expected stored value for key a")
+
+	seen := map[string]string{}
+	err = s.ForEach(store.StreamsBucket, func(key string, value []byte) error {
+		seen[key] = string(value)
+		return nil
+	})
+	assert.NoError(t, err, "Expected no error iterating the bucket")
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, seen, "Expected both keys during iteration")
+
+	assert.NoError(t, s.Delete(store.StreamsBucket, "a"), "Expected no error deleting key a")
+	_, err = s.Get(store.StreamsBucket, "a")
+	assert.ErrorIs(t, err, store.ErrNotFound, "Expected deleted key to be gone")
+}
+
+// TestMemoryStore verifies the in-memory store implementation
+func TestMemoryStore(t *testing.T) {
+	exerciseStore(t, store.NewMemoryStore())
+}
+
+// TestBoltStorePersists verifies the bolt store and that records survive reopening the database
+func TestBoltStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blockhouse.db")
+
+	s, err := store.Open("bolt", path)
+	assert.NoError(t, err, "Expected bolt store to open")
+	exerciseStore(t, s)
+	assert.NoError(t, store.PutJSON(s, store.CursorsBucket, "group", map[string]int{"count": 3}), "Expected no error writing cursor")
+	assert.NoError(t, s.Close(), "Expected bolt store to close cleanly")
+
+	reopened, err := store.Open("bolt", path)
+	assert.NoError(t, err, "Expected bolt store to reopen")
+	defer reopened.Close()
+
+	var cursor map[string]int
+	assert.NoError(t, store.GetJSON(reopened, store.CursorsBucket, "group", &cursor), "Expected cursor to survive reopen")
+	assert.Equal(t, 3, cursor["count"], "Expected persisted cursor value")
+}
+
+// TestOpenUnknownBackend verifies that unsupported backends are rejected
+func TestOpenUnknownBackend(t *testing.T) {
+	_, err := store.Open("cassandra", "")
+	assert.Error(t, err, "Expected an error for an unknown backend")
+}
@@ -0,0 +1,135 @@
+package tenants_test
+
+import (
+	"blockhouse/models"
+	"blockhouse/registry"
+	"blockhouse/store"
+	"blockhouse/tenants"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTenantRecords verifies tenants can be created, listed and given limits, and that the
+// default tenant always exists
+func TestTenantRecords(t *testing.T) {
+	s := store.NewMemoryStore()
+	manager := tenants.New(s, registry.New(s))
+
+	_, err := manager.Create(models.Tenant{ID: "Team A"})
+	assert.ErrorIs(t, err, tenants.ErrInvalidTenant, "Expected IDs unfit for topic names to be refused")
+	_, err = manager.Create(models.Tenant{ID: "team-a", Limits: models.TenantLimits{MaxStreams: -1}})
+	assert.ErrorIs(t, err, tenants.ErrInvalidTenant, "Expected negative limits to be refused")
+	_, err = manager.Create(models.Tenant{ID: models.DefaultTenant})
+	assert.ErrorIs(t, err, tenants.ErrTenantExists, "Expected the default tenant to exist already")
+
+	created, err := manager.Create(models.Tenant{ID: "team-a", Name: "Team A"})
+	assert.NoError(t, err)
+	assert.False(t, created.CreatedAt.IsZero())
+	_, err = manager.Create(models.Tenant{ID: "team-a"})
+	assert.ErrorIs(t, err, tenants.ErrTenantExists)
+
+	_, err = manager.Get("team-b")
+	assert.ErrorIs(t, err, tenants.ErrTenantNotFound)
+	list, err := manager.List()
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, models.DefaultTenant, list[0].ID, "Expected the default tenant to be listed first")
+
+	updated, err := manager.SetLimits(models.DefaultTenant, models.TenantLimits{MaxStreams: 5})
+	assert.NoError(t, err, "Expected the default tenant's limits to be settable")
+	assert.Equal(t, 5, updated.Limits.MaxStreams)
+	list, err = manager.List()
+	assert.NoError(t, err)
+	assert.Len(t, list, 2, "Expected the default tenant to be listed once")
+	_, err = manager.SetLimits("team-b", models.TenantLimits{})
+	assert.ErrorIs(t, err, tenants.ErrTenantNotFound)
+
+	updated, err = manager.SetTier("team-a", "gold")
+	assert.NoError(t, err)
+	assert.Equal(t, "gold", updated.Tier)
+	_, err = manager.SetTier("team-a", "Gold Plus")
+	assert.ErrorIs(t, err, tenants.ErrInvalidTenant, "Expected malformed tiers to be refused")
+	_, err = manager.SetTier("team-b", "gold")
+	assert.ErrorIs(t, err, tenants.ErrTenantNotFound)
+
+	assert.Equal(t, "team-a.stream-1", tenants.TopicName("team-a", "stream-1"))
+	assert.Equal(t, "default.stream-1", tenants.TopicName("", "stream-1"))
+}
+
+// TestReserveStreamConcurrent verifies concurrent stream creations cannot pass the stream limit
+func TestReserveStreamConcurrent(t *testing.T) {
+	s := store.NewMemoryStore()
+	manager := tenants.New(s, registry.New(s))
+	_, err := manager.Create(models.Tenant{ID: "team-a", Limits: models.TenantLimits{MaxStreams: 3}})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	var reserved atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := manager.ReserveStream("team-a"); err == nil {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), reserved.Load(), "Expected only as many reservations as the limit allows")
+}
+
+// TestTenantLimits verifies stream, throughput and write volume limits are enforced per tenant
+func TestTenantLimits(t *testing.T) {
+	s := store.NewMemoryStore()
+	streams := registry.New(s)
+	manager := tenants.New(s, streams)
+	_, err := manager.Create(models.Tenant{ID: "team-a", Limits: models.TenantLimits{MaxStreams: 1, MessagesPerSecond: 1, Burst: 2, MaxWrittenBytes: 100}})
+	assert.NoError(t, err)
+	_, err = manager.Create(models.Tenant{ID: "team-b"})
+	assert.NoError(t, err)
+
+	release, err := manager.ReserveStream("team-a")
+	assert.NoError(t, err)
+	_, err = manager.ReserveStream("team-a")
+	assert.ErrorIs(t, err, tenants.ErrStreamLimit, "Expected a stream being created to hold its slot")
+	release()
+	release()
+	release, err = manager.ReserveStream("team-a")
+	assert.NoError(t, err, "Expected a released slot to be free again, once")
+	assert.NoError(t, streams.Register(models.Stream{ID: "a-1", Tenant: "team-a", CreatedAt: time.Now()}))
+	release()
+	_, err = manager.ReserveStream("team-a")
+	assert.ErrorIs(t, err, tenants.ErrStreamLimit)
+	release, err = manager.ReserveStream("team-b")
+	assert.NoError(t, err, "Expected one tenant's streams not to count against another")
+	release()
+	_, err = manager.ReserveStream("team-c")
+	assert.ErrorIs(t, err, tenants.ErrTenantNotFound)
+
+	assert.NoError(t, manager.AllowWrite("team-a", 40))
+	assert.NoError(t, manager.AllowWrite("team-a", 40))
+	assert.ErrorIs(t, manager.AllowWrite("team-a", 10), tenants.ErrThroughputLimit, "Expected writes beyond the burst to be refused")
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, manager.AllowWrite("team-b", 1000), "Expected a tenant without limits to be unaffected")
+	}
+	assert.NoError(t, manager.AllowWriteN("team-b", 500, 1000), "Expected batches to be unaffected without limits")
+
+	batched := tenants.New(s, streams)
+	assert.ErrorIs(t, batched.AllowWriteN("team-a", 3, 10), tenants.ErrThroughputLimit, "Expected a batch larger than the burst to be refused")
+	assert.NoError(t, batched.AllowWriteN("team-a", 2, 10))
+	assert.ErrorIs(t, batched.AllowWrite("team-a", 10), tenants.ErrThroughputLimit, "Expected every message of a batch to count")
+
+	assert.NoError(t, streams.Register(models.Stream{ID: "a-2", Tenant: "team-a", CreatedAt: time.Now()}))
+	streams.AddBytes("a-2", 90)
+	limited := tenants.New(s, streams)
+	assert.ErrorIs(t, limited.AllowWrite("team-a", 20), tenants.ErrWriteVolumeLimit, "Expected bytes already written to count")
+	limited.Release("team-a", 50)
+	assert.NoError(t, limited.AllowWrite("team-a", 20), "Expected released bytes to stop counting")
+	assert.ErrorIs(t, limited.AllowWrite("team-a", 50), tenants.ErrWriteVolumeLimit)
+	limited.Refund("team-a", 20)
+	assert.NoError(t, limited.AllowWrite("team-a", 50), "Expected bytes refunded by a failed write to stop counting")
+}
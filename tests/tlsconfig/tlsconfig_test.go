@@ -0,0 +1,173 @@
+package tlsconfig_test
+
+import (
+	"blockhouse/auth"
+	"blockhouse/config"
+	"blockhouse/tlsconfig"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// issuer is a throwaway certificate authority for tests
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCA(t *testing.T) issuer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "Failed to generate CA key")
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err, "Failed to create CA certificate")
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err, "Failed to parse CA certificate")
+	return issuer{cert: cert, key: key}
+}
+
+// issue signs a leaf certificate and returns its PEM certificate and key
+func (ca issuer) issue(t *testing.T, serial int64, template *x509.Certificate) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "Failed to generate leaf key")
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err, "Failed to create leaf certificate")
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err, "Failed to marshal leaf key")
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func serverTemplate() *x509.Certificate {
+	return &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	assert.NoError(t, os.WriteFile(path, data, 0o600), "Failed to write %s", path)
+}
+
+// TestMutualTLS verifies client certificates are required, verified and used as the caller
+// identity, and that renewed server certificates are picked up without a restart
+func TestMutualTLS(t *testing.T) {
+	ca := newCA(t)
+	dir := t.TempDir()
+	cfg := config.TLSConfig{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server-key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+		ClientAuth:   "require",
+		ClientScopes: []string{"stream:read"},
+	}
+	certPEM, keyPEM := ca.issue(t, 100, serverTemplate())
+	writeFile(t, cfg.CertFile, certPEM)
+	writeFile(t, cfg.KeyFile, keyPEM)
+	writeFile(t, cfg.ClientCAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}))
+
+	reloader, err := tlsconfig.New(cfg)
+	assert.NoError(t, err, "Expected no error loading the TLS files")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 20*time.Millisecond)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := auth.Authenticate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(principal.ID))
+	}))
+	server.TLS = reloader.TLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	spiffeID, _ := url.Parse("spiffe://example.org/team/analytics")
+	clientPEM, clientKeyPEM := ca.issue(t, 200, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "analytics"},
+		URIs:        []*url.URL{spiffeID},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	clientCert, err := tls.X509KeyPair(clientPEM, clientKeyPEM)
+	assert.NoError(t, err, "Failed to load client certificate")
+
+	get := func(certs ...tls.Certificate) (*http.Response, error) {
+		transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}, DisableKeepAlives: true}
+		return (&http.Client{Transport: transport}).Get(server.URL)
+	}
+
+	resp, err := get(clientCert)
+	assert.NoError(t, err, "Expected the handshake with a client certificate to succeed")
+	body := make([]byte, 128)
+	n, _ := resp.Body.Read(body)
+	resp.Body.Close()
+	assert.Equal(t, "cert:spiffe://example.org/team/analytics", string(body[:n]), "Expected the URI SAN to identify the caller")
+	assert.Equal(t, int64(100), resp.TLS.PeerCertificates[0].SerialNumber.Int64())
+
+	_, err = get()
+	assert.Error(t, err, "Expected the handshake without a client certificate to fail")
+
+	// Renew the server certificate in place
+	certPEM, keyPEM = ca.issue(t, 101, serverTemplate())
+	writeFile(t, cfg.KeyFile, keyPEM)
+	writeFile(t, cfg.CertFile, certPEM)
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(cfg.CertFile, later, later))
+	assert.NoError(t, os.Chtimes(cfg.KeyFile, later, later))
+
+	assert.Eventually(t, func() bool {
+		resp, err := get(clientCert)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64() == 101
+	}, 2*time.Second, 20*time.Millisecond, "Expected the renewed certificate to be served")
+}
+
+// TestInvalidTLSConfig verifies misconfigurations are reported when loading
+func TestInvalidTLSConfig(t *testing.T) {
+	_, err := tlsconfig.New(config.TLSConfig{})
+	assert.Error(t, err, "Expected TLS without a certificate to be refused")
+
+	_, err = tlsconfig.New(config.TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientAuth: "require"})
+	assert.Error(t, err, "Expected client verification without a CA bundle to be refused")
+
+	_, err = tlsconfig.New(config.TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientAuth: "sometimes"})
+	assert.Error(t, err, "Expected an unknown client auth mode to be refused")
+}
@@ -0,0 +1,156 @@
+package tlsconfig
+
+import (
+	"blockhouse/config"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a certificate and client CA pool loaded from files, picking up new files
+// without a restart so certificates can be renewed in place.
+type Reloader struct {
+	cfg        config.TLSConfig
+	clientAuth tls.ClientAuthType
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// New loads the files named in cfg. It fails if TLS is not configured or the files are invalid.
+func New(cfg config.TLSConfig) (*Reloader, error) {
+	if !cfg.Enabled() {
+		return nil, errors.New("TLS requires both TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	r := &Reloader{cfg: cfg}
+	switch cfg.ClientAuth {
+	case "", "none":
+		r.clientAuth = tls.NoClientCert
+	case "optional":
+		r.clientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		r.clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unsupported TLS_CLIENT_AUTH %q: expected none, optional or require", cfg.ClientAuth)
+	}
+	if r.clientAuth != tls.NoClientCert && cfg.ClientCAFile == "" {
+		return nil, errors.New("client certificate verification requires TLS_CLIENT_CA_FILE")
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// files returns the paths the reloader loads
+func (r *Reloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.clientAuth != tls.NoClientCert {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+// Reload loads the certificate, key and client CA files. On failure the previously loaded
+// material stays in use.
+func (r *Reloader) Reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.clientAuth != tls.NoClientCert {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA bundle: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA bundle %s", r.cfg.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCA = pool
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return nil
+}
+
+// changed reports whether any loaded file has a different modification time than when it was loaded
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for file, loaded := range r.modTimes {
+		info, err := os.Stat(file)
+		if err != nil {
+			// A file being replaced may briefly be missing; try again next tick
+			return false
+		}
+		if !info.ModTime().Equal(loaded) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads the files whenever they change, checking every interval until ctx is cancelled.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				log.Printf("Error reloading TLS certificates, keeping the current ones: %v", err)
+				continue
+			}
+			log.Printf("Reloaded TLS certificates from %s", r.cfg.CertFile)
+		}
+	}
+}
+
+// TLSConfig returns a server TLS configuration that always uses the latest loaded files.
+func (r *Reloader) TLSConfig() *tls.Config {
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			NextProtos:   []string{"h2", "http/1.1"},
+			Certificates: []tls.Certificate{*r.cert},
+			ClientAuth:   r.clientAuth,
+			ClientCAs:    r.clientCA,
+		}, nil
+	}
+	return base
+}